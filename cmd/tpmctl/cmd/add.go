@@ -16,6 +16,9 @@ func newAddPassphraseCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "add-passphrase",
 		Usage: "Add passphrase authentication",
+		Flags: append([]cli.Flag{
+			newForceFlag(),
+		}, newKDFFlags()...),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Ensure that the user's effective ID is root
 			if os.Geteuid() != 0 {
@@ -30,11 +33,21 @@ func newAddPassphraseCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
 			// Validate auth mode is currently none
 			if err := tpm.ValidateAuthMode(ctx, c, snapd.AuthModeNone); err != nil {
 				return err
 			}
 
+			kdf, err := parseKDFFlags(cmd)
+			if err != nil {
+				return err
+			}
+
 			newPassphrase, err := tui.ReadUserSecret("Enter new passphrase: ")
 			if err != nil {
 				return err
@@ -49,7 +62,7 @@ func newAddPassphraseCmd() *cli.Command {
 				return err
 			}
 
-			if err := tpm.AddPassphrase(ctx, c, newPassphrase); err != nil {
+			if err := tpm.AddPassphrase(ctx, c, newPassphrase, kdf); err != nil {
 				return err
 			}
 			fmt.Println("Passphrase added successfully")
@@ -63,6 +76,9 @@ func newAddPINCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "add-pin",
 		Usage: "Add PIN authentication",
+		Flags: append([]cli.Flag{
+			newForceFlag(),
+		}, newKDFFlags()...),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Ensure that the user's effective ID is root
 			if os.Geteuid() != 0 {
@@ -77,11 +93,21 @@ func newAddPINCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
 			// Validate auth mode is currently none
 			if err := tpm.ValidateAuthMode(ctx, c, snapd.AuthModeNone); err != nil {
 				return err
 			}
 
+			kdf, err := parseKDFFlags(cmd)
+			if err != nil {
+				return err
+			}
+
 			newPin, err := tui.ReadUserSecret("Enter new PIN: ")
 			if err != nil {
 				return err
@@ -95,7 +121,7 @@ func newAddPINCmd() *cli.Command {
 			if err := tpm.IsValidPIN(ctx, c, newPin, confirmPin); err != nil {
 				return err
 			}
-			if err := tpm.AddPIN(ctx, c, newPin); err != nil {
+			if err := tpm.AddPIN(ctx, c, newPin, kdf); err != nil {
 				return err
 			}
 			fmt.Println("PIN added successfully")
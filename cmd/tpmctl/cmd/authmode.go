@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
+	"snap-tpmctl/internal/tui"
+)
+
+func newSetAuthModeCmd() *cli.Command {
+	var authMode string
+
+	return &cli.Command{
+		Name:    "set-auth-mode",
+		Usage:   "Set the authentication mode of the platform keys",
+		Suggest: true,
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:        "auth-mode",
+				UsageText:   "<none|pin|passphrase>",
+				Destination: &authMode,
+			},
+		},
+		Flags: append([]cli.Flag{
+			newForceFlag(),
+		}, newKDFFlags()...),
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// Ensure that the user's effective ID is root
+			if os.Geteuid() != 0 {
+				return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+			}
+
+			mode, err := tpm.ParseAuthMode(authMode)
+			if err != nil {
+				return err
+			}
+
+			kdf, err := parseKDFFlags(cmd)
+			if err != nil {
+				return err
+			}
+
+			c := snapd.NewClient()
+			defer c.Close()
+
+			// Load auth before validation
+			if err := c.LoadAuthFromHome(); err != nil {
+				return fmt.Errorf("failed to load auth: %w", err)
+			}
+
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
+			secret, err := readNewSecret(ctx, c, mode)
+			if err != nil {
+				return err
+			}
+
+			if err := tpm.SetAuthMode(ctx, c, mode, secret, kdf); err != nil {
+				return err
+			}
+			fmt.Printf("Authentication mode set to %s successfully\n", mode)
+			return nil
+		},
+	}
+}
+
+// readNewSecret prompts for and validates the new PIN or passphrase required by the auth mode, if any.
+func readNewSecret(ctx context.Context, c *snapd.Client, mode snapd.AuthMode) (string, error) {
+	switch mode {
+	case snapd.AuthModePin:
+		pin, err := tui.ReadUserSecret("Enter new PIN: ")
+		if err != nil {
+			return "", err
+		}
+
+		confirmPin, err := tui.ReadUserSecret("Confirm new PIN: ")
+		if err != nil {
+			return "", err
+		}
+
+		if err := tpm.IsValidPIN(ctx, c, pin, confirmPin); err != nil {
+			return "", err
+		}
+		return pin, nil
+	case snapd.AuthModePassphrase:
+		passphrase, err := tui.ReadUserSecret("Enter new passphrase: ")
+		if err != nil {
+			return "", err
+		}
+
+		confirmPassphrase, err := tui.ReadUserSecret("Confirm new passphrase: ")
+		if err != nil {
+			return "", err
+		}
+
+		if err := tpm.IsValidPassphrase(ctx, c, passphrase, confirmPassphrase); err != nil {
+			return "", err
+		}
+		return passphrase, nil
+	default:
+		return "", nil
+	}
+}
+
+func newKDFBenchmarkCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "kdf-benchmark",
+		Usage:   "Measure the local cost of key derivation functions and recommend a KDF time",
+		Suggest: true,
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			fmt.Println("Benchmarking key derivation functions, this may take a few seconds...")
+
+			res, err := fde.BenchmarkKDF(ctx, fde.BenchmarkOptions{})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("argon2id: %s per iteration with %d MiB of memory and %d threads\n",
+				res.Argon2Iteration.Round(time.Millisecond), res.Argon2MemoryKiB/1024, res.Argon2Threads)
+			fmt.Printf("pbkdf2-sha256: %d iterations per second\n", res.PBKDF2IterationsPerSecond)
+			fmt.Printf("Recommended KDF time: %s (use --kdf-time %s)\n", res.RecommendedTime, res.RecommendedTime)
+
+			return nil
+		},
+	}
+}
@@ -3,12 +3,13 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
+	"os"
 
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
 	"snap-tpmctl/internal/snapd"
-	"snap-tpmctl/internal/tui"
 )
 
 func newCheckCmd() *cli.Command {
@@ -16,17 +17,24 @@ func newCheckCmd() *cli.Command {
 		Name:    "check-key",
 		Usage:   "Check recovery key",
 		Suggest: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "device",
+				Usage: "Check the key against the LUKS2 header of a device or image, without snapd",
+			},
+			newRecoveryKeyFileFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
-			key, err := tui.ReadUserSecret("Enter recovery key: ")
+			key, err := readRecoveryKey(cmd, os.Stdout)
 			if err != nil {
 				return err
 			}
 
-			if err := IsValidRecoveryKey(key); err != nil {
-				return err
+			if device := cmd.String("device"); device != "" {
+				return checkDevice(device, key)
 			}
 
-			return check(ctx, key)
+			return check(ctx, key.String())
 		},
 	}
 }
@@ -54,19 +62,29 @@ func check(ctx context.Context, key string) error {
 	return nil
 }
 
+func checkDevice(device string, key fde.RecoveryKey) error {
+	keyslot, err := fde.VerifyKey(device, key)
+	if errors.Is(err, fde.ErrKeyMismatch) {
+		fmt.Println("Recovery key does not work")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check recovery key: %w", err)
+	}
+
+	fmt.Printf("Recovery key works (LUKS2 keyslot %s)\n", keyslot)
+
+	return nil
+}
+
 // IsValidRecoveryKey checks to see if a recovery key matches expected formatting.
 func IsValidRecoveryKey(key string) error {
 	if key == "" {
 		return fmt.Errorf("recovery key cannot be empty")
 	}
 
-	matched, err := regexp.MatchString(`^([0-9]{5}-){7}[0-9]{5}$`, key)
-	if err != nil {
-		return fmt.Errorf("regex validation error: %w", err)
-	}
-
-	if !matched {
-		return fmt.Errorf("invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores")
+	if _, err := fde.ParseRecoveryKey(key); err != nil {
+		return fmt.Errorf("invalid recovery key format: %w", err)
 	}
 
 	return nil
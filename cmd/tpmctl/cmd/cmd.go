@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
 	"snap-tpmctl/internal/log"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
+	"snap-tpmctl/internal/tui"
 )
 
 /*
@@ -53,14 +61,25 @@ func newRootCmd() cli.Command {
 			newCreateEnterpriseKeyCmd(),
 			newCreateKeyCmd(),
 			newCheckCmd(),
+			newCombineKeyCmd(),
 			newEnumerateCmd(),
+			newEnterpriseCmd(),
+			newFingerprintKeyCmd(),
+			newJournalCmd(),
 			newGetLuksPassphraseCmd(),
 			newMountVolumeCmd(),
+			newUnmountVolumeCmd(),
+			newBackupHeaderCmd(),
+			newRestoreHeaderCmd(),
 			newReplacePassphraseCmd(),
 			newReplacePinCmd(),
 			newRegenerateEnterpriseKeyCmd(),
 			newRegenerateKeyCmd(),
+			newRepairCmd(),
+			newRotateKeysCmd(),
 			newStatusCmd(),
+			newSetAuthModeCmd(),
+			newKDFBenchmarkCmd(),
 			newAddPINCmd(),
 			newAddPassphraseCmd(),
 			newRemovePINCmd(),
@@ -83,6 +102,153 @@ func newRootCmd() cli.Command {
 	}
 }
 
+// newForceFlag returns the flag allowing to operate on an inconsistent keyslot state.
+func newForceFlag() *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:  "force",
+		Usage: "Proceed even if the keyslots are in an inconsistent state",
+	}
+}
+
+// newOfflineFlag returns the flag reading the keyslots from the LUKS2 headers of the system volumes instead of snapd.
+func newOfflineFlag() *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:  "offline",
+		Usage: "Read the keyslots from the LUKS2 headers of the system volumes, without snapd",
+	}
+}
+
+// newKeySlotFlag returns the repeatable flag selecting the keyslots to operate on.
+func newKeySlotFlag() *cli.StringSliceFlag {
+	return &cli.StringSliceFlag{
+		Name:  "keyslot",
+		Usage: "Keyslot to operate on as name[:container-role], can be repeated (default: the default keyslots)",
+	}
+}
+
+// newKDFFlags returns the flags tuning the key derivation function protecting the platform key.
+func newKDFFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "kdf-type",
+			Usage: "Key derivation function: argon2id, argon2i or pbkdf2 (default: snapd's choice)",
+		},
+		&cli.DurationFlag{
+			Name:  "kdf-time",
+			Usage: "Target duration of the key derivation, see kdf-benchmark (default: snapd's choice)",
+		},
+	}
+}
+
+// parseKDFFlags returns the KDF options selected with the flags of newKDFFlags.
+func parseKDFFlags(cmd *cli.Command) (snapd.KDFOptions, error) {
+	return tpm.ParseKDFOptions(cmd.String("kdf-type"), cmd.Duration("kdf-time"))
+}
+
+// newRecoveryKeyFileFlag returns the flag reading the recovery key from a file instead of prompting for it.
+func newRecoveryKeyFileFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "recovery-key-file",
+		Usage: "Read the recovery key from a file, or - for stdin, instead of prompting for it",
+	}
+}
+
+// readRecoveryKey reads and parses the recovery key selected with newRecoveryKeyFileFlag,
+// prompting for it on w if no file was given.
+func readRecoveryKey(cmd *cli.Command, w io.Writer) (fde.RecoveryKey, error) {
+	var key string
+
+	switch path := cmd.String("recovery-key-file"); path {
+	case "":
+		k, err := tui.ReadUserSecretOn(w, "Enter recovery key: ")
+		if err != nil {
+			return fde.RecoveryKey{}, err
+		}
+		key = k
+	case "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fde.RecoveryKey{}, fmt.Errorf("failed to read recovery key: %w", err)
+		}
+		key = string(data)
+	default:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fde.RecoveryKey{}, fmt.Errorf("failed to read recovery key: %w", err)
+		}
+		key = string(data)
+	}
+
+	key = strings.TrimSpace(key)
+	if err := IsValidRecoveryKey(key); err != nil {
+		return fde.RecoveryKey{}, err
+	}
+
+	return fde.ParseRecoveryKey(key)
+}
+
+// newPurposeFlag returns the flag recording why a recovery key is created in the key registry.
+func newPurposeFlag() *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:  "purpose",
+		Usage: "Why the recovery key is created, recorded in the key registry",
+	}
+}
+
+// keyMetadata returns the metadata of a key created by the command, recorded in the key registry.
+// The operator is the user who ran sudo, not root.
+func keyMetadata(cmd *cli.Command) tpm.KeyMetadata {
+	return tpm.KeyMetadata{
+		Operator: cmp.Or(os.Getenv("SUDO_USER"), os.Getenv("USER")),
+		Purpose:  cmd.String("purpose"),
+	}
+}
+
+// newVolumeSelectorFlags returns the flags selecting an encrypted volume, see resolveVolume.
+func newVolumeSelectorFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "container-role",
+			Usage: "Container role of the system volume (default: system-data)",
+		},
+		&cli.StringFlag{
+			Name:  "label",
+			Usage: "Label of the encrypted partition",
+		},
+		&cli.StringFlag{
+			Name:  "device",
+			Usage: "Encrypted block device or image",
+		},
+	}
+}
+
+// resolveVolume returns the encrypted device selected with newVolumeSelectorFlags, defaulting to system-data.
+func resolveVolume(cmd *cli.Command) (string, error) {
+	selector := fde.VolumeSelector{
+		ContainerRole: cmd.String("container-role"),
+		Label:         cmd.String("label"),
+		Device:        cmd.String("device"),
+	}
+	if selector == (fde.VolumeSelector{}) {
+		selector.ContainerRole = "system-data"
+	}
+
+	return selector.ResolveDevice(fde.DefaultByLabelDir)
+}
+
+// validateConsistency refuses to proceed on an inconsistent keyslot state unless --force was given.
+func validateConsistency(ctx context.Context, cmd *cli.Command, c *snapd.Client) error {
+	if cmd.Bool("force") {
+		return nil
+	}
+
+	if err := tpm.ValidateConsistency(ctx, c); err != nil {
+		return fmt.Errorf("%w\nrun with --force to proceed anyway", err)
+	}
+
+	return nil
+}
+
 func setupLogging(level int) {
 	switch level {
 	case 0:
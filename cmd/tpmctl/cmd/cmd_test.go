@@ -20,7 +20,7 @@ func TestIsValidRecoveryKey(t *testing.T) {
 		wantInErr string
 	}{
 		"valid recovery key": {
-			key:     "12345-67890-12345-67890-12345-67890-12345-67890",
+			key:     "12345-54321-12345-54321-12345-54321-12345-54321",
 			wantErr: false,
 		},
 		"empty key": {
@@ -29,44 +29,49 @@ func TestIsValidRecoveryKey(t *testing.T) {
 			wantInErr: "recovery key cannot be empty",
 		},
 		"key with letters": {
-			key:       "12345-67890-abcde-67890-12345-67890-12345-67890",
+			key:       "12345-54321-abcde-54321-12345-54321-12345-54321",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key too short": {
-			key:       "12345-67890-12345",
+			key:       "12345-54321-12345",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key too long": {
-			key:       "12345-67890-12345-67890-12345-67890-12345-67890-12345",
+			key:       "12345-54321-12345-54321-12345-54321-12345-54321-12345",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key with wrong separator": {
-			key:       "12345_67890_12345_67890_12345_67890_12345_67890",
+			key:       "12345_54321_12345_54321_12345_54321_12345_54321",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key with missing separator": {
-			key:       "123456789012345678901234567890123456789012345",
+			key:       "123455432112345543211234554321123455432112345",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key with four digits": {
-			key:       "1234-67890-12345-67890-12345-67890-12345-67890",
+			key:       "1234-54321-12345-54321-12345-54321-12345-54321",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 		"key with six digits": {
-			key:       "123456-67890-12345-67890-12345-67890-12345-67890",
+			key:       "123456-54321-12345-54321-12345-54321-12345-54321",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
+		},
+		"key with group out of range": {
+			key:       "12345-67890-12345-54321-12345-54321-12345-54321",
+			wantErr:   true,
+			wantInErr: `invalid recovery key format: group 2 "67890" is out of range`,
 		},
 		"key with spaces": {
-			key:       "12345 67890 12345 67890 12345 67890 12345 67890",
+			key:       "12345 54321 12345 54321 12345 54321 12345 54321",
 			wantErr:   true,
-			wantInErr: "invalid recovery key format: must contain only alphanumeric characters, hyphens, or underscores",
+			wantInErr: "invalid recovery key format",
 		},
 	}
 
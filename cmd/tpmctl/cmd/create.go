@@ -3,8 +3,10 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/enterprise"
 	"snap-tpmctl/internal/snapd"
 	"snap-tpmctl/internal/tpm"
 )
@@ -22,7 +24,28 @@ func newCreateKeyCmd() *cli.Command {
 				Destination: &recoveryKeyName,
 			},
 		},
+		Flags: append(newSplitFlags(), newPurposeFlag()),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// Ensure that the user's effective ID is root
+			if os.Geteuid() != 0 {
+				return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+			}
+
+			var threshold, shares int
+			if split := cmd.String("split"); split != "" {
+				var err error
+				if threshold, shares, err = parseSplit(split); err != nil {
+					return err
+				}
+				if dir := cmd.String("export-shares"); dir != "" {
+					if err := checkExportDir(dir); err != nil {
+						return err
+					}
+				}
+			} else if cmd.IsSet("export-shares") {
+				return fmt.Errorf("--export-shares requires --split")
+			}
+
 			c := snapd.NewClient()
 			defer c.Close()
 
@@ -36,12 +59,20 @@ func newCreateKeyCmd() *cli.Command {
 				return err
 			}
 
-			result, err := tpm.CreateKey(ctx, c, recoveryKeyName)
+			result, err := tpm.CreateKey(ctx, c, tpm.NewJournal(tpm.DefaultStateDir), tpm.NewRegistry(tpm.DefaultStateDir), recoveryKeyName, keyMetadata(cmd))
 			if err != nil {
-				return err
+				return withJournalHint(err)
 			}
 
-			fmt.Printf("Recovery Key: %s\n", result.RecoveryKey)
+			if shares > 0 {
+				if err := printKeyShares(result.RecoveryKey, recoveryKeyName, threshold, shares, cmd.String("export-shares")); err != nil {
+					// The key is already created, so show it rather than losing it.
+					fmt.Printf("Warning: cannot split the recovery key: %v\n", err)
+					fmt.Printf("Recovery Key: %s\n", result.RecoveryKey)
+				}
+			} else {
+				fmt.Printf("Recovery Key: %s\n", result.RecoveryKey)
+			}
 			fmt.Printf("Key ID: %s\n", result.KeyID)
 			fmt.Println(result.Status)
 
@@ -54,13 +85,40 @@ func newCreateEnterpriseKeyCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "create-enterprise-key",
 		Usage: "Create a new enterprise recovery key for Landscape",
+		Flags: newEscrowFlags(),
 		Action: func(ctx context.Context, cmd *cli.Command) error {
-			return createEnterpriseKey(ctx)
+			return createEnterpriseKey(ctx, cmd)
 		},
 	}
 }
 
-func createEnterpriseKey(_ context.Context) error {
-	fmt.Println("Created enterprise key")
+func createEnterpriseKey(ctx context.Context, cmd *cli.Command) error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	escrow, machine, cfg, err := newEscrowClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	// The key is only ever sent to the escrow endpoint, never shown locally.
+	res, err := enterprise.Escrow(ctx, c, escrow, enterprise.NewSpool(tpm.DefaultStateDir), machine, cfg.KeySlot)
+	if err != nil {
+		return err
+	}
+	recordEnterpriseKey(ctx, cmd, res)
+
+	printEscrowResult(res)
+	fmt.Println(res.Status)
+
 	return nil
 }
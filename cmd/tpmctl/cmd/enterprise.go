@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/log"
+	"snap-tpmctl/internal/tpm"
+)
+
+func newEnterpriseCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "enterprise",
+		Usage:   "Tools for escrowed enterprise recovery keys",
+		Suggest: true,
+		Commands: []*cli.Command{
+			{
+				Name:  "flush",
+				Usage: "Upload the escrow records spooled while the endpoint was unavailable",
+				Flags: []cli.Flag{
+					newEnterpriseConfigFlag(),
+					&cli.StringFlag{
+						Name:  "endpoint",
+						Usage: "URL of the escrow endpoint (default: from the configuration)",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "Retry all records now, ignoring the backoff of failed attempts",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return flushEscrowSpool(ctx, cmd)
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Inspect the enterprise configuration",
+				Commands: []*cli.Command{
+					{
+						Name:  "check",
+						Usage: "Validate the enterprise configuration and show where each setting comes from",
+						Flags: []cli.Flag{
+							newEnterpriseConfigFlag(),
+						},
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							return checkEnterpriseConfig(cmd.String("config"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "decrypt",
+				Usage: "Decrypt an escrowed recovery key offline with the organisation private key",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "record",
+						UsageText: "<record-file|->",
+					},
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "private-key",
+						Usage:    "PEM encoded organisation RSA private key",
+						Required: true,
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return decryptEscrowRecord(cmd.StringArg("record"), cmd.String("private-key"))
+				},
+			},
+		},
+	}
+}
+
+// flushEscrowSpool uploads the spooled escrow records. It is meant to be run periodically,
+// e.g. by a systemd timer, and only retries the records whose backoff expired.
+func flushEscrowSpool(ctx context.Context, cmd *cli.Command) error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	cfg, err := loadEnterpriseConfig(cmd)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Require(enterprise.ConfigEndpoint); err != nil {
+		return err
+	}
+
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		return err
+	}
+
+	// Spooled records are already encrypted, so the organisation key is not needed.
+	escrow, err := enterprise.NewClient(cfg.Endpoint, nil, opts...)
+	if err != nil {
+		return err
+	}
+
+	res, err := enterprise.NewSpool(tpm.DefaultStateDir).Flush(ctx, escrow, time.Now(), cmd.Bool("force"))
+	if res != nil {
+		for _, id := range res.Uploaded {
+			fmt.Printf("Uploaded escrow record %s\n", id)
+		}
+		if res.Deferred > 0 {
+			fmt.Printf("%d record(s) waiting for their next attempt\n", res.Deferred)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(res.Uploaded) == 0 && res.Deferred == 0 {
+		fmt.Println("No record pending escrow")
+	}
+
+	return nil
+}
+
+// clientCertRenewal is how long before its expiry the client certificate should be renewed.
+const clientCertRenewal = 30 * 24 * time.Hour
+
+// checkEnterpriseConfig validates the configuration and prints its settings, secrets redacted.
+func checkEnterpriseConfig(path string) error {
+	cfg, err := enterprise.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Setting", "Value", "Source")
+	for _, key := range enterprise.ConfigKeys() {
+		value, source := cfg.Get(key)
+		if err := table.Append(key, cmp.Or(value, "-"), cmp.Or(source, "-")); err != nil {
+			return fmt.Errorf("failed to append table row: %w", err)
+		}
+	}
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("failed to render table: %w", err)
+	}
+
+	landscape, err := enterprise.ReadLandscapeIdentity(enterprise.DefaultLandscapeConfigPath)
+	switch {
+	case err == nil:
+		fmt.Printf("Landscape: computer %d of account %q\n", landscape.ComputerID, landscape.AccountName)
+		if err := cfg.CheckLandscape(landscape); err != nil {
+			return err
+		}
+	case errors.Is(err, enterprise.ErrLandscapeNotConfigured):
+		fmt.Println("Landscape: not configured")
+	default:
+		fmt.Printf("Landscape: %v\n", err)
+	}
+
+	if err := cfg.Require(enterprise.ConfigEndpoint, enterprise.ConfigEscrowPublicKey); err != nil {
+		return err
+	}
+
+	// Check the TLS settings together, e.g. that the client certificate matches its key.
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		return err
+	}
+	if _, err := enterprise.NewClient(cfg.Endpoint, nil, opts...); err != nil {
+		return err
+	}
+
+	fmt.Println("Enterprise configuration is valid")
+
+	return nil
+}
+
+// printEscrowResult prints where an enterprise recovery key was escrowed. The key itself is never shown.
+func printEscrowResult(res *enterprise.EscrowResult) {
+	if res.Pending() {
+		fmt.Printf("Enterprise recovery key pending escrow, spooled as %s\n", res.SpoolID)
+		fmt.Println("It will be uploaded by 'snap-tpmctl enterprise flush' once the endpoint is reachable")
+	} else {
+		fmt.Printf("Enterprise recovery key escrowed as %s\n", res.EscrowID)
+	}
+	fmt.Printf("Key ID: %s\n", res.KeyID)
+	fmt.Printf("Keyslot: %s\n", res.KeySlot)
+}
+
+// displayEnterpriseStatus reports the expiry of the client certificate authenticating the
+// machine to the escrow endpoint, and warns about recovery keys not escrowed yet.
+func displayEnterpriseStatus(ctx context.Context) {
+	displayClientCertificate(ctx, time.Now())
+	warnPendingEscrow(ctx)
+}
+
+// displayClientCertificate prints when the configured client certificate expires, with a
+// warning when it expires soon as escrow uploads then fail.
+func displayClientCertificate(ctx context.Context, now time.Time) {
+	cfg, err := enterprise.LoadConfig(enterprise.DefaultConfigPath)
+	if err != nil {
+		log.Debugf(ctx, "Cannot read the enterprise configuration: %v", err)
+		return
+	}
+	if cfg.ClientCert == "" {
+		return
+	}
+
+	cert, err := enterprise.ReadCertificate(cfg.ClientCert)
+	if err != nil {
+		fmt.Printf("Warning: cannot read the enterprise client certificate: %v\n", err)
+		return
+	}
+
+	expiry := cert.NotAfter.Local().Format(time.DateTime)
+	switch left := cert.NotAfter.Sub(now); {
+	case left <= 0:
+		fmt.Printf("Warning: enterprise client certificate expired on %s, escrow uploads will fail\n", expiry)
+	case left < clientCertRenewal:
+		fmt.Printf("Warning: enterprise client certificate expires on %s, in %d day(s), renew it\n", expiry, int(left.Hours()/24))
+	default:
+		fmt.Printf("Enterprise client certificate expires on %s\n", expiry)
+	}
+}
+
+// recordEnterpriseKey records an escrowed key in the key registry, with the fingerprint of
+// its escrow record.
+func recordEnterpriseKey(ctx context.Context, cmd *cli.Command, res *enterprise.EscrowResult) {
+	meta := keyMetadata(cmd)
+	meta.Purpose = "escrowed enterprise recovery key"
+
+	rec := tpm.KeyRecord{
+		KeySlot:         res.KeySlot,
+		KeyID:           res.KeyID,
+		Created:         time.Now().UTC(),
+		KeyMetadata:     meta,
+		Fingerprint:     res.Fingerprint,
+		FingerprintSalt: res.FingerprintSalt,
+	}
+	if err := tpm.NewRegistry(tpm.DefaultStateDir).Record(rec); err != nil {
+		log.Warningf(ctx, "Enterprise recovery key %s could not be recorded in the key registry: %v", res.KeyID, err)
+	}
+}
+
+// warnPendingEscrow warns when enterprise recovery keys are in use but not escrowed yet.
+func warnPendingEscrow(ctx context.Context) {
+	pending, err := enterprise.NewSpool(tpm.DefaultStateDir).Pending()
+	if err != nil {
+		// The spool is root-only, so it cannot be checked by unprivileged users.
+		log.Debugf(ctx, "Cannot check the escrow spool: %v", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	fmt.Printf("Warning: %d enterprise recovery key(s) pending escrow since %s, run 'snap-tpmctl enterprise flush'\n",
+		len(pending), pending[0].Spooled.Local().Format(time.DateTime))
+}
+
+// decryptEscrowRecord prints the recovery key of an escrow record. It needs neither snapd
+// nor network access, so that it can run on an offline machine holding the private key.
+func decryptEscrowRecord(path, privateKeyPath string) error {
+	var data []byte
+	var err error
+	switch path {
+	case "":
+		return fmt.Errorf("missing escrow record file")
+	case "-":
+		data, err = io.ReadAll(os.Stdin)
+	default:
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read escrow record: %w", err)
+	}
+
+	var record enterprise.EscrowRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("invalid escrow record: %w", err)
+	}
+
+	priv, err := enterprise.LoadPrivateKey(privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := enterprise.DecryptRecord(priv, record)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recovery Key: %s\n", key)
+	fmt.Printf("Key ID: %s\n", record.KeyID)
+	fmt.Printf("Machine ID: %s\n", record.Machine.MachineID)
+
+	return nil
+}
+
+// newEnterpriseConfigFlag returns the flag selecting the enterprise configuration file.
+func newEnterpriseConfigFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "config",
+		Usage: "Enterprise configuration file, drop-ins are read from enterprise.d next to it",
+		Value: enterprise.DefaultConfigPath,
+	}
+}
+
+// newEscrowFlags returns the flags overriding where and how enterprise keys are escrowed.
+func newEscrowFlags() []cli.Flag {
+	return []cli.Flag{
+		newEnterpriseConfigFlag(),
+		&cli.StringFlag{
+			Name:  "endpoint",
+			Usage: "URL of the escrow endpoint (default: from the configuration)",
+		},
+		&cli.StringFlag{
+			Name:  "org-public-key",
+			Usage: "PEM encoded organisation RSA public key to encrypt the escrowed key to (default: from the configuration)",
+		},
+	}
+}
+
+// loadEnterpriseConfig loads the enterprise configuration, overridden by the command flags.
+func loadEnterpriseConfig(cmd *cli.Command) (*enterprise.Config, error) {
+	cfg, err := enterprise.LoadConfig(cmd.String("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]string{
+		"endpoint":       enterprise.ConfigEndpoint,
+		"org-public-key": enterprise.ConfigEscrowPublicKey,
+	}
+	for flag, key := range overrides {
+		if !cmd.IsSet(flag) {
+			continue
+		}
+		if err := cfg.Set(key, cmd.String(flag), "--"+flag); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// newEscrowClient returns the client of the escrow endpoint, the identity of this machine and the configuration.
+func newEscrowClient(ctx context.Context, cmd *cli.Command) (*enterprise.Client, enterprise.Identity, *enterprise.Config, error) {
+	cfg, err := loadEnterpriseConfig(cmd)
+	if err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+	if err := cfg.Require(enterprise.ConfigEndpoint, enterprise.ConfigEscrowPublicKey); err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+
+	orgKey, err := enterprise.LoadOrgKey(cfg.EscrowPublicKey)
+	if err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+
+	escrow, err := enterprise.NewClient(cfg.Endpoint, orgKey, opts...)
+	if err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+
+	machine, err := enterprise.ReadIdentity(enterprise.DefaultMachineIDPath)
+	if err != nil {
+		return nil, enterprise.Identity{}, nil, err
+	}
+
+	// Tie the escrowed keys to the Landscape registration of the machine, when it has one.
+	landscape, err := enterprise.ReadLandscapeIdentity(enterprise.DefaultLandscapeConfigPath)
+	switch {
+	case err == nil:
+		if err := cfg.CheckLandscape(landscape); err != nil {
+			return nil, enterprise.Identity{}, nil, err
+		}
+		machine.Landscape = landscape
+	case errors.Is(err, enterprise.ErrLandscapeNotConfigured):
+		log.Debugf(ctx, "Escrowing without Landscape identity: %v", err)
+	default:
+		log.Warningf(ctx, "Escrowing without Landscape identity: %v", err)
+	}
+
+	return escrow, machine, cfg, nil
+}
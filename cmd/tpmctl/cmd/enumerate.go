@@ -1,15 +1,20 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	sm "github.com/egregors/sortedmap"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/log"
 	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
 )
 
 func newEnumerateCmd() *cli.Command {
@@ -19,13 +24,43 @@ func newEnumerateCmd() *cli.Command {
 		Name:    "list",
 		Usage:   "Enumerate all the keyslots",
 		Suggest: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "device",
+				Usage: "Read the keyslots from the LUKS2 header of a device or image, without snapd",
+			},
+			newOfflineFlag(),
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "Also list the keyslots of the LUKS2 volumes not managed by snapd, probing every block device",
+			},
+			&cli.BoolFlag{
+				Name:  "with-metadata",
+				Usage: "Show when, by whom and why the recovery keys were created and their fingerprint, from the key registry",
+			},
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
-			return enumerate(ctx)
+			if device := cmd.String("device"); device != "" {
+				return enumerateDevice(device)
+			}
+
+			var metadata map[string]tpm.KeyRecord
+			if cmd.Bool("with-metadata") {
+				var err error
+				if metadata, err = tpm.NewRegistry(tpm.DefaultStateDir).Keys(); err != nil {
+					return err
+				}
+			}
+
+			if cmd.Bool("offline") {
+				return enumerateOffline(ctx, metadata, cmd.Bool("all"))
+			}
+			return enumerate(ctx, metadata, cmd.Bool("all"))
 		},
 	}
 }
 
-func enumerate(ctx context.Context) error {
+func enumerate(ctx context.Context, metadata map[string]tpm.KeyRecord, all bool) error {
 	c := snapd.NewClient()
 	defer c.Close()
 
@@ -38,14 +73,40 @@ func enumerate(ctx context.Context) error {
 		return err
 	}
 
-	if err = displayTable(res); err != nil {
+	if err = displayTable(res, discoverOtherVolumes(ctx, res, all), metadata); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func displayTable(data *snapd.SystemVolumesResult) error {
+// discoverOtherVolumes returns the LUKS2 volumes of the system which are not already part of the snapd result.
+// Discovery opens every block device, so it is only done when all volumes are requested.
+func discoverOtherVolumes(ctx context.Context, data *snapd.SystemVolumesResult, all bool) []fde.DiscoveredVolume {
+	if !all {
+		return nil
+	}
+
+	discovered, err := fde.DiscoverVolumes(fde.DefaultSystemPaths)
+	if err != nil {
+		log.Warningf(ctx, "Failed to discover other encrypted volumes: %v", err)
+		return nil
+	}
+
+	var others []fde.DiscoveredVolume
+	for _, v := range discovered {
+		if _, ok := data.ByContainerRole[v.ContainerRole]; ok && v.ContainerRole != "" {
+			continue
+		}
+		others = append(others, v)
+	}
+
+	return others
+}
+
+// displayTable prints the keyslots of the snapd volumes, followed by the LUKS2 keyslots of the other volumes.
+// With metadata, the keyslots are joined with their record in the key registry.
+func displayTable(data *snapd.SystemVolumesResult, others []fde.DiscoveredVolume, metadata map[string]tpm.KeyRecord) error {
 	dashIfEmpty := func(s string) string {
 		if strings.TrimSpace(s) == "" {
 			return "-"
@@ -53,8 +114,24 @@ func displayTable(data *snapd.SystemVolumesResult) error {
 		return s
 	}
 
+	header := []any{"ContainerRole", "Volume", "VolumeName", "Encrypted", "Name", "AuthMode", "PlatformName", "Roles", "Type", "ManagedBy"}
+	if metadata != nil {
+		header = append(header, "Created", "Operator", "Purpose", "Fingerprint")
+	}
+	// withMetadata appends the registry columns of the keyslot to a row, if requested.
+	withMetadata := func(keySlot string, row ...any) []any {
+		if metadata == nil {
+			return row
+		}
+		rec, ok := metadata[keySlot]
+		if !ok {
+			return append(row, "-", "-", "-", "-")
+		}
+		return append(row, rec.Created.Local().Format(time.DateTime), dashIfEmpty(rec.Operator), dashIfEmpty(rec.Purpose), dashIfEmpty(rec.Fingerprint))
+	}
+
 	table := tablewriter.NewWriter(os.Stdout)
-	table.Header("ContainerRole", "Volume", "VolumeName", "Encrypted", "Name", "AuthMode", "PlatformName", "Roles", "Type")
+	table.Header(header...)
 
 	sortedData := sm.NewFromMap(data.ByContainerRole, func(i, j sm.KV[string, snapd.VolumeInfo]) bool {
 		return i.Key < j.Key
@@ -68,40 +145,130 @@ func displayTable(data *snapd.SystemVolumesResult) error {
 		// TODO: find a better way to do this
 
 		if keyslots.Len() == 0 {
-			err := table.Append(
+			err := table.Append(withMetadata("",
 				role,
 				volume.Name,
-				volume.VolumeName,
+				dashIfEmpty(volume.VolumeName),
 				fmt.Sprintf("%v", volume.Encrypted),
 				"-",
 				"-",
 				"-",
 				"-",
 				"-",
-			)
+				fde.ManagedBySnapd,
+			)...)
 			if err != nil {
 				return fmt.Errorf("failed to append table row: %w", err)
 			}
 		}
 
 		for name, slot := range keyslots.All() {
-			err := table.Append(
+			err := table.Append(withMetadata(name,
 				role,
 				volume.Name,
-				volume.VolumeName,
+				dashIfEmpty(volume.VolumeName),
 				fmt.Sprintf("%v", volume.Encrypted),
 				dashIfEmpty(name),
 				dashIfEmpty(slot.AuthMode),
 				dashIfEmpty(slot.PlatformName),
 				dashIfEmpty(strings.Join(slot.Roles, "+")),
 				dashIfEmpty(slot.Type),
-			)
+				fde.ManagedBySnapd,
+			)...)
 			if err != nil {
 				return fmt.Errorf("failed to append table row: %w", err)
 			}
 		}
 	}
 
+	for _, v := range others {
+		volume := v.Device
+		if v.Open {
+			volume = fmt.Sprintf("%s (open as %s)", v.Device, v.DMName)
+		}
+
+		for _, id := range v.Header.Metadata.KeyslotIDs() {
+			err := table.Append(withMetadata("",
+				dashIfEmpty(v.ContainerRole),
+				volume,
+				dashIfEmpty(v.Label),
+				"true",
+				"keyslot "+id,
+				"-",
+				"-",
+				"-",
+				"-",
+				v.ManagedBy(),
+			)...)
+			if err != nil {
+				return fmt.Errorf("failed to append table row: %w", err)
+			}
+		}
+	}
+
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("failed to render table: %w", err)
+	}
+
+	return nil
+}
+
+func enumerateOffline(ctx context.Context, metadata map[string]tpm.KeyRecord, all bool) error {
+	volumes, err := fde.ReadSystemVolumes(fde.DefaultByLabelDir)
+	if err != nil {
+		return err
+	}
+
+	res := fde.SystemVolumes(volumes)
+	return displayTable(res, discoverOtherVolumes(ctx, res, all), metadata)
+}
+
+func enumerateDevice(device string) error {
+	hdr, err := fde.OpenLUKS2Header(device)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Device: %s\nUUID: %s\nLabel: %s\n", device, hdr.UUID, cmp.Or(hdr.Label, "-"))
+
+	return displayLUKS2Table(hdr.Metadata)
+}
+
+func displayLUKS2Table(md fde.LUKS2Metadata) error {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Keyslot", "Type", "KeySize", "KDF", "Priority", "Encryption", "Tokens")
+
+	for _, id := range md.KeyslotIDs() {
+		slot := md.Keyslots[id]
+
+		priority := "normal"
+		switch {
+		case slot.Priority == nil:
+		case *slot.Priority == 0:
+			priority = "ignore"
+		case *slot.Priority == 2:
+			priority = "prefer"
+		}
+
+		var tokens []string
+		for _, tid := range md.KeyslotTokens(id) {
+			tokens = append(tokens, fmt.Sprintf("%s:%s", tid, md.Tokens[tid].Type))
+		}
+
+		err := table.Append(
+			id,
+			slot.Type,
+			fmt.Sprintf("%d bits", slot.KeySize*8),
+			slot.KDF.Type,
+			priority,
+			slot.Area.Encryption,
+			cmp.Or(strings.Join(tokens, ", "), "-"),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to append table row: %w", err)
+		}
+	}
+
 	if err := table.Render(); err != nil {
 		return fmt.Errorf("failed to render table: %w", err)
 	}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/tpm"
+)
+
+func newFingerprintKeyCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "fingerprint-key",
+		Usage:   "Print the fingerprint of a recovery key, to compare it with the recorded one without disclosing the key",
+		Suggest: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "keyslot",
+				Usage: "Salt the fingerprint as the key registry record of this keyslot, and compare them",
+				Value: tpm.DefaultRecoveryKeySlot,
+			},
+			&cli.StringFlag{
+				Name:  "salt",
+				Usage: "Salt the fingerprint with this hex encoded salt, e.g. of an escrow record, instead of the key registry",
+			},
+			newRecoveryKeyFileFlag(),
+		},
+		Action: fingerprintKey,
+	}
+}
+
+func fingerprintKey(ctx context.Context, cmd *cli.Command) error {
+	if cmd.IsSet("keyslot") && cmd.IsSet("salt") {
+		return fmt.Errorf("--keyslot and --salt cannot be used together")
+	}
+
+	if s := cmd.String("salt"); s != "" {
+		salt, err := hex.DecodeString(s)
+		if err != nil || len(salt) == 0 {
+			return fmt.Errorf("invalid salt %q: expected a hex encoded value", s)
+		}
+
+		key, err := readRecoveryKey(cmd, os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Fingerprint: %s\n", fde.Fingerprint(key, salt))
+		return nil
+	}
+
+	// Ensure that the user's effective ID is root, as the key registry is root-only.
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	keySlot := cmd.String("keyslot")
+	keys, err := tpm.NewRegistry(tpm.DefaultStateDir).Keys()
+	if err != nil {
+		return err
+	}
+	rec, ok := keys[keySlot]
+	if !ok {
+		return fmt.Errorf("no record of keyslot %q in the key registry, use --salt with the salt of its escrow record", keySlot)
+	}
+
+	key, err := readRecoveryKey(cmd, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := rec.KeyFingerprint(key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fingerprint: %s\n", fingerprint)
+
+	if !rec.Matches(key.String()) {
+		return fmt.Errorf("recovery key does not match the recorded key of keyslot %q", keySlot)
+	}
+	fmt.Printf("Recovery key matches the recorded key %s of keyslot %q\n", rec.KeyID, keySlot)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/tui"
+)
+
+func newBackupHeaderCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "backup-header",
+		Usage:   "Back up the LUKS2 header areas of an encrypted volume",
+		Suggest: true,
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:      "file",
+				UsageText: "<file>",
+			},
+		},
+		Flags: append(newVolumeSelectorFlags(),
+			&cli.BoolFlag{
+				Name:  "encrypt",
+				Usage: "Encrypt the backup with a passphrase",
+			},
+		),
+		Action: backupHeader,
+	}
+}
+
+func backupHeader(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.StringArg("file")
+	if path == "" {
+		return cli.Exit("Missing file argument", 1)
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	device, err := resolveVolume(cmd)
+	if err != nil {
+		return err
+	}
+
+	var passphrase string
+	if cmd.Bool("encrypt") {
+		passphrase, err = tui.ReadUserSecret("Enter backup passphrase: ")
+		if err != nil {
+			return err
+		}
+		confirm, err := tui.ReadUserSecret("Confirm backup passphrase: ")
+		if err != nil {
+			return err
+		}
+		if passphrase == "" {
+			return fmt.Errorf("backup passphrase cannot be empty")
+		}
+		if passphrase != confirm {
+			return fmt.Errorf("backup passphrases do not match")
+		}
+	}
+
+	m, err := fde.BackupHeader(device, path, []byte(passphrase))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Header of %s (UUID %s) backed up to %s\n", device, m.UUID, path)
+	fmt.Printf("SHA-256: %s\n", m.SHA256)
+
+	return nil
+}
+
+func newRestoreHeaderCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "restore-header",
+		Usage:   "Restore the LUKS2 header areas of an encrypted volume from a backup",
+		Suggest: true,
+		Arguments: []cli.Argument{
+			&cli.StringArg{
+				Name:      "file",
+				UsageText: "<file>",
+			},
+		},
+		Flags:  newVolumeSelectorFlags(),
+		Action: restoreHeader,
+	}
+}
+
+func restoreHeader(ctx context.Context, cmd *cli.Command) error {
+	path := cmd.StringArg("file")
+	if path == "" {
+		return cli.Exit("Missing file argument", 1)
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	device, err := resolveVolume(cmd)
+	if err != nil {
+		return err
+	}
+
+	backup, err := fde.ReadHeaderBackup(path, nil)
+	if errors.Is(err, fde.ErrBackupEncrypted) {
+		passphrase, perr := tui.ReadUserSecret("Enter backup passphrase: ")
+		if perr != nil {
+			return perr
+		}
+		backup, err = fde.ReadHeaderBackup(path, []byte(passphrase))
+	}
+	if err != nil {
+		return err
+	}
+
+	m := backup.Manifest
+	var confirmedUUID string
+
+	err = backup.CheckTarget(device)
+	if err != nil && !errors.Is(err, fde.ErrUnidentifiedTarget) {
+		return fmt.Errorf("refusing to restore header: %w", err)
+	}
+
+	fmt.Printf("Backup of UUID %s taken on %s\n", m.UUID, m.Created.Local().Format(time.DateTime))
+	fmt.Printf("This overwrites the first %d bytes of %s, replacing all its current keyslots.\n", m.Size, device)
+
+	if err != nil {
+		// Both headers are damaged, so only the size of the device could be checked.
+		fmt.Printf("Warning: %v\n", err)
+		fmt.Printf("Make sure %s is the device the backup was taken from.\n", device)
+		fmt.Printf("Type the UUID of the backup to restore header: ")
+		confirmedUUID, err = tui.ReadUserInput()
+		if err != nil {
+			return err
+		}
+		if confirmedUUID != m.UUID {
+			return fmt.Errorf("restore aborted")
+		}
+	} else {
+		ok, err := tui.Confirm("Restore header?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("restore aborted")
+		}
+	}
+
+	if err := fde.RestoreHeader(device, backup, confirmedUUID); err != nil {
+		return err
+	}
+
+	fmt.Printf("Header of %s restored from %s\n", device, path)
+
+	return nil
+}
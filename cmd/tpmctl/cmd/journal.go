@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
+)
+
+func newJournalCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "journal",
+		Usage:   "Inspect, resume or roll back interrupted operations",
+		Suggest: true,
+		Commands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List unfinished operations",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return listOperations()
+				},
+			},
+			{
+				Name:  "rollback",
+				Usage: "Undo the completed steps of an unfinished operation",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "operation-id",
+						UsageText: "<operation-id>",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return rollbackOperation(ctx, cmd.StringArg("operation-id"), false)
+				},
+			},
+			{
+				Name:  "resume",
+				Usage: "Roll back an unfinished operation and run it again",
+				Arguments: []cli.Argument{
+					&cli.StringArg{
+						Name:      "operation-id",
+						UsageText: "<operation-id>",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return rollbackOperation(ctx, cmd.StringArg("operation-id"), true)
+				},
+			},
+		},
+	}
+}
+
+func listOperations() error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	ops, err := tpm.NewJournal(tpm.DefaultStateDir).Pending()
+	if err != nil {
+		return err
+	}
+
+	if len(ops) == 0 {
+		fmt.Println("No unfinished operations")
+		return nil
+	}
+
+	for _, op := range ops {
+		fmt.Println(op)
+	}
+
+	return nil
+}
+
+func rollbackOperation(ctx context.Context, id string, resume bool) error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	if id == "" {
+		return cli.Exit("Missing operation-id argument", 1)
+	}
+
+	journal := tpm.NewJournal(tpm.DefaultStateDir)
+	op, err := journal.Get(id)
+	if err != nil {
+		return err
+	}
+
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	if !resume {
+		if err := tpm.Rollback(ctx, c, op); err != nil {
+			return err
+		}
+		fmt.Printf("Operation %s rolled back\n", op.ID)
+		return nil
+	}
+
+	res, err := tpm.Resume(ctx, c, journal, tpm.NewRegistry(tpm.DefaultStateDir), op)
+	if err != nil {
+		if res != nil {
+			fmt.Printf("Recovery Key (may only be applied to some keyslots): %s\n", res.RecoveryKey)
+		}
+		return withJournalHint(err)
+	}
+
+	fmt.Printf("Recovery Key: %s\n", res.RecoveryKey)
+	fmt.Printf("Key ID: %s\n", res.KeyID)
+	fmt.Println(res.Status)
+
+	return nil
+}
+
+// withJournalHint tells the user how to recover from a partially failed operation.
+func withJournalHint(err error) error {
+	var opErr *tpm.OperationError
+	if !errors.As(err, &opErr) {
+		return err
+	}
+
+	return fmt.Errorf("%w\nrun 'snap-tpmctl journal rollback %s' or 'snap-tpmctl journal resume %s' to recover",
+		err, opErr.Operation.ID, opErr.Operation.ID)
+}
@@ -3,8 +3,10 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
 )
 
 func newMountVolumeCmd() *cli.Command {
@@ -18,37 +20,126 @@ func newMountVolumeCmd() *cli.Command {
 				UsageText: "<mount-point>",
 			},
 		},
+		Flags: append(newVolumeSelectorFlags(),
+			&cli.StringFlag{
+				Name:  "dm-name",
+				Usage: "Device mapper name of the unlocked volume (default: snap-tpmctl-<device name>)",
+			},
+			&cli.BoolFlag{
+				Name:  "read-only",
+				Usage: "Unlock and mount the volume read-only",
+			},
+			newRecoveryKeyFileFlag(),
+		),
 		Action: mountVolume,
 	}
 }
 
 func mountVolume(ctx context.Context, cmd *cli.Command) error {
-	// TODO: add validator for mount-point [string]
-
-	if cmd.StringArg("mount-point") == "" {
+	mountPoint := cmd.StringArg("mount-point")
+	if mountPoint == "" {
 		return cli.Exit("Missing mount-point argument", 1)
 	}
 
-	fmt.Println("Mount volume in", cmd.StringArg("mount-point"))
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	device, err := resolveVolume(cmd)
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(mountPoint); err != nil || !fi.IsDir() {
+		return fmt.Errorf("mount point %s is not a directory", mountPoint)
+	}
+
+	key, err := readRecoveryKey(cmd, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	vol, err := fde.MountVolume(ctx, fde.CommandExecutor{}, fde.MountOptions{
+		Device:     device,
+		MountPoint: mountPoint,
+		DMName:     cmd.String("dm-name"),
+		ReadOnly:   cmd.Bool("read-only"),
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s unlocked as %s and mounted on %s\n", vol.Device, vol.MapperPath, vol.MountPoint)
+
 	return nil
 }
 
-func newGetLuksPassphraseCmd() *cli.Command {
+func newUnmountVolumeCmd() *cli.Command {
 	return &cli.Command{
-		Name:    "get-luks-passphrase",
-		Usage:   "Get LUKS passphrase from recovery key",
+		Name:    "unmount-volume",
+		Usage:   "Unmount and close a volume opened with mount-volume",
 		Suggest: true,
 		Arguments: []cli.Argument{
-			&cli.IntArg{
-				Name:      "key-id",
-				UsageText: "<key-id>",
-				Value:     -1,
+			&cli.StringArg{
+				Name:      "target",
+				UsageText: "<mount-point|dm-name>",
 			},
 		},
 		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "lazy",
+				Usage: "Detach busy mounts now and close the volume once it is no longer used",
+			},
+		},
+		Action: unmountVolume,
+	}
+}
+
+func unmountVolume(ctx context.Context, cmd *cli.Command) error {
+	target := cmd.StringArg("target")
+	if target == "" {
+		return cli.Exit("Missing mount point or device mapper name argument", 1)
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	vol, err := fde.UnmountVolume(ctx, fde.CommandExecutor{}, fde.DefaultSystemPaths, fde.UnmountOptions{
+		Target: target,
+		Lazy:   cmd.Bool("lazy"),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, mp := range vol.MountPoints {
+		fmt.Printf("Unmounted %s\n", mp)
+	}
+	if vol.Deferred {
+		fmt.Printf("%s will be closed once it is no longer used\n", vol.DMName)
+		return nil
+	}
+	fmt.Printf("Closed %s\n", vol.DMName)
+
+	return nil
+}
+
+func newGetLuksPassphraseCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "get-luks-passphrase",
+		Usage:   "Get LUKS passphrase from recovery key",
+		Suggest: true,
+		Flags: []cli.Flag{
+			newRecoveryKeyFileFlag(),
+			&cli.StringFlag{
+				Name:  "format",
+				Usage: "Output format: raw, hex or base64",
+				Value: string(fde.KeyFormatRaw),
+			},
 			&cli.StringFlag{
 				Name:  "file",
-				Usage: "Write passphrase to file",
+				Usage: "Write passphrase to a new file instead of stdout, usable as a cryptsetup --key-file",
 			},
 		},
 		Action: getLuksPassphrase,
@@ -56,22 +147,28 @@ func newGetLuksPassphraseCmd() *cli.Command {
 }
 
 func getLuksPassphrase(ctx context.Context, cmd *cli.Command) error {
-	// TODO: add validator for key-id [int]
-
-	if cmd.IntArg("key-id") < 0 {
-		// TODO… return an error instead
-		return cli.Exit("Missing key-id argument", 1)
+	// Prompts go to stderr, as stdout may be the passphrase output.
+	key, err := readRecoveryKey(cmd, os.Stderr)
+	if err != nil {
+		return err
 	}
 
-	// TODO: add validator for f [string]
+	passphrase, err := fde.EncodeLUKSPassphrase(key, fde.KeyFormat(cmd.String("format")))
+	if err != nil {
+		return err
+	}
 
-	msg := "print to stdout"
 	if f := cmd.String("file"); f != "" {
-		msg = fmt.Sprintf("print to file: %s", f)
+		if err := fde.WriteKeyFile(f, passphrase); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "LUKS passphrase written to %s\n", f)
+		return nil
 	}
-	fmt.Println(msg)
 
-	fmt.Println("Get LUKS passphrase for key", cmd.IntArg("key-id"))
+	if _, err := os.Stdout.Write(passphrase); err != nil {
+		return fmt.Errorf("failed to write passphrase: %w", err)
+	}
 
 	return nil
 }
@@ -3,9 +3,13 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/enterprise"
 	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
 )
 
 func newRegenerateKeyCmd() *cli.Command {
@@ -22,17 +26,25 @@ func newRegenerateKeyCmd() *cli.Command {
 				Destination: &recoveryKey,
 			},
 		},
+		Flags: []cli.Flag{
+			newPurposeFlag(),
+		},
 		Action: func(ctx context.Context, c *cli.Command) error {
-			return regenerateKey(ctx, recoveryKey)
+			return regenerateKey(ctx, recoveryKey, keyMetadata(c))
 		},
 	}
 }
 
-func regenerateKey(ctx context.Context, _ string) error {
+func regenerateKey(ctx context.Context, recoveryKeyName string, meta tpm.KeyMetadata) error {
 	// TODO: decide if we want to match exactly the security center
 	// behaviour showing the key, waiting for user confirmation and then
 	// replace the key and removing it from the screen
 
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
 	c := snapd.NewClient()
 	defer c.Close()
 
@@ -40,46 +52,68 @@ func regenerateKey(ctx context.Context, _ string) error {
 		return fmt.Errorf("failed to load auth: %w", err)
 	}
 
-	key, err := c.GenerateRecoveryKey(ctx)
+	res, err := tpm.RegenerateKey(ctx, c, tpm.NewJournal(tpm.DefaultStateDir), tpm.NewRegistry(tpm.DefaultStateDir), recoveryKeyName, meta)
 	if err != nil {
-		return fmt.Errorf("failed to generate recovery key: %w", err)
-	}
-
-	fmt.Printf("Recovery Key: %s\n", key.RecoveryKey)
-	fmt.Printf("Key ID: %s\n", key.KeyID)
-
-	res, err := c.ReplaceRecoveryKey(ctx, key.KeyID, nil)
-	if err != nil {
-		return fmt.Errorf("failed to replace recovery key: %w", err)
+		// The key may already be in use on some keyslots, so never hide it.
+		if res != nil {
+			fmt.Printf("Recovery Key (may only be applied to some keyslots): %s\n", res.RecoveryKey)
+			fmt.Printf("Key ID: %s\n", res.KeyID)
+		}
+		return withJournalHint(err)
 	}
 
+	fmt.Printf("Recovery Key: %s\n", res.RecoveryKey)
+	fmt.Printf("Key ID: %s\n", res.KeyID)
 	fmt.Println(res.Status)
-	fmt.Println(res.Summary)
 
 	return nil
 }
 
 func newRegenerateEnterpriseKeyCmd() *cli.Command {
-	var recoveryKey string
-
 	return &cli.Command{
 		Name:    "regenerate-enterprise-key",
 		Usage:   "Regenerate an existing enterprise recovery key",
 		Suggest: true,
-		Arguments: []cli.Argument{
-			&cli.StringArg{
-				Name:        "key-id",
-				UsageText:   "<key-id>",
-				Destination: &recoveryKey,
-			},
-		},
+		Flags:   newEscrowFlags(),
 		Action: func(ctx context.Context, c *cli.Command) error {
-			return regenerateEnterpriseKey(ctx, recoveryKey)
+			return regenerateEnterpriseKey(ctx, c)
 		},
 	}
 }
 
-func regenerateEnterpriseKey(_ context.Context, key string) error {
-	fmt.Println("Regenerated enterprise key with id", key)
+func regenerateEnterpriseKey(ctx context.Context, cmd *cli.Command) error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	escrow, machine, cfg, err := newEscrowClient(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	// Unlike local keys, the rotated key is never shown: the escrow endpoint holds it.
+	res, err := enterprise.Rotate(ctx, c, escrow, machine, cfg.KeySlot)
+	if res != nil {
+		// The key was replaced even if the previous records could not be revoked.
+		recordEnterpriseKey(ctx, cmd, res)
+		printEscrowResult(res)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(res.Revoked) > 0 {
+		fmt.Printf("Revoked escrow records: %s\n", strings.Join(res.Revoked, ", "))
+	}
+	fmt.Println(res.Status)
+
 	return nil
 }
@@ -14,6 +14,9 @@ func newRemovePassphraseCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "remove-passphrase",
 		Usage: "Remove passphrase authentication",
+		Flags: []cli.Flag{
+			newForceFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Ensure that the user's effective ID is root
 			if os.Geteuid() != 0 {
@@ -28,6 +31,11 @@ func newRemovePassphraseCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
 			// Validate auth mode is currently passphrase
 			if err := tpm.ValidateAuthMode(ctx, c, snapd.AuthModePassphrase); err != nil {
 				return err
@@ -46,6 +54,9 @@ func newRemovePINCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "remove-pin",
 		Usage: "Remove PIN authentication",
+		Flags: []cli.Flag{
+			newForceFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Ensure that the user's effective ID is root
 			if os.Geteuid() != 0 {
@@ -60,6 +71,11 @@ func newRemovePINCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
 			// Validate auth mode is currently PIN
 			if err := tpm.ValidateAuthMode(ctx, c, snapd.AuthModePin); err != nil {
 				return err
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
+	"snap-tpmctl/internal/tui"
+)
+
+func newRepairCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "repair",
+		Usage:   "Reconcile divergent keyslot states",
+		Suggest: true,
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			// Ensure that the user's effective ID is root
+			if os.Geteuid() != 0 {
+				return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+			}
+
+			return repair(ctx)
+		},
+	}
+}
+
+func repair(ctx context.Context) error {
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	report, err := tpm.CheckConsistency(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if report.IsConsistent() {
+		fmt.Println("Keyslots are consistent, nothing to repair")
+		return nil
+	}
+
+	fmt.Println("Found the following issues:")
+	for _, issue := range report.Issues {
+		fmt.Printf("  - %s\n", issue.Message)
+	}
+
+	actions := tpm.PlanRepair(report)
+
+	fmt.Println("The following actions will be applied:")
+	for i, action := range actions {
+		fmt.Printf("  %d. %s\n", i+1, action)
+	}
+
+	ok, err := tui.Confirm("Apply these actions?")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("Repair cancelled")
+		return nil
+	}
+
+	for _, action := range actions {
+		secret, err := readRepairSecret(ctx, c, action)
+		if err != nil {
+			return err
+		}
+
+		res, err := tpm.ApplyRepair(ctx, c, action, secret)
+		if res != nil && res.RecoveryKey != "" {
+			fmt.Printf("Recovery Key for %s: %s\n", action.KeySlot, res.RecoveryKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Keyslots repaired successfully")
+
+	return nil
+}
+
+// readRepairSecret prompts for the PIN or passphrase needed to reseal the platform keys, if any.
+func readRepairSecret(ctx context.Context, c *snapd.Client, action tpm.RepairAction) (string, error) {
+	if action.Kind != tpm.RepairReplacePlatformKey {
+		return "", nil
+	}
+
+	return readNewSecret(ctx, c, action.AuthMode)
+}
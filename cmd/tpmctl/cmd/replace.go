@@ -15,6 +15,10 @@ func newReplacePassphraseCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "replace-passphrase",
 		Usage: "Replace encryption passphrase",
+		Flags: []cli.Flag{
+			newForceFlag(),
+			newKeySlotFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			c := snapd.NewClient()
 			defer c.Close()
@@ -24,6 +28,21 @@ func newReplacePassphraseCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
+			keySlots, err := tpm.ParseKeySlots(cmd.StringSlice("keyslot"))
+			if err != nil {
+				return err
+			}
+
+			// Validate the selected keyslots use passphrase authentication
+			if err := tpm.ValidateKeySlots(ctx, c, keySlots, snapd.AuthModePassphrase); err != nil {
+				return err
+			}
+
 			oldPassphrase, err := tui.ReadUserSecret("Enter current passphrase: ")
 			if err != nil {
 				return err
@@ -43,7 +62,7 @@ func newReplacePassphraseCmd() *cli.Command {
 				return err
 			}
 
-			if err := tpm.ReplacePassphrase(ctx, c, oldPassphrase, newPassphrase); err != nil {
+			if err := tpm.ReplacePassphrase(ctx, c, oldPassphrase, newPassphrase, keySlots); err != nil {
 				return err
 			}
 			fmt.Println("Passphrase replaced successfully")
@@ -57,6 +76,10 @@ func newReplacePinCmd() *cli.Command {
 	return &cli.Command{
 		Name:  "replace-pin",
 		Usage: "Replace encryption PIN",
+		Flags: []cli.Flag{
+			newForceFlag(),
+			newKeySlotFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			c := snapd.NewClient()
 			defer c.Close()
@@ -66,6 +89,21 @@ func newReplacePinCmd() *cli.Command {
 				return fmt.Errorf("failed to load auth: %w", err)
 			}
 
+			// Validate keyslots are consistent across all volumes
+			if err := validateConsistency(ctx, cmd, c); err != nil {
+				return err
+			}
+
+			keySlots, err := tpm.ParseKeySlots(cmd.StringSlice("keyslot"))
+			if err != nil {
+				return err
+			}
+
+			// Validate the selected keyslots use PIN authentication
+			if err := tpm.ValidateKeySlots(ctx, c, keySlots, snapd.AuthModePin); err != nil {
+				return err
+			}
+
 			oldPin, err := tui.ReadUserSecret("Enter current PIN: ")
 			if err != nil {
 				return err
@@ -85,7 +123,7 @@ func newReplacePinCmd() *cli.Command {
 				return err
 			}
 
-			if err := tpm.ReplacePIN(ctx, c, oldPin, newPin); err != nil {
+			if err := tpm.ReplacePIN(ctx, c, oldPin, newPin, keySlots); err != nil {
 				return err
 			}
 			fmt.Println("PIN replaced successfully")
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
+)
+
+func newRotateKeysCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "rotate-keys",
+		Usage:   "Regenerate the recovery keys older than a maximum age, from the key registry",
+		Suggest: true,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:     "older-than",
+				Usage:    "Maximum age of the recovery keys, e.g. 180d, 26w or 4320h",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report the recovery keys which would be rotated",
+			},
+			&cli.BoolFlag{
+				Name:  "include-unknown",
+				Usage: "Also rotate the recovery keys missing from the key registry, whose age is unknown",
+			},
+		}, newEscrowFlags()...),
+		Action: rotateKeys,
+	}
+}
+
+// rotation is the outcome of the rotation of a keyslot, reported in the summary.
+type rotation struct {
+	age    tpm.KeyAge
+	action string
+	keyID  string
+}
+
+func rotateKeys(ctx context.Context, cmd *cli.Command) error {
+	// Ensure that the user's effective ID is root
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("this command requires elevated privileges. Please run with sudo")
+	}
+
+	maxAge, err := tpm.ParseKeyAge(cmd.String("older-than"))
+	if err != nil {
+		return err
+	}
+	dryRun := cmd.Bool("dry-run")
+
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	registry := tpm.NewRegistry(tpm.DefaultStateDir)
+	ages, err := tpm.RecoveryKeyAges(ctx, c, registry)
+	if err != nil {
+		return err
+	}
+
+	rotator := &keyRotator{cmd: cmd, client: c, registry: registry}
+	now := time.Now()
+	var report []rotation
+	var stale, failed, unescrowed int
+	for _, age := range ages {
+		r := rotation{age: age, keyID: age.KeyID}
+		switch {
+		case !age.Known() && !cmd.Bool("include-unknown"):
+			r.action = "skipped: not in the key registry"
+		case age.Known() && !age.IsStale(maxAge, now):
+			r.action = "up to date"
+		case dryRun:
+			stale++
+			r.action = "would rotate"
+		default:
+			stale++
+			keyID, err := rotator.rotate(ctx, age.KeySlot)
+			if errors.Is(err, errEscrowUnavailable) {
+				failed++
+				unescrowed++
+				r.action = "failed: escrow unavailable"
+				break
+			}
+			if err != nil {
+				failed++
+				r.action = fmt.Sprintf("failed: %v", err)
+				break
+			}
+			r.action, r.keyID = "rotated", keyID
+		}
+		report = append(report, r)
+	}
+
+	if err := displayRotationReport(report, now); err != nil {
+		return err
+	}
+
+	switch {
+	case stale == 0:
+		fmt.Printf("No recovery key older than %s\n", cmd.String("older-than"))
+	case dryRun:
+		fmt.Printf("%d recovery key(s) would be rotated\n", stale)
+	default:
+		fmt.Printf("%d of %d recovery key(s) rotated\n", stale-failed, stale)
+	}
+	if unescrowed > 0 {
+		fmt.Printf("%d enterprise recovery key(s) kept as the escrow endpoint is unavailable, run rotate-keys again later\n", unescrowed)
+	}
+	if failed > unescrowed {
+		return fmt.Errorf("%d recovery key(s) could not be rotated, see 'snap-tpmctl journal list'", failed-unescrowed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d recovery key(s) could not be rotated", failed)
+	}
+
+	return nil
+}
+
+// errEscrowUnavailable is returned when an enterprise key was not rotated, as the escrow
+// endpoint was temporarily unavailable. Its current key is left in place.
+var errEscrowUnavailable = errors.New("escrow unavailable")
+
+// keyRotator regenerates the keys of the stale keyslots.
+type keyRotator struct {
+	cmd      *cli.Command
+	client   *snapd.Client
+	registry *tpm.Registry
+
+	// escrow is the enterprise escrow client, created for the first enterprise keyslot.
+	escrow  *enterprise.Client
+	machine enterprise.Identity
+}
+
+// rotate regenerates the key of a keyslot and returns its new key ID. Local keys are
+// printed, enterprise keys are escrowed and never shown. Enterprise keys are never spooled:
+// they are only replaced once the escrow endpoint acknowledged the new key.
+func (r *keyRotator) rotate(ctx context.Context, keySlot string) (string, error) {
+	if !tpm.IsEnterpriseKeySlot(keySlot) {
+		res, err := tpm.RegenerateKey(ctx, r.client, tpm.NewJournal(tpm.DefaultStateDir), r.registry, keySlot, keyMetadata(r.cmd))
+		if res != nil {
+			// The key may already be in use on some keyslots, so never hide it.
+			fmt.Printf("Recovery Key for %s: %s\n", keySlot, res.RecoveryKey)
+		}
+		if err != nil {
+			return "", err
+		}
+		return res.KeyID, nil
+	}
+
+	if r.escrow == nil {
+		escrow, machine, _, err := newEscrowClient(ctx, r.cmd)
+		if err != nil {
+			return "", err
+		}
+		r.escrow, r.machine = escrow, machine
+	}
+
+	res, err := enterprise.Rotate(ctx, r.client, r.escrow, r.machine, keySlot)
+	if res == nil && enterprise.IsTemporary(err) {
+		return "", fmt.Errorf("%w: %w", errEscrowUnavailable, err)
+	}
+	if res != nil {
+		// The key was replaced even if the previous records could not be revoked.
+		recordEnterpriseKey(ctx, r.cmd, res)
+		fmt.Printf("Enterprise recovery key for %s escrowed as %s\n", keySlot, res.EscrowID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return res.KeyID, nil
+}
+
+// displayRotationReport prints the outcome of the rotation of each recovery keyslot.
+func displayRotationReport(report []rotation, now time.Time) error {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("Keyslot", "ContainerRoles", "Created", "Age", "KeyID", "Action")
+
+	for _, r := range report {
+		created, age := "unknown", "unknown"
+		if r.age.Known() {
+			created = r.age.Created.Local().Format(time.DateTime)
+			age = fmt.Sprintf("%dd", int(now.Sub(r.age.Created).Hours()/24))
+		}
+
+		err := table.Append(
+			r.age.KeySlot,
+			strings.Join(r.age.ContainerRoles, ", "),
+			created,
+			age,
+			cmp.Or(r.keyID, "-"),
+			r.action,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to append table row: %w", err)
+		}
+	}
+
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("failed to render table: %w", err)
+	}
+
+	return nil
+}
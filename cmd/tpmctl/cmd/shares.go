@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/tui"
+)
+
+// newSplitFlags returns the flags splitting a new recovery key in shares for custodians.
+func newSplitFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "split",
+			Usage: "Split the recovery key in M shares, any N of which reconstruct it with combine-key, as N/M",
+		},
+		&cli.StringFlag{
+			Name:  "export-shares",
+			Usage: "Write each share to a new root-only file in this directory instead of printing them",
+		},
+	}
+}
+
+// parseSplit parses the N/M value of the split flag.
+func parseSplit(s string) (threshold, shares int, err error) {
+	n, m, ok := strings.Cut(s, "/")
+	if ok {
+		threshold, err = strconv.Atoi(n)
+	}
+	if ok && err == nil {
+		shares, err = strconv.Atoi(m)
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid split %q: expected N/M, e.g. 2/3 for 3 shares any 2 of which reconstruct the key", s)
+	}
+
+	// Validate the split before creating the key.
+	if _, err := fde.SplitRecoveryKey(fde.RecoveryKey{}, threshold, shares); err != nil {
+		return 0, 0, err
+	}
+
+	return threshold, shares, nil
+}
+
+// checkExportDir checks that the shares can be exported before the key is created.
+func checkExportDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid share export directory: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("invalid share export directory: %s is not a directory", dir)
+	}
+	return nil
+}
+
+// printKeyShares splits the recovery key and prints its shares, or writes them to the
+// export directory. The recovery key itself is not shown.
+func printKeyShares(recoveryKey, keyName string, threshold, count int, exportDir string) error {
+	key, err := fde.ParseRecoveryKey(recoveryKey)
+	if err != nil {
+		return fmt.Errorf("invalid recovery key: %w", err)
+	}
+
+	shares, err := fde.SplitRecoveryKey(key, threshold, count)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recovery key split in %d shares, any %d of which reconstruct it with combine-key\n", count, threshold)
+	for _, s := range shares {
+		if exportDir == "" {
+			fmt.Printf("Share %d/%d: %s\n", s.Index, count, s)
+			continue
+		}
+
+		path := filepath.Join(exportDir, fmt.Sprintf("%s-share-%d-of-%d.txt", keyName, s.Index, count))
+		if err := fde.WriteKeyFile(path, []byte(s.String()+"\n")); err != nil {
+			// The key is already created, so print the share rather than losing it.
+			fmt.Printf("Warning: %v\nShare %d/%d: %s\n", err, s.Index, count, s)
+			continue
+		}
+		fmt.Printf("Share %d/%d written to %s\n", s.Index, count, path)
+	}
+	fmt.Println("Give each share to a different custodian")
+
+	return nil
+}
+
+func newCombineKeyCmd() *cli.Command {
+	return &cli.Command{
+		Name:    "combine-key",
+		Usage:   "Reconstruct a recovery key from the shares of create-key --split and check it",
+		Suggest: true,
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "share-file",
+				Usage: "Read a share from a file instead of prompting for it, can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "device",
+				Usage: "Check the key against the LUKS2 header of a device or image, without snapd",
+			},
+		},
+		Action: combineKey,
+	}
+}
+
+func combineKey(ctx context.Context, cmd *cli.Command) error {
+	var shares []fde.KeyShare
+	for _, path := range cmd.StringSlice("share-file") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read share: %w", err)
+		}
+		s, err := fde.ParseKeyShare(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid share in %s: %w", path, err)
+		}
+		shares = append(shares, s)
+	}
+
+	// Prompt for the missing shares, the first one telling how many are needed.
+	for len(shares) == 0 || len(shares) < shares[0].Threshold {
+		input, err := tui.ReadUserSecret(fmt.Sprintf("Enter share %d: ", len(shares)+1))
+		if err != nil {
+			return err
+		}
+		s, err := fde.ParseKeyShare(input)
+		if err != nil {
+			return fmt.Errorf("invalid share: %w", err)
+		}
+		shares = append(shares, s)
+	}
+
+	key, err := fde.CombineKeyShares(shares)
+	if err != nil {
+		return err
+	}
+
+	if device := cmd.String("device"); device != "" {
+		if err := checkDevice(device, key); err != nil {
+			return err
+		}
+	} else if err := check(ctx, key.String()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Recovery Key: %s\n", key)
+
+	return nil
+}
@@ -1,11 +1,20 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
-	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
 
+	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v3"
+	"snap-tpmctl/internal/fde"
 	"snap-tpmctl/internal/log"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/tpm"
 )
 
 func newStatusCmd() *cli.Command {
@@ -13,14 +22,107 @@ func newStatusCmd() *cli.Command {
 		Name:    "status",
 		Usage:   "Show TPM status",
 		Suggest: true,
+		Flags: []cli.Flag{
+			newOfflineFlag(),
+		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
-			return status(ctx)
+			return status(ctx, cmd.Bool("offline"))
 		},
 	}
 }
 
-func status(ctx context.Context) error {
+func status(ctx context.Context, offline bool) error {
 	log.Debug(ctx, "Retrieve status")
 
-	return errors.New("TODO: implement the status API when lands on snapd")
+	if !offline {
+		res, err := enumerateFromSnapd(ctx)
+		if err == nil {
+			fmt.Println("Source: snapd")
+			if err := displayStatus(res, nil); err != nil {
+				return err
+			}
+			displayEnterpriseStatus(ctx)
+			return nil
+		}
+		log.Warningf(ctx, "snapd API unavailable, reading LUKS2 headers instead: %v", err)
+	}
+
+	volumes, err := fde.ReadSystemVolumes(fde.DefaultByLabelDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Source: LUKS2 headers")
+	if err := displayStatus(fde.SystemVolumes(volumes), volumes); err != nil {
+		return err
+	}
+	displayEnterpriseStatus(ctx)
+
+	return nil
+}
+
+func enumerateFromSnapd(ctx context.Context) (*snapd.SystemVolumesResult, error) {
+	c := snapd.NewClient()
+	defer c.Close()
+
+	if err := c.LoadAuthFromHome(); err != nil {
+		return nil, fmt.Errorf("failed to load auth: %w", err)
+	}
+
+	return c.EnumerateKeySlots(ctx)
+}
+
+// displayStatus prints the auth mode, the consistency issues and the keyslots of the volumes.
+// The KDF of the keyslots is only known when read offline.
+func displayStatus(res *snapd.SystemVolumesResult, offline []fde.OfflineVolume) error {
+	report := tpm.CheckVolumes(res)
+
+	fmt.Printf("Auth mode: %s\n", cmp.Or(string(report.AuthMode), "-"))
+	if err := report.Error(); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("Keyslots are consistent across volumes")
+	}
+
+	details := make(map[string]fde.KeySlot)
+	for _, v := range offline {
+		for _, ks := range v.KeySlots {
+			details[v.ContainerRole+"/"+ks.Name] = ks
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header("ContainerRole", "Name", "Type", "AuthMode", "PlatformName", "Roles", "KDF")
+
+	for _, role := range slices.Sorted(maps.Keys(res.ByContainerRole)) {
+		volume := res.ByContainerRole[role]
+		for _, name := range slices.Sorted(maps.Keys(volume.KeySlots)) {
+			slot := volume.KeySlots[name]
+			ks := details[role+"/"+name]
+
+			kdf := "-"
+			if ks.KDF != nil {
+				kdf = ks.KDF.Type
+			}
+
+			err := table.Append(
+				role,
+				name,
+				slot.Type,
+				cmp.Or(slot.AuthMode, "-"),
+				cmp.Or(slot.PlatformName, "-"),
+				cmp.Or(strings.Join(slot.Roles, "+"), "-"),
+				kdf,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to append table row: %w", err)
+			}
+		}
+	}
+
+	if err := table.Render(); err != nil {
+		return fmt.Errorf("failed to render table: %w", err)
+	}
+
+	return nil
 }
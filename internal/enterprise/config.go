@@ -0,0 +1,315 @@
+package enterprise
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigPath is the main enterprise configuration file. Drop-ins are read from
+// the enterprise.d directory next to it.
+const DefaultConfigPath = "/etc/snap-tpmctl/enterprise.yaml"
+
+// configEnvPrefix prefixes the environment variables overriding the configuration,
+// e.g. SNAP_TPMCTL_ENTERPRISE_ENDPOINT for endpoint.
+const configEnvPrefix = "SNAP_TPMCTL_ENTERPRISE_"
+
+// Configuration keys.
+const (
+	ConfigEndpoint        = "endpoint"
+	ConfigCABundle        = "ca-bundle"
+	ConfigClientCert      = "client-certificate"
+	ConfigClientKey       = "client-key"
+	ConfigAccountName     = "account-name"
+	ConfigRegistrationKey = "registration-key"
+	ConfigEscrowPublicKey = "escrow-public-key"
+	ConfigKeySlot         = "key-slot"
+)
+
+// accountNameRe matches the Landscape account names.
+var accountNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// configKey describes a configuration key: where it is stored and how it is validated.
+type configKey struct {
+	field    func(*Config) *string
+	validate func(string) error
+	// secret values must not be readable by other users, nor be printed.
+	secret bool
+}
+
+var configKeys = map[string]configKey{
+	ConfigEndpoint:        {field: func(c *Config) *string { return &c.Endpoint }, validate: validateEndpoint},
+	ConfigCABundle:        {field: func(c *Config) *string { return &c.CABundle }, validate: validateCABundle},
+	ConfigClientCert:      {field: func(c *Config) *string { return &c.ClientCert }, validate: validateClientCert},
+	ConfigClientKey:       {field: func(c *Config) *string { return &c.ClientKey }, validate: checkPrivateFile},
+	ConfigAccountName:     {field: func(c *Config) *string { return &c.AccountName }, validate: validateAccountName},
+	ConfigRegistrationKey: {field: func(c *Config) *string { return &c.RegistrationKey }, secret: true},
+	ConfigEscrowPublicKey: {field: func(c *Config) *string { return &c.EscrowPublicKey }, validate: validateEscrowPublicKey},
+	ConfigKeySlot:         {field: func(c *Config) *string { return &c.KeySlot }, validate: validateKeySlot},
+}
+
+// Config is the enterprise configuration.
+type Config struct {
+	// Endpoint is the URL of the escrow endpoint.
+	Endpoint string
+	// CABundle is the PEM file of the certificate authorities trusted for the endpoint.
+	CABundle string
+	// ClientCert and ClientKey are the PEM files of the certificate authenticating the machine
+	// to the endpoint. The key must not be accessible to other users.
+	ClientCert  string
+	ClientKey   string
+	AccountName string
+	// RegistrationKey is the secret used to register the machine to the account.
+	RegistrationKey string
+	// EscrowPublicKey is the PEM file of the organisation key escrowed keys are encrypted to.
+	EscrowPublicKey string
+	// KeySlot is the keyslot of the escrowed recovery key.
+	KeySlot string
+
+	// sources records where each key was set, as file:line, an environment variable or a flag.
+	sources map[string]string
+}
+
+// ConfigKeys returns the configuration keys, sorted.
+func ConfigKeys() []string {
+	keys := make([]string, 0, len(configKeys))
+	for k := range configKeys {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// LoadConfig reads the configuration file at path, then the *.yaml drop-ins of the
+// enterprise.d directory next to it in lexical order, then the SNAP_TPMCTL_ENTERPRISE_*
+// environment variables. Later values override earlier ones.
+//
+// The configuration is validated strictly: unknown keys, invalid values and secrets in
+// files readable by other users are errors, reported with their file and line.
+// A missing main file is not an error, as the configuration may only come from drop-ins.
+func LoadConfig(path string) (*Config, error) {
+	return loadConfig(path, os.LookupEnv)
+}
+
+func loadConfig(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := &Config{KeySlot: DefaultKeySlot, sources: make(map[string]string)}
+
+	dropIns, err := filepath.Glob(filepath.Join(filepath.Dir(path), "enterprise.d", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(dropIns)
+
+	files := dropIns
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		files = append([]string{path}, dropIns...)
+	}
+
+	var errs []error
+	for _, file := range files {
+		errs = append(errs, c.readFile(file))
+	}
+
+	for _, key := range ConfigKeys() {
+		env := envName(key)
+		if value, ok := lookupEnv(env); ok {
+			errs = append(errs, c.Set(key, value, "$"+env))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// readFile reads the keys of a configuration file.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read enterprise configuration: %w", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read enterprise configuration: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	// An empty file has no document.
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping of keys to values", path, root.Line)
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valueNode := root.Content[i], root.Content[i+1]
+		source := fmt.Sprintf("%s:%d", path, keyNode.Line)
+		key := keyNode.Value
+
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("%s: duplicate key %q", source, key))
+			continue
+		}
+		seen[key] = true
+
+		if valueNode.Kind != yaml.ScalarNode || valueNode.Tag == "!!null" {
+			errs = append(errs, fmt.Errorf("%s: %s must be a string", source, key))
+			continue
+		}
+		if configKeys[key].secret && fi.Mode().Perm()&0o077 != 0 {
+			errs = append(errs, fmt.Errorf("%s: %s must not be stored in a file accessible to other users (mode %04o)", source, key, fi.Mode().Perm()))
+			continue
+		}
+
+		errs = append(errs, c.Set(key, valueNode.Value, source))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Set validates and sets the value of a key. The source, like a file:line or a flag,
+// is used in errors and reported by Get.
+func (c *Config) Set(key, value, source string) error {
+	k, ok := configKeys[key]
+	if !ok {
+		return fmt.Errorf("%s: unknown key %q", source, key)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("%s: %s cannot be empty", source, key)
+	}
+	if k.validate != nil {
+		if err := k.validate(value); err != nil {
+			return fmt.Errorf("%s: invalid %s: %w", source, key, err)
+		}
+	}
+
+	*k.field(c) = value
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+
+	return nil
+}
+
+// Get returns the value of a key, with secrets redacted, and where it was set.
+// The source of unset keys is empty.
+func (c *Config) Get(key string) (value, source string) {
+	k, ok := configKeys[key]
+	if !ok {
+		return "", ""
+	}
+
+	value = *k.field(c)
+	if k.secret && value != "" {
+		value = "********"
+	}
+
+	source = c.sources[key]
+	if source == "" && value != "" {
+		source = "default"
+	}
+
+	return value, source
+}
+
+// Require returns an error for each of the keys which is not set.
+func (c *Config) Require(keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		if value, _ := c.Get(key); value == "" {
+			errs = append(errs, fmt.Errorf("missing required setting %q, set it in %s or with %s", key, DefaultConfigPath, envName(key)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ClientOptions returns the options of the escrow client: the pinned certificate authorities
+// and the client certificate.
+func (c *Config) ClientOptions() ([]ClientOption, error) {
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", ConfigClientCert, ConfigClientKey)
+	}
+
+	var opts []ClientOption
+	if c.CABundle != "" {
+		opts = append(opts, WithCABundle(c.CABundle))
+	}
+	if c.ClientCert != "" {
+		opts = append(opts, WithClientCertificate(c.ClientCert, c.ClientKey))
+	}
+	return opts, nil
+}
+
+// CheckLandscape checks that the configured account is the one the machine is registered
+// to with Landscape. Without a configured account, the Landscape one is used.
+func (c *Config) CheckLandscape(id *LandscapeIdentity) error {
+	if c.AccountName == "" {
+		return c.Set(ConfigAccountName, id.AccountName, "landscape client")
+	}
+	if c.AccountName != id.AccountName {
+		return fmt.Errorf("%s: account-name %q does not match the Landscape client account %q",
+			c.sources[ConfigAccountName], c.AccountName, id.AccountName)
+	}
+	return nil
+}
+
+// envName returns the environment variable overriding a key.
+func envName(key string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func validateEndpoint(value string) error {
+	_, err := NewClient(value, nil)
+	return err
+}
+
+func validateCABundle(path string) error {
+	_, err := loadCertPool(path)
+	return err
+}
+
+func validateClientCert(path string) error {
+	_, err := ReadCertificate(path)
+	return err
+}
+
+func validateAccountName(name string) error {
+	if !accountNameRe.MatchString(name) {
+		return fmt.Errorf("%q must contain only lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+func validateEscrowPublicKey(path string) error {
+	_, err := LoadOrgKey(path)
+	return err
+}
+
+func validateKeySlot(name string) error {
+	if !strings.HasPrefix(name, "enterprise") {
+		return fmt.Errorf("%q must start with 'enterprise', which is reserved for escrowed keys", name)
+	}
+	if strings.ContainsAny(name, ": \t/") {
+		return fmt.Errorf("%q must not contain spaces, ':' or '/'", name)
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package enterprise_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		main    string
+		noMain  bool
+		mode    os.FileMode
+		keyMode os.FileMode
+		dropIns map[string]string
+		env     map[string]string
+
+		want        map[string]string
+		wantSources map[string]string
+		wantErr     []string
+	}{
+		"Main file": {
+			main: "endpoint: https://landscape.example.com/escrow\naccount-name: acme\nescrow-public-key: {{pub}}\nca-bundle: {{ca}}\n",
+			want: map[string]string{
+				"endpoint":          "https://landscape.example.com/escrow",
+				"account-name":      "acme",
+				"escrow-public-key": "{{pub}}",
+				"ca-bundle":         "{{ca}}",
+				"key-slot":          enterprise.DefaultKeySlot,
+			},
+			wantSources: map[string]string{"endpoint": "{{dir}}/enterprise.yaml:1", "account-name": "{{dir}}/enterprise.yaml:2", "key-slot": "default"},
+		},
+		"Empty main file": {
+			main: "",
+			want: map[string]string{"endpoint": "", "key-slot": enterprise.DefaultKeySlot},
+		},
+		"Drop-ins override the main file in lexical order": {
+			main: "endpoint: https://main.example.com\naccount-name: acme\n",
+			dropIns: map[string]string{
+				"20-last.yaml":  "endpoint: https://last.example.com\n",
+				"10-first.yaml": "endpoint: https://first.example.com\nkey-slot: enterprise-laptop\n",
+				"README":        "not: read",
+			},
+			want: map[string]string{
+				"endpoint":     "https://last.example.com",
+				"account-name": "acme",
+				"key-slot":     "enterprise-laptop",
+			},
+			wantSources: map[string]string{"endpoint": "{{dir}}/enterprise.d/20-last.yaml:1", "key-slot": "{{dir}}/enterprise.d/10-first.yaml:2"},
+		},
+		"Environment overrides the files": {
+			main:        "endpoint: https://main.example.com\n",
+			env:         map[string]string{"SNAP_TPMCTL_ENTERPRISE_ENDPOINT": "https://env.example.com", "SNAP_TPMCTL_ENTERPRISE_REGISTRATION_KEY": "secret"},
+			want:        map[string]string{"endpoint": "https://env.example.com", "registration-key": "********"},
+			wantSources: map[string]string{"endpoint": "$SNAP_TPMCTL_ENTERPRISE_ENDPOINT"},
+		},
+		"Drop-ins only when main file is missing": {
+			noMain:  true,
+			dropIns: map[string]string{"50-site.yaml": "endpoint: https://site.example.com\n"},
+			want:    map[string]string{"endpoint": "https://site.example.com"},
+		},
+		"Client certificate": {
+			main: "client-certificate: {{cert}}\nclient-key: {{key}}\n",
+			want: map[string]string{"client-certificate": "{{cert}}", "client-key": "{{key}}"},
+		},
+		"Secret in a root-only file": {
+			main: "registration-key: secret\n",
+			want: map[string]string{"registration-key": "********"},
+		},
+
+		"Error when key is unknown": {
+			main:    "endpoint: https://landscape.example.com\n\nendpiont: https://typo.example.com\n",
+			wantErr: []string{`enterprise.yaml:3: unknown key "endpiont"`},
+		},
+		"Error when value is invalid": {
+			main:    "account-name: ACME Corp\n",
+			wantErr: []string{"enterprise.yaml:1: invalid account-name"},
+		},
+		"Error when value is not a string": {
+			main:    "endpoint:\n  - https://landscape.example.com\n",
+			wantErr: []string{"enterprise.yaml:1: endpoint must be a string"},
+		},
+		"Error when value is null": {
+			main:    "endpoint:\n",
+			wantErr: []string{"enterprise.yaml:1: endpoint must be a string"},
+		},
+		"Error when key is duplicated": {
+			main:    "endpoint: https://a.example.com\nendpoint: https://b.example.com\n",
+			wantErr: []string{`enterprise.yaml:2: duplicate key "endpoint"`},
+		},
+		"Error when file is not a mapping": {
+			main:    "- endpoint\n",
+			wantErr: []string{"enterprise.yaml:1: expected a mapping"},
+		},
+		"Error when YAML is invalid": {
+			main:    "endpoint: https://a.example.com\n  bad: [\n",
+			wantErr: []string{"enterprise.yaml: yaml: line"},
+		},
+		"Error when secret is in a file readable by others": {
+			main:    "account-name: acme\nregistration-key: secret\n",
+			mode:    0o644,
+			wantErr: []string{"enterprise.yaml:2: registration-key must not be stored in a file accessible to other users"},
+		},
+		"Error when endpoint is not HTTP": {
+			main:    "endpoint: ftp://example.com\n",
+			wantErr: []string{"enterprise.yaml:1: invalid endpoint"},
+		},
+		"Error when key slot is not reserved": {
+			main:    "key-slot: my-key\n",
+			wantErr: []string{"enterprise.yaml:1: invalid key-slot", "must start with 'enterprise'"},
+		},
+		"Error when CA bundle has no certificate": {
+			main:    "ca-bundle: {{pub}}\n",
+			wantErr: []string{"enterprise.yaml:1: invalid ca-bundle", "no PEM encoded certificate"},
+		},
+		"Error when client certificate has no certificate": {
+			main:    "client-certificate: {{pub}}\n",
+			wantErr: []string{"enterprise.yaml:1: invalid client-certificate", "no PEM encoded certificate"},
+		},
+		"Error when client key is accessible to other users": {
+			main:    "client-key: {{key}}\n",
+			keyMode: 0o640,
+			wantErr: []string{"enterprise.yaml:1: invalid client-key", "must not be accessible to other users"},
+		},
+		"Error when escrow public key is missing": {
+			main:    "escrow-public-key: {{dir}}/missing.pem\n",
+			wantErr: []string{"enterprise.yaml:1: invalid escrow-public-key"},
+		},
+		"Error when drop-in is invalid": {
+			main:    "endpoint: https://a.example.com\n",
+			dropIns: map[string]string{"10-site.yaml": "account-name: acme\nunknown: value\n"},
+			wantErr: []string{`enterprise.d/10-site.yaml:2: unknown key "unknown"`},
+		},
+		"Error when environment value is invalid": {
+			env:     map[string]string{"SNAP_TPMCTL_ENTERPRISE_ENDPOINT": "not a url"},
+			wantErr: []string{"$SNAP_TPMCTL_ENTERPRISE_ENDPOINT: invalid endpoint"},
+		},
+		"Error reports every invalid line": {
+			main:    "endpoint: ftp://example.com\naccount-name: acme\nkey-slot: mine\n",
+			wantErr: []string{"enterprise.yaml:1: invalid endpoint", "enterprise.yaml:3: invalid key-slot"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			pub, _ := testutils.WriteOrgKey(t)
+			ca := filepath.Join(dir, "ca.pem")
+			writeCACert(t, ca)
+			cert, key := testutils.WriteClientCertificate(t, testutils.ClientCertificateOptions{})
+			if tc.keyMode != 0 {
+				be.Err(t, os.Chmod(key, tc.keyMode), nil)
+			}
+			expand := strings.NewReplacer("{{dir}}", dir, "{{pub}}", pub, "{{ca}}", ca, "{{cert}}", cert, "{{key}}", key).Replace
+
+			path := filepath.Join(dir, "enterprise.yaml")
+			if !tc.noMain {
+				mode := tc.mode
+				if mode == 0 {
+					mode = 0o600
+				}
+				be.Err(t, os.WriteFile(path, []byte(expand(tc.main)), mode), nil)
+				be.Err(t, os.Chmod(path, mode), nil)
+			}
+			if len(tc.dropIns) > 0 {
+				be.Err(t, os.Mkdir(filepath.Join(dir, "enterprise.d"), 0o700), nil)
+			}
+			for name, content := range tc.dropIns {
+				be.Err(t, os.WriteFile(filepath.Join(dir, "enterprise.d", name), []byte(expand(content)), 0o600), nil)
+			}
+			lookupEnv := func(key string) (string, bool) {
+				v, ok := tc.env[key]
+				return v, ok
+			}
+
+			cfg, err := enterprise.LoadConfigWithEnv(path, lookupEnv)
+			if len(tc.wantErr) > 0 {
+				for _, want := range tc.wantErr {
+					be.Err(t, err, want)
+				}
+				be.Equal(t, cfg, nil)
+				return
+			}
+			be.Err(t, err, nil)
+
+			for key, want := range tc.want {
+				got, _ := cfg.Get(key)
+				be.Equal(t, got, expand(want))
+			}
+			for key, want := range tc.wantSources {
+				_, got := cfg.Get(key)
+				be.Equal(t, got, expand(want))
+			}
+		})
+	}
+}
+
+func TestConfigRequire(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		endpoint string
+
+		wantErr bool
+	}{
+		"Required keys are set":            {endpoint: "https://landscape.example.com"},
+		"Error when required key is unset": {wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := enterprise.LoadConfigWithEnv(filepath.Join(t.TempDir(), "enterprise.yaml"), func(string) (string, bool) { return "", false })
+			be.Err(t, err, nil)
+			if tc.endpoint != "" {
+				be.Err(t, cfg.Set(enterprise.ConfigEndpoint, tc.endpoint, "--endpoint"), nil)
+			}
+
+			err = cfg.Require(enterprise.ConfigEndpoint, enterprise.ConfigKeySlot)
+			if tc.wantErr {
+				be.Err(t, err, `missing required setting "endpoint"`)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
+
+func TestConfigClientOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		clientCert bool
+		clientKey  bool
+
+		wantOptions int
+		wantErr     bool
+	}{
+		"No client certificate":           {wantOptions: 0},
+		"Client certificate and key":      {clientCert: true, clientKey: true, wantOptions: 1},
+		"Error when key is unset":         {clientCert: true, wantErr: true},
+		"Error when certificate is unset": {clientKey: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cert, key := testutils.WriteClientCertificate(t, testutils.ClientCertificateOptions{})
+			cfg, err := enterprise.LoadConfigWithEnv(filepath.Join(t.TempDir(), "enterprise.yaml"), func(string) (string, bool) { return "", false })
+			be.Err(t, err, nil)
+			if tc.clientCert {
+				be.Err(t, cfg.Set(enterprise.ConfigClientCert, cert, "--test"), nil)
+			}
+			if tc.clientKey {
+				be.Err(t, cfg.Set(enterprise.ConfigClientKey, key, "--test"), nil)
+			}
+
+			opts, err := cfg.ClientOptions()
+			if tc.wantErr {
+				be.Err(t, err, "client-certificate and client-key must be set together")
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, len(opts), tc.wantOptions)
+		})
+	}
+}
+
+func TestConfigCheckLandscape(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		accountName string
+
+		wantAccountName string
+		wantSource      string
+		wantErr         bool
+	}{
+		"Uses the Landscape account when unset": {wantAccountName: "acme", wantSource: "landscape client"},
+		"Matching account name":                 {accountName: "acme", wantAccountName: "acme", wantSource: "--account-name"},
+
+		"Error when account name differs": {accountName: "other", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := enterprise.LoadConfigWithEnv(filepath.Join(t.TempDir(), "enterprise.yaml"), func(string) (string, bool) { return "", false })
+			be.Err(t, err, nil)
+			if tc.accountName != "" {
+				be.Err(t, cfg.Set(enterprise.ConfigAccountName, tc.accountName, "--account-name"), nil)
+			}
+
+			err = cfg.CheckLandscape(&enterprise.LandscapeIdentity{AccountName: "acme", ComputerID: 42})
+			if tc.wantErr {
+				be.Err(t, err, `does not match the Landscape client account "acme"`)
+				return
+			}
+			be.Err(t, err, nil)
+
+			value, source := cfg.Get(enterprise.ConfigAccountName)
+			be.Equal(t, value, tc.wantAccountName)
+			be.Equal(t, source, tc.wantSource)
+		})
+	}
+}
+
+// writeCACert writes a self-signed PEM encoded certificate to path.
+func writeCACert(t *testing.T, path string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	be.Err(t, err, nil)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	be.Err(t, err, nil)
+
+	be.Err(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600), nil)
+}
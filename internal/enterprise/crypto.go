@@ -0,0 +1,160 @@
+package enterprise
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeyEncryptionRSAOAEP is the algorithm used to encrypt escrowed recovery keys.
+const KeyEncryptionRSAOAEP = "RSA-OAEP-SHA256"
+
+// minOrgKeyBits is the smallest organisation RSA key accepted.
+const minOrgKeyBits = 2048
+
+// EncryptedKey is a recovery key encrypted to the organisation public key.
+type EncryptedKey struct {
+	Algorithm string `json:"algorithm"`
+	// KeyFingerprint identifies the organisation key able to decrypt the recovery key.
+	KeyFingerprint string `json:"key-fingerprint"`
+	Ciphertext     []byte `json:"ciphertext"`
+}
+
+// OrgKey is the organisation public key escrowed recovery keys are encrypted to.
+type OrgKey struct {
+	key *rsa.PublicKey
+	// Fingerprint is the hex encoded SHA-256 of the DER encoded public key.
+	Fingerprint string
+}
+
+// LoadOrgKey reads a PEM encoded RSA public key from path.
+func LoadOrgKey(path string) (*OrgKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read organisation public key: %w", err)
+	}
+
+	key, err := ParseOrgKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// ParseOrgKey parses a PEM encoded RSA public key, in PKIX or PKCS #1 form.
+func ParseOrgKey(data []byte) (*OrgKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+
+	var pub any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, expected a public key", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T, expected RSA", pub)
+	}
+	if key.N.BitLen() < minOrgKeyBits {
+		return nil, fmt.Errorf("RSA key of %d bits is too small, at least %d are required", key.N.BitLen(), minOrgKeyBits)
+	}
+
+	return &OrgKey{key: key, Fingerprint: fingerprint(key)}, nil
+}
+
+// Encrypt encrypts the recovery key of the given snapd key ID and machine.
+// Both are bound to the ciphertext, so it cannot be passed off as the key of another record.
+func (k *OrgKey) Encrypt(recoveryKey, keyID string, machine Identity) (*EncryptedKey, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, k.key, []byte(recoveryKey), oaepLabel(keyID, machine))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt recovery key: %w", err)
+	}
+
+	return &EncryptedKey{
+		Algorithm:      KeyEncryptionRSAOAEP,
+		KeyFingerprint: k.Fingerprint,
+		Ciphertext:     ciphertext,
+	}, nil
+}
+
+// LoadPrivateKey reads a PEM encoded RSA private key from path, in PKCS #8 or PKCS #1 form.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded private key found", path)
+	}
+
+	var priv any
+	switch block.Type {
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q, expected a private key", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid private key: %w", path, err)
+	}
+
+	key, ok := priv.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported private key type %T, expected RSA", path, priv)
+	}
+
+	return key, nil
+}
+
+// DecryptRecord returns the recovery key of an escrow record, decrypted with the organisation private key.
+func DecryptRecord(priv *rsa.PrivateKey, record EscrowRecord) (string, error) {
+	enc := record.EncryptedKey
+	if enc.Algorithm != KeyEncryptionRSAOAEP {
+		return "", fmt.Errorf("unsupported key encryption %q", enc.Algorithm)
+	}
+	if got := fingerprint(&priv.PublicKey); enc.KeyFingerprint != got {
+		return "", fmt.Errorf("record is encrypted to key %s, not to this key %s", enc.KeyFingerprint, got)
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), nil, priv, enc.Ciphertext, oaepLabel(record.KeyID, record.Machine))
+	if err != nil {
+		return "", errors.New("failed to decrypt recovery key: the record is corrupted or was altered")
+	}
+
+	return string(plaintext), nil
+}
+
+// oaepLabel binds a ciphertext to the record it belongs to.
+func oaepLabel(keyID string, machine Identity) []byte {
+	return fmt.Appendf(nil, "snap-tpmctl escrow\x00%s\x00%s", machine.MachineID, keyID)
+}
+
+// fingerprint returns the hex encoded SHA-256 of the DER encoded public key.
+func fingerprint(key *rsa.PublicKey) string {
+	// Marshalling a valid RSA public key cannot fail.
+	der, _ := x509.MarshalPKIXPublicKey(key)
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package enterprise_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestParseOrgKey(t *testing.T) {
+	t.Parallel()
+
+	pub, priv := testutils.WriteOrgKey(t)
+	pkix, err := os.ReadFile(pub)
+	be.Err(t, err, nil)
+	privKey, err := enterprise.LoadPrivateKey(priv)
+	be.Err(t, err, nil)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	be.Err(t, err, nil)
+	ecDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	be.Err(t, err, nil)
+
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	be.Err(t, err, nil)
+
+	tests := map[string]struct {
+		data []byte
+
+		wantErr bool
+	}{
+		"PKIX public key":  {data: pkix},
+		"PKCS1 public key": {data: pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privKey.PublicKey)})},
+
+		"Error when not PEM":              {data: []byte("not a key"), wantErr: true},
+		"Error when PEM is a private":     {data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), wantErr: true},
+		"Error when key is invalid":       {data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")}), wantErr: true},
+		"Error when key is not RSA":       {data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: ecDER}), wantErr: true},
+		"Error when RSA key is too small": {data: pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&smallKey.PublicKey)}), wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := enterprise.ParseOrgKey(tc.data)
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, len(got.Fingerprint), 64)
+		})
+	}
+}
+
+func TestDecryptRecord(t *testing.T) {
+	t.Parallel()
+
+	const recoveryKey = "12345-54321-12345-54321-12345-54321-12345-54321"
+	machine := enterprise.Identity{MachineID: "0123456789abcdef"}
+
+	tests := map[string]struct {
+		tamper func(*enterprise.EscrowRecord)
+
+		wantErr string
+	}{
+		"Decrypts the recovery key": {},
+
+		"Error when algorithm is unsupported": {
+			tamper:  func(r *enterprise.EscrowRecord) { r.EncryptedKey.Algorithm = "RSA-PKCS1" },
+			wantErr: "unsupported key encryption",
+		},
+		"Error when encrypted to another key": {
+			tamper:  func(r *enterprise.EscrowRecord) { r.EncryptedKey.KeyFingerprint = "0000" },
+			wantErr: "encrypted to key 0000",
+		},
+		"Error when ciphertext is altered": {
+			tamper:  func(r *enterprise.EscrowRecord) { r.EncryptedKey.Ciphertext[0] ^= 1 },
+			wantErr: "corrupted or was altered",
+		},
+		"Error when record is for another machine": {
+			tamper:  func(r *enterprise.EscrowRecord) { r.Machine.MachineID = "other-machine" },
+			wantErr: "corrupted or was altered",
+		},
+		"Error when record is for another key ID": {
+			tamper:  func(r *enterprise.EscrowRecord) { r.KeyID = "other-key-id" },
+			wantErr: "corrupted or was altered",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pub, priv := testutils.WriteOrgKey(t)
+			orgKey, err := enterprise.LoadOrgKey(pub)
+			be.Err(t, err, nil)
+			privKey, err := enterprise.LoadPrivateKey(priv)
+			be.Err(t, err, nil)
+
+			enc, err := orgKey.Encrypt(recoveryKey, "key-id", machine)
+			be.Err(t, err, nil)
+			be.Equal(t, enc.Algorithm, enterprise.KeyEncryptionRSAOAEP)
+			be.Equal(t, enc.KeyFingerprint, orgKey.Fingerprint)
+
+			record := enterprise.EscrowRecord{KeyID: "key-id", EncryptedKey: *enc, Machine: machine}
+			if tc.tamper != nil {
+				tc.tamper(&record)
+			}
+
+			got, err := enterprise.DecryptRecord(privKey, record)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, recoveryKey)
+		})
+	}
+}
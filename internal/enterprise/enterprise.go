@@ -1,2 +1,451 @@
 // Package enterprise provides enterprise TPM/FDE management features
 package enterprise
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/snapd"
+)
+
+// DefaultKeySlot is the keyslot of the escrowed enterprise recovery key, unless configured otherwise.
+// Keyslot names starting with "enterprise" are reserved for escrowed keys.
+const DefaultKeySlot = "enterprise-recovery"
+
+// DefaultMachineIDPath is the file holding the systemd machine ID.
+const DefaultMachineIDPath = "/etc/machine-id"
+
+// escrowTimeout bounds a single request to the escrow endpoint.
+const escrowTimeout = 30 * time.Second
+
+// keyGenerator defines the interface for snapd operations needed to generate a key to escrow.
+type keyGenerator interface {
+	EnumerateKeySlots(ctx context.Context) (*snapd.SystemVolumesResult, error)
+	GenerateRecoveryKey(ctx context.Context) (*snapd.GenerateRecoveryKeyResult, error)
+}
+
+// keyEscrower defines the interface for snapd operations needed to escrow a recovery key.
+type keyEscrower interface {
+	keyGenerator
+	AddRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+}
+
+// keyRotator defines the interface for snapd operations needed to rotate an escrowed recovery key.
+type keyRotator interface {
+	keyGenerator
+	ReplaceRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+}
+
+// Identity identifies the machine a recovery key belongs to.
+type Identity struct {
+	MachineID string `json:"machine-id"`
+	Hostname  string `json:"hostname,omitempty"`
+	// Landscape ties the key to the Landscape registration of the machine, if any.
+	Landscape *LandscapeIdentity `json:"landscape,omitempty"`
+}
+
+// ReadIdentity returns the identity of the running machine, read from the machine ID file.
+func ReadIdentity(machineIDPath string) (Identity, error) {
+	data, err := os.ReadFile(machineIDPath)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to read machine ID: %w", err)
+	}
+
+	id := strings.TrimSpace(string(data))
+	if id == "" {
+		return Identity{}, fmt.Errorf("machine ID in %s is empty", machineIDPath)
+	}
+
+	// The hostname is informative only: the machine ID is what identifies the machine.
+	hostname, _ := os.Hostname()
+
+	return Identity{MachineID: id, Hostname: hostname}, nil
+}
+
+// EscrowRecord is the document sent to the escrow endpoint.
+// The recovery key never leaves the machine unencrypted.
+type EscrowRecord struct {
+	KeyID        string       `json:"key-id"`
+	KeySlot      string       `json:"keyslot"`
+	EncryptedKey EncryptedKey `json:"encrypted-recovery-key"`
+	Machine      Identity     `json:"machine"`
+	Created      time.Time    `json:"created"`
+
+	// Fingerprint is the fde.Fingerprint of the recovery key salted with FingerprintSalt, for
+	// helpdesk to check a key read out by a user without decrypting the record.
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSalt string `json:"fingerprint-salt"`
+}
+
+// escrowReceipt is the acknowledgement of the escrow endpoint.
+type escrowReceipt struct {
+	ID string `json:"id"`
+}
+
+// revokeRequest asks the escrow endpoint to revoke the records superseded by a new one.
+type revokeRequest struct {
+	Machine      Identity `json:"machine"`
+	KeySlot      string   `json:"keyslot"`
+	SupersededBy string   `json:"superseded-by"`
+}
+
+// revokeReceipt is the acknowledgement of a revocation.
+type revokeReceipt struct {
+	Revoked []string `json:"revoked"`
+}
+
+// Client submits recovery keys, encrypted to the organisation key, to an escrow endpoint.
+type Client struct {
+	endpoint   string
+	orgKey     *OrgKey
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the escrow endpoint at the given URL, encrypting
+// recovery keys to the organisation key. Without an organisation key, the client can
+// only upload records which are already encrypted, like the spooled ones.
+//
+// Options pin the server certificate authorities and authenticate the machine with a
+// client certificate, both requiring an https endpoint.
+func NewClient(endpoint string, orgKey *OrgKey, opts ...ClientOption) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid escrow endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid escrow endpoint %q: expected an http or https URL", endpoint)
+	}
+
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	tlsConfig, err := o.newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid escrow endpoint %q: a CA bundle or a client certificate requires an https URL", endpoint)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{
+		endpoint: u.String(),
+		orgKey:   orgKey,
+		httpClient: &http.Client{
+			Timeout:       escrowTimeout,
+			Transport:     transport,
+			CheckRedirect: checkRedirect,
+		},
+	}, nil
+}
+
+// ErrUnreachable is returned when the escrow endpoint cannot be reached.
+var ErrUnreachable = errors.New("failed to reach escrow endpoint")
+
+// EndpointError is returned when the escrow endpoint answers with an error status.
+type EndpointError struct {
+	StatusCode int
+	Status     string
+	// Message is the beginning of the response body, to help diagnose the server.
+	Message string
+}
+
+func (e *EndpointError) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// IsTemporary returns true if the error is worth retrying later, as the endpoint was
+// unreachable or temporarily unable to store the record.
+func IsTemporary(err error) bool {
+	if errors.Is(err, ErrUnreachable) {
+		return true
+	}
+	var endpointErr *EndpointError
+	if errors.As(err, &endpointErr) {
+		return endpointErr.StatusCode >= http.StatusInternalServerError || endpointErr.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Submit posts the record to the escrow endpoint and returns the ID under which it was stored.
+// The record is only considered escrowed once the endpoint acknowledged it with an ID.
+func (c *Client) Submit(ctx context.Context, record EscrowRecord) (string, error) {
+	var receipt escrowReceipt
+	if err := c.post(ctx, c.endpoint, record, &receipt); err != nil {
+		return "", rejected("escrow endpoint rejected the key", err)
+	}
+	if receipt.ID == "" {
+		return "", errors.New("invalid escrow response: missing record ID")
+	}
+
+	return receipt.ID, nil
+}
+
+// Revoke marks every record escrowed for the machine keyslot as revoked, except the
+// record superseding them. It returns the IDs of the revoked records.
+func (c *Client) Revoke(ctx context.Context, machine Identity, keySlot, supersededBy string) ([]string, error) {
+	endpoint, err := url.JoinPath(c.endpoint, "revoke")
+	if err != nil {
+		return nil, err
+	}
+
+	req := revokeRequest{Machine: machine, KeySlot: keySlot, SupersededBy: supersededBy}
+	var receipt revokeReceipt
+	if err := c.post(ctx, endpoint, req, &receipt); err != nil {
+		return nil, rejected("escrow endpoint rejected the revocation", err)
+	}
+
+	return receipt.Revoked, nil
+}
+
+// rejected prefixes errors returned by the endpoint with msg. Other errors already explain themselves.
+func rejected(msg string, err error) error {
+	var endpointErr *EndpointError
+	if errors.As(err, &endpointErr) {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return err
+}
+
+// post sends body as JSON to the endpoint and decodes the JSON acknowledgement into out.
+func (c *Client) post(ctx context.Context, endpoint string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if errors.Is(err, ErrInsecureRedirect) {
+		// This is a misconfiguration of the endpoint, retrying would not help.
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response: %w", ErrUnreachable, err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		// Error bodies are only shown to help diagnose the server, so keep them short.
+		msg := strings.TrimSpace(string(data))
+		if len(msg) > 200 {
+			msg = msg[:200] + "..."
+		}
+		return &EndpointError{StatusCode: resp.StatusCode, Status: resp.Status, Message: msg}
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid escrow response: %w", err)
+	}
+
+	return nil
+}
+
+// EscrowResult contains the result of escrowing a recovery key. It never holds the key itself.
+type EscrowResult struct {
+	KeyID    string
+	KeySlot  string
+	EscrowID string
+	// SpoolID is set instead of EscrowID when the endpoint was unavailable and the
+	// record was spooled for a later upload.
+	SpoolID string
+	// Revoked are the IDs of the escrow records superseded by a rotation.
+	Revoked []string
+	Status  string
+
+	// Fingerprint and FingerprintSalt are the ones of the escrow record.
+	Fingerprint     string
+	FingerprintSalt string
+}
+
+// Pending returns true if the key is not escrowed yet, but waiting in the spool.
+func (r *EscrowResult) Pending() bool {
+	return r.SpoolID != ""
+}
+
+// escrowRef describes where the key went, for error messages.
+func (r *EscrowResult) escrowRef() string {
+	if r.Pending() {
+		return "spooled for escrow as " + r.SpoolID
+	}
+	return "escrowed as " + r.EscrowID
+}
+
+// Escrow generates a recovery key, submits it to the escrow endpoint and adds it to the
+// reserved enterprise keyslot of the system volumes.
+//
+// The key is escrowed before it is added, so that no keyslot ever holds a key the
+// organisation cannot recover. If the endpoint is temporarily unavailable and a spool is
+// given, the encrypted record is spooled instead and the result is pending.
+// The key is never returned to the caller.
+func Escrow(ctx context.Context, client keyEscrower, escrow *Client, spool *Spool, machine Identity, keySlot string) (*EscrowResult, error) {
+	exists, err := hasKeySlot(ctx, client, keySlot)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("enterprise recovery key already exists in keyslot %q, regenerate it instead", keySlot)
+	}
+
+	result, err := generateAndEscrow(ctx, client, escrow, spool, machine, keySlot)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.AddRecoveryKey(ctx, result.KeyID, []snapd.KeySlot{{Name: keySlot}})
+	if err != nil {
+		return nil, fmt.Errorf("recovery key %s but not added: %w", result.escrowRef(), err)
+	}
+	if !resp.IsOK() {
+		return nil, fmt.Errorf("recovery key %s but not added: %s", result.escrowRef(), resp.Err)
+	}
+
+	result.Status = resp.Status
+
+	return result, nil
+}
+
+// Rotate replaces the enterprise recovery key with a newly generated one and re-escrows it.
+//
+// The new key is escrowed before it replaces the old one, so that the machine never holds a
+// key the escrow endpoint does not know. Once replaced, the records of the old key are revoked.
+// If the revocation fails, the result is still returned along with the error, as the key was
+// already rotated.
+func Rotate(ctx context.Context, client keyRotator, escrow *Client, machine Identity, keySlot string) (*EscrowResult, error) {
+	exists, err := hasKeySlot(ctx, client, keySlot)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no enterprise recovery key found in keyslot %q, create it first", keySlot)
+	}
+
+	// Never spool a rotation: the spool is on the encrypted disk itself, so the old key must
+	// stay in use until the endpoint acknowledged the new one.
+	result, err := generateAndEscrow(ctx, client, escrow, nil, machine, keySlot)
+	if err != nil {
+		return nil, err
+	}
+
+	// The old key remains valid and escrowed until snapd replaced it.
+	resp, err := client.ReplaceRecoveryKey(ctx, result.KeyID, []snapd.KeySlot{{Name: keySlot}})
+	if err != nil {
+		return nil, fmt.Errorf("recovery key %s but not replaced: %w", result.escrowRef(), err)
+	}
+	if !resp.IsOK() {
+		return nil, fmt.Errorf("recovery key %s but not replaced: %s", result.escrowRef(), resp.Err)
+	}
+
+	result.Status = resp.Status
+
+	result.Revoked, err = escrow.Revoke(ctx, machine, keySlot, result.EscrowID)
+	if err != nil {
+		return result, fmt.Errorf("recovery key rotated but the previous escrow records were not revoked: %w", err)
+	}
+
+	return result, nil
+}
+
+// hasKeySlot returns true if a system volume has the keyslot.
+func hasKeySlot(ctx context.Context, client keyGenerator, keySlot string) (bool, error) {
+	volumes, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	for _, volumeInfo := range volumes.ByContainerRole {
+		if _, ok := volumeInfo.KeySlots[keySlot]; ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// generateAndEscrow generates a recovery key and escrows it, or spools it if a spool is given
+// and the endpoint is temporarily unavailable.
+func generateAndEscrow(ctx context.Context, client keyGenerator, escrow *Client, spool *Spool, machine Identity, keySlot string) (*EscrowResult, error) {
+	if escrow.orgKey == nil {
+		return nil, errors.New("missing organisation public key")
+	}
+
+	key, err := client.GenerateRecoveryKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+
+	parsed, err := fde.ParseRecoveryKey(key.RecoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery key generated by snapd: %w", err)
+	}
+	salt, err := fde.NewFingerprintSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := escrow.orgKey.Encrypt(key.RecoveryKey, key.KeyID, machine)
+	if err != nil {
+		return nil, err
+	}
+
+	record := EscrowRecord{
+		KeyID:        key.KeyID,
+		KeySlot:      keySlot,
+		EncryptedKey: *enc,
+		Machine:      machine,
+		Created:      time.Now().UTC(),
+
+		Fingerprint:     fde.Fingerprint(parsed, salt),
+		FingerprintSalt: hex.EncodeToString(salt),
+	}
+	result := &EscrowResult{
+		KeyID:           key.KeyID,
+		KeySlot:         keySlot,
+		Fingerprint:     record.Fingerprint,
+		FingerprintSalt: record.FingerprintSalt,
+	}
+
+	result.EscrowID, err = escrow.Submit(ctx, record)
+	if err == nil {
+		return result, nil
+	}
+	if spool == nil || !IsTemporary(err) {
+		return nil, err
+	}
+
+	sr, sErr := spool.Add(record)
+	if sErr != nil {
+		return nil, errors.Join(err, sErr)
+	}
+	result.SpoolID = sr.ID
+
+	return result, nil
+}
@@ -1 +1,317 @@
 package enterprise_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestEscrow(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		serverStatus int
+		serverBody   string
+		serverDown   bool
+
+		enterpriseKeySlot bool
+		generateKeyFails  bool
+		addKeyFails       bool
+		withSpool         bool
+
+		wantRecords int
+		wantSpooled int
+		wantErr     string
+	}{
+		"Success":                                  {wantRecords: 1},
+		"Success with spool when server is up":     {withSpool: true, wantRecords: 1},
+		"Spools record when server is unavailable": {withSpool: true, serverStatus: http.StatusServiceUnavailable, wantSpooled: 1},
+		"Spools record when server is unreachable": {withSpool: true, serverDown: true, wantSpooled: 1},
+
+		"Error when server rejects the key with spool": {withSpool: true, serverStatus: http.StatusForbidden, wantErr: "403 Forbidden"},
+		"Error when add key fails after spooling":      {withSpool: true, serverStatus: http.StatusBadGateway, addKeyFails: true, wantSpooled: 1, wantErr: "spooled for escrow as"},
+
+		"Error when enterprise keyslot exists":  {enterpriseKeySlot: true, wantErr: "already exists"},
+		"Error when generate key fails":         {generateKeyFails: true, wantErr: "failed to generate recovery key"},
+		"Error when server rejects the key":     {serverStatus: http.StatusForbidden, wantErr: "403 Forbidden"},
+		"Error when server is unreachable":      {serverDown: true, wantErr: "failed to reach escrow endpoint"},
+		"Error when server does not return ID":  {serverBody: `{}`, wantRecords: 1, wantErr: "missing record ID"},
+		"Error when server returns invalid ack": {serverBody: `not json`, wantRecords: 1, wantErr: "invalid escrow response"},
+		"Error when add key fails":              {addKeyFails: true, wantRecords: 1, wantErr: "escrowed as escrow-1 but not added"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := testutils.NewEscrowServer(t, testutils.EscrowServerOptions{Status: tc.serverStatus, Body: tc.serverBody})
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnterpriseKeySlot: tc.enterpriseKeySlot,
+				GenerateKeyError:  tc.generateKeyFails,
+				AddKeyError:       tc.addKeyFails,
+			})
+			escrow, priv := newTestClient(t, server.URL+"/escrow")
+			if tc.serverDown {
+				server.Close()
+			}
+			var spool *enterprise.Spool
+			if tc.withSpool {
+				spool = enterprise.NewSpool(t.TempDir())
+			}
+
+			machine := enterprise.Identity{
+				MachineID: "0123456789abcdef",
+				Hostname:  "host",
+				Landscape: &enterprise.LandscapeIdentity{AccountName: "acme", ComputerID: 42},
+			}
+			res, err := enterprise.Escrow(context.Background(), mockClient, escrow, spool, machine, enterprise.DefaultKeySlot)
+
+			records := server.Records()
+			be.Equal(t, len(records), tc.wantRecords)
+			if spool != nil {
+				spooled, sErr := spool.Pending()
+				be.Err(t, sErr, nil)
+				be.Equal(t, len(spooled), tc.wantSpooled)
+			}
+
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				be.Equal(t, res, nil)
+				return
+			}
+			be.Err(t, err, nil)
+
+			if tc.wantSpooled > 0 {
+				be.True(t, res.Pending())
+				be.Equal(t, res.EscrowID, "")
+				return
+			}
+			be.True(t, !res.Pending())
+
+			be.Equal(t, res.KeyID, "test-key-id-12345")
+			be.Equal(t, res.KeySlot, enterprise.DefaultKeySlot)
+			be.Equal(t, res.EscrowID, "escrow-1")
+
+			rec := records[0]
+			be.Equal(t, rec.ContentType, "application/json")
+			be.Equal(t, rec.KeyID, "test-key-id-12345")
+			be.Equal(t, rec.KeySlot, enterprise.DefaultKeySlot)
+			be.Equal(t, rec.RecoveryKey, "")
+			be.Equal(t, decryptRecord(t, priv, rec), "12345-54321-12345-54321-12345-54321-12345-54321")
+			be.Equal(t, rec.Machine.MachineID, machine.MachineID)
+			be.Equal(t, rec.Machine.Hostname, machine.Hostname)
+			be.Equal(t, rec.Machine.Landscape.AccountName, "acme")
+			be.Equal(t, rec.Machine.Landscape.ComputerID, int64(42))
+			be.True(t, !rec.Created.IsZero())
+
+			// The fingerprint identifies the key without disclosing it.
+			be.Equal(t, rec.Fingerprint, res.Fingerprint)
+			be.Equal(t, rec.FingerprintSalt, res.FingerprintSalt)
+			salt, err := hex.DecodeString(rec.FingerprintSalt)
+			be.Err(t, err, nil)
+			key, err := fde.ParseRecoveryKey("12345-54321-12345-54321-12345-54321-12345-54321")
+			be.Err(t, err, nil)
+			be.Equal(t, rec.Fingerprint, fde.Fingerprint(key, salt))
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		serverStatus int
+		revokeStatus int
+
+		noEnterpriseKeySlot bool
+		generateKeyFails    bool
+		replaceKeyFails     bool
+		serverDown          bool
+
+		wantRecords  int
+		wantRevoked  []string
+		wantReplaced bool
+		wantResult   bool
+		wantErr      string
+	}{
+		"Success": {wantRecords: 3, wantRevoked: []string{"escrow-1"}, wantReplaced: true, wantResult: true},
+
+		"Error when enterprise keyslot is missing":       {noEnterpriseKeySlot: true, wantRecords: 2, wantErr: "create it first"},
+		"Error when generate key fails":                  {generateKeyFails: true, wantRecords: 2, wantErr: "failed to generate recovery key"},
+		"Error when server rejects the key":              {serverStatus: http.StatusForbidden, wantRecords: 2, wantErr: "403 Forbidden"},
+		"Error when server is unavailable keeps the key": {serverStatus: http.StatusServiceUnavailable, wantRecords: 2, wantErr: "503 Service Unavailable"},
+		"Error when server is unreachable keeps the key": {serverDown: true, wantRecords: 2, wantErr: "failed to reach escrow endpoint"},
+		"Error when replace key fails":                   {replaceKeyFails: true, wantRecords: 3, wantReplaced: true, wantErr: "escrowed as escrow-3 but not replaced"},
+		"Error when revocation fails":                    {revokeStatus: http.StatusInternalServerError, wantRecords: 3, wantReplaced: true, wantResult: true, wantErr: "previous escrow records were not revoked"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := testutils.NewEscrowServer(t, testutils.EscrowServerOptions{Status: tc.serverStatus, RevokeStatus: tc.revokeStatus})
+			machine := enterprise.Identity{MachineID: "0123456789abcdef", Hostname: "host"}
+			server.AddRecord(testutils.EscrowRecord{KeySlot: enterprise.DefaultKeySlot, Machine: testutils.EscrowMachine{MachineID: machine.MachineID}})
+			server.AddRecord(testutils.EscrowRecord{KeySlot: enterprise.DefaultKeySlot, Machine: testutils.EscrowMachine{MachineID: "other-machine"}})
+
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnterpriseKeySlot: !tc.noEnterpriseKeySlot,
+				GenerateKeyError:  tc.generateKeyFails,
+				ReplaceKeyError:   tc.replaceKeyFails,
+			})
+			escrow, priv := newTestClient(t, server.URL)
+			if tc.serverDown {
+				server.Close()
+			}
+
+			res, err := enterprise.Rotate(context.Background(), mockClient, escrow, machine, enterprise.DefaultKeySlot)
+
+			records := server.Records()
+			be.Equal(t, len(records), tc.wantRecords)
+
+			// The old key stays in use until the endpoint acknowledged the new one.
+			var wantCalls []string
+			if tc.wantReplaced {
+				wantCalls = []string{"ReplaceRecoveryKey test-key-id-12345"}
+			}
+			be.Equal(t, mockClient.Calls(), wantCalls)
+
+			var revoked []string
+			for _, rec := range records {
+				if rec.Revoked {
+					revoked = append(revoked, rec.ID)
+				}
+			}
+			be.Equal(t, revoked, tc.wantRevoked)
+
+			if tc.wantResult {
+				be.Equal(t, res.KeyID, "test-key-id-12345")
+				be.Equal(t, res.EscrowID, "escrow-3")
+				be.Equal(t, res.Revoked, tc.wantRevoked)
+				be.Equal(t, records[2].RecoveryKey, "")
+				be.Equal(t, decryptRecord(t, priv, records[2]), "12345-54321-12345-54321-12345-54321-12345-54321")
+			} else {
+				be.Equal(t, res, nil)
+			}
+
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		endpoint string
+
+		wantErr bool
+	}{
+		"HTTPS endpoint": {endpoint: "https://landscape.example.com/api/escrow"},
+		"HTTP endpoint":  {endpoint: "http://127.0.0.1:8080/escrow"},
+
+		"Error when endpoint is empty":      {endpoint: "", wantErr: true},
+		"Error when endpoint has no host":   {endpoint: "https:///escrow", wantErr: true},
+		"Error when endpoint is not HTTP":   {endpoint: "ftp://example.com/escrow", wantErr: true},
+		"Error when endpoint is not an URL": {endpoint: "://", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			pub, _ := testutils.WriteOrgKey(t)
+			orgKey, err := enterprise.LoadOrgKey(pub)
+			be.Err(t, err, nil)
+
+			_, err = enterprise.NewClient(tc.endpoint, orgKey)
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
+
+func TestReadIdentity(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		missing bool
+
+		want    string
+		wantErr bool
+	}{
+		"Machine ID":                {content: "0123456789abcdef\n", want: "0123456789abcdef"},
+		"Error when file is empty":  {content: "\n", wantErr: true},
+		"Error when file not found": {missing: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "machine-id")
+			if !tc.missing {
+				be.Err(t, os.WriteFile(path, []byte(tc.content), 0o600), nil)
+			}
+
+			got, err := enterprise.ReadIdentity(path)
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got.MachineID, tc.want)
+		})
+	}
+}
+
+// newTestClient returns a client of the escrow endpoint and the organisation private key.
+func newTestClient(t *testing.T, endpoint string) (*enterprise.Client, *rsa.PrivateKey) {
+	t.Helper()
+
+	pub, priv := testutils.WriteOrgKey(t)
+	orgKey, err := enterprise.LoadOrgKey(pub)
+	be.Err(t, err, nil)
+	privKey, err := enterprise.LoadPrivateKey(priv)
+	be.Err(t, err, nil)
+
+	client, err := enterprise.NewClient(endpoint, orgKey)
+	be.Err(t, err, nil)
+
+	return client, privKey
+}
+
+// decryptRecord returns the recovery key of a record received by the escrow server.
+func decryptRecord(t *testing.T, priv *rsa.PrivateKey, rec testutils.EscrowRecord) string {
+	t.Helper()
+
+	var enc enterprise.EncryptedKey
+	be.Err(t, json.Unmarshal(rec.EncryptedKey, &enc), nil)
+
+	key, err := enterprise.DecryptRecord(priv, enterprise.EscrowRecord{
+		KeyID:        rec.KeyID,
+		EncryptedKey: enc,
+		Machine:      enterprise.Identity{MachineID: rec.Machine.MachineID},
+	})
+	be.Err(t, err, nil)
+
+	return key
+}
@@ -0,0 +1,6 @@
+package enterprise
+
+// Export private functions for testing.
+var (
+	LoadConfigWithEnv = loadConfig
+)
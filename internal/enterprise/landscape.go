@@ -0,0 +1,265 @@
+package enterprise
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Landscape client defaults.
+const (
+	DefaultLandscapeConfigPath = "/etc/landscape/client.conf"
+	defaultLandscapeDataPath   = "/var/lib/landscape/client"
+)
+
+var (
+	// ErrLandscapeNotConfigured is returned when the Landscape client is not configured on the machine.
+	ErrLandscapeNotConfigured = errors.New("landscape client is not configured")
+	// ErrLandscapeNotRegistered is returned when the Landscape client is configured, but the
+	// machine is not registered, or its registration was not accepted yet.
+	ErrLandscapeNotRegistered = errors.New("machine is not registered with Landscape")
+)
+
+// LandscapeIdentity is the registration of the machine with Landscape.
+type LandscapeIdentity struct {
+	AccountName   string `json:"account-name"`
+	ComputerTitle string `json:"computer-title,omitempty"`
+	// ServerURL is the message system URL of the Landscape server.
+	ServerURL string `json:"server-url,omitempty"`
+	// ComputerID is the public ID of the computer in Landscape. The secure ID, which
+	// authenticates the client to Landscape, is never read.
+	ComputerID int64 `json:"computer-id"`
+}
+
+// ReadLandscapeIdentity returns the Landscape registration of the machine, from the Landscape
+// client configuration at path and the computer identity stored by the client.
+//
+// ErrLandscapeNotConfigured is returned when the client is not configured and
+// ErrLandscapeNotRegistered, with the reason, when the registration is missing or pending.
+func ReadLandscapeIdentity(path string) (*LandscapeIdentity, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s not found", ErrLandscapeNotConfigured, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Landscape client configuration: %w", err)
+	}
+	defer f.Close()
+
+	sections, err := parseINI(path, f)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ok := sections["client"]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no [client] section", ErrLandscapeNotConfigured, path)
+	}
+	if client["account_name"] == "" {
+		return nil, fmt.Errorf("%w: account_name is not set in %s", ErrLandscapeNotConfigured, path)
+	}
+
+	id := &LandscapeIdentity{
+		AccountName:   client["account_name"],
+		ComputerTitle: client["computer_title"],
+		ServerURL:     client["url"],
+	}
+
+	dataPath := client["data_path"]
+	if dataPath == "" {
+		dataPath = defaultLandscapeDataPath
+	}
+	id.ComputerID, err = readComputerID(filepath.Join(dataPath, "broker.bpickle"))
+	if err != nil {
+		return nil, err
+	}
+
+	return id, nil
+}
+
+// readComputerID returns the computer ID stored by the Landscape broker once registered.
+func readComputerID(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("%w: no computer identity in %s, run landscape-config to register", ErrLandscapeNotRegistered, path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read Landscape computer identity: %w", err)
+	}
+
+	v, err := decodeBPickle(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	persist, _ := v.(map[string]any)
+	registration, _ := persist["registration"].(map[string]any)
+
+	// The secure ID is set when the client sent its registration, the computer ID once
+	// the server accepted it.
+	if _, ok := registration["secure-id"]; !ok {
+		return 0, fmt.Errorf("%w: no registration in %s, run landscape-config to register", ErrLandscapeNotRegistered, path)
+	}
+	computerID, ok := registration["insecure-id"].(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: registration pending, it must be accepted on the Landscape server", ErrLandscapeNotRegistered)
+	}
+
+	return computerID, nil
+}
+
+// parseINI parses an INI file in the format of the Python configparser used by the
+// Landscape client: keys are lowercased, "=" and ":" are both delimiters, lines starting
+// with "#" or ";" are comments and indented lines continue the previous value.
+// Errors are prefixed with the file name and line.
+func parseINI(path string, r io.Reader) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+	var section map[string]string
+	var lastKey string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if section == nil || lastKey == "" {
+				return nil, fmt.Errorf("%s:%d: unexpected continuation line", path, n)
+			}
+			section[lastKey] = strings.TrimSpace(section[lastKey] + "\n" + trimmed)
+			continue
+		case trimmed[0] == '[':
+			name, ok := strings.CutSuffix(trimmed[1:], "]")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("%s:%d: invalid section header %q", path, n, trimmed)
+			}
+			if _, ok := sections[name]; !ok {
+				sections[name] = make(map[string]string)
+			}
+			section, lastKey = sections[name], ""
+			continue
+		}
+
+		if section == nil {
+			return nil, fmt.Errorf("%s:%d: key outside of a section", path, n)
+		}
+		i := strings.IndexAny(trimmed, "=:")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value, got %q", path, n, trimmed)
+		}
+		lastKey = strings.ToLower(strings.TrimSpace(trimmed[:i]))
+		section[lastKey] = strings.TrimSpace(trimmed[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return sections, nil
+}
+
+// decodeBPickle decodes the bpickle serialization used by the Landscape client to persist
+// its state. Dictionaries are decoded as map[string]any, integers as int64 and strings,
+// whether bytes or unicode, as string.
+func decodeBPickle(data []byte) (any, error) {
+	v, rest, err := decodeBPickleValue(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bpickle data: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("invalid bpickle data: %d trailing bytes", len(rest))
+	}
+	return v, nil
+}
+
+func decodeBPickleValue(data []byte) (any, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errors.New("unexpected end of data")
+	}
+
+	switch data[0] {
+	case 'n':
+		return nil, data[1:], nil
+	case 'b':
+		if len(data) < 2 {
+			return nil, nil, errors.New("unexpected end of data")
+		}
+		return data[1] == '1', data[2:], nil
+	case 'i':
+		s, rest, err := cutBPickle(data[1:], ';')
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		return v, rest, err
+	case 'f':
+		s, rest, err := cutBPickle(data[1:], ';')
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		return v, rest, err
+	case 's', 'u':
+		s, rest, err := cutBPickle(data[1:], ':')
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 0 || size > len(rest) {
+			return nil, nil, fmt.Errorf("invalid string length %q", s)
+		}
+		return string(rest[:size]), rest[size:], nil
+	case 'l', 't':
+		var list []any
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != ';' {
+			var v any
+			var err error
+			v, rest, err = decodeBPickleValue(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+		}
+		if len(rest) == 0 {
+			return nil, nil, errors.New("unterminated list")
+		}
+		return list, rest[1:], nil
+	case 'd':
+		dict := make(map[string]any)
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != ';' {
+			var k, v any
+			var err error
+			if k, rest, err = decodeBPickleValue(rest); err != nil {
+				return nil, nil, err
+			}
+			if v, rest, err = decodeBPickleValue(rest); err != nil {
+				return nil, nil, err
+			}
+			dict[fmt.Sprint(k)] = v
+		}
+		if len(rest) == 0 {
+			return nil, nil, errors.New("unterminated dictionary")
+		}
+		return dict, rest[1:], nil
+	default:
+		return nil, nil, fmt.Errorf("unknown type %q", data[0])
+	}
+}
+
+// cutBPickle returns the data before the separator and the data after it.
+func cutBPickle(data []byte, sep byte) (string, []byte, error) {
+	before, after, ok := bytes.Cut(data, []byte{sep})
+	if !ok {
+		return "", nil, errors.New("unexpected end of data")
+	}
+	return string(before), after, nil
+}
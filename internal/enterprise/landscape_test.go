@@ -0,0 +1,134 @@
+package enterprise_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+)
+
+func TestReadLandscapeIdentity(t *testing.T) {
+	t.Parallel()
+
+	const registered = "ds12:registrationds9:secure-idu3:abcs11:insecure-idi42;;;"
+
+	tests := map[string]struct {
+		conf    string
+		noConf  bool
+		bpickle string
+
+		want          *enterprise.LandscapeIdentity
+		wantErrTarget error
+		wantErr       string
+	}{
+		"Registered machine": {
+			conf:    "[client]\naccount_name = acme\ncomputer_title = web-1\nurl = https://landscape.example.com/message-system\ndata_path = {{dir}}\n",
+			bpickle: registered,
+			want: &enterprise.LandscapeIdentity{
+				AccountName:   "acme",
+				ComputerTitle: "web-1",
+				ServerURL:     "https://landscape.example.com/message-system",
+				ComputerID:    42,
+			},
+		},
+		"Comments, colon delimiters and continuation lines": {
+			conf:    "# Landscape\n; client\n[client]\nAccount_Name: acme\ncomputer_title = web\n  1\ndata_path = {{dir}}\n[other]\naccount_name = ignored\n",
+			bpickle: registered,
+			want:    &enterprise.LandscapeIdentity{AccountName: "acme", ComputerTitle: "web\n1", ComputerID: 42},
+		},
+
+		"Error when configuration is missing": {
+			noConf:        true,
+			wantErrTarget: enterprise.ErrLandscapeNotConfigured,
+			wantErr:       "not found",
+		},
+		"Error when client section is missing": {
+			conf:          "[other]\naccount_name = acme\n",
+			wantErrTarget: enterprise.ErrLandscapeNotConfigured,
+			wantErr:       "has no [client] section",
+		},
+		"Error when account name is missing": {
+			conf:          "[client]\ncomputer_title = web-1\n",
+			wantErrTarget: enterprise.ErrLandscapeNotConfigured,
+			wantErr:       "account_name is not set",
+		},
+		"Error when computer identity is missing": {
+			conf:          "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			wantErrTarget: enterprise.ErrLandscapeNotRegistered,
+			wantErr:       "run landscape-config to register",
+		},
+		"Error when registration was not sent": {
+			conf:          "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			bpickle:       "ds7:messageds5:counti3;;;",
+			wantErrTarget: enterprise.ErrLandscapeNotRegistered,
+			wantErr:       "no registration in",
+		},
+		"Error when registration is pending": {
+			conf:          "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			bpickle:       "ds12:registrationds9:secure-idu3:abcs11:insecure-idn;;",
+			wantErrTarget: enterprise.ErrLandscapeNotRegistered,
+			wantErr:       "registration pending",
+		},
+		"Error when section header is invalid": {
+			conf:    "[client\naccount_name = acme\n",
+			wantErr: "client.conf:1: invalid section header",
+		},
+		"Error when key is outside of a section": {
+			conf:    "# Landscape\naccount_name = acme\n",
+			wantErr: "client.conf:2: key outside of a section",
+		},
+		"Error when line has no delimiter": {
+			conf:    "[client]\naccount_name\n",
+			wantErr: "client.conf:2: expected key = value",
+		},
+		"Error when continuation line has no key": {
+			conf:    "[client]\n  acme\n",
+			wantErr: "client.conf:2: unexpected continuation line",
+		},
+		"Error when computer identity is corrupted": {
+			conf:    "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			bpickle: "ds12:registrationds9:secure-idu3:abc",
+			wantErr: "invalid bpickle data",
+		},
+		"Error when computer identity has trailing data": {
+			conf:    "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			bpickle: registered + "n",
+			wantErr: "1 trailing bytes",
+		},
+		"Error when computer identity has an unknown type": {
+			conf:    "[client]\naccount_name = acme\ndata_path = {{dir}}\n",
+			bpickle: "x",
+			wantErr: "unknown type",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "client.conf")
+			if !tc.noConf {
+				conf := strings.ReplaceAll(tc.conf, "{{dir}}", dir)
+				be.Err(t, os.WriteFile(path, []byte(conf), 0o600), nil)
+			}
+			if tc.bpickle != "" {
+				be.Err(t, os.WriteFile(filepath.Join(dir, "broker.bpickle"), []byte(tc.bpickle), 0o600), nil)
+			}
+
+			got, err := enterprise.ReadLandscapeIdentity(path)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				if tc.wantErrTarget != nil {
+					be.Err(t, err, tc.wantErrTarget)
+				}
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, tc.want)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package enterprise
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"snap-tpmctl/internal/fileutils"
+)
+
+// Backoff between upload attempts of a spooled record.
+const (
+	spoolMinBackoff = time.Minute
+	spoolMaxBackoff = 6 * time.Hour
+)
+
+// SpooledRecord is an escrow record waiting to be uploaded.
+type SpooledRecord struct {
+	ID      string       `json:"id"`
+	Spooled time.Time    `json:"spooled"`
+	Record  EscrowRecord `json:"record"`
+
+	Attempts    int       `json:"attempts,omitempty"`
+	LastError   string    `json:"last-error,omitempty"`
+	NextAttempt time.Time `json:"next-attempt,omitzero"`
+}
+
+// Spool stores the escrow records which could not be uploaded, so that a disconnected
+// machine can escrow its keys once it is back online. Records are encrypted to the
+// organisation key before being spooled.
+type Spool struct {
+	dir string
+}
+
+// NewSpool returns a spool stored under the given state directory.
+func NewSpool(stateDir string) *Spool {
+	return &Spool{dir: filepath.Join(stateDir, "escrow-spool")}
+}
+
+// Add spools a record for a later upload.
+func (s *Spool) Add(record EscrowRecord) (*SpooledRecord, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate spool ID: %w", err)
+	}
+
+	sr := &SpooledRecord{
+		ID:      hex.EncodeToString(id),
+		Spooled: time.Now().UTC(),
+		Record:  record,
+	}
+	if err := s.save(sr); err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+// Pending returns the records waiting to be uploaded, oldest first.
+func (s *Spool) Pending() ([]*SpooledRecord, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escrow spool: %w", err)
+	}
+
+	var records []*SpooledRecord
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spooled record: %w", err)
+		}
+		var sr SpooledRecord
+		if err := json.Unmarshal(data, &sr); err != nil {
+			return nil, fmt.Errorf("failed to parse spooled record %q: %w", name, err)
+		}
+		records = append(records, &sr)
+	}
+
+	// Records are uploaded in the order they were spooled.
+	slices.SortFunc(records, func(a, b *SpooledRecord) int {
+		return a.Spooled.Compare(b.Spooled)
+	})
+
+	return records, nil
+}
+
+// FlushResult reports the outcome of flushing the spool.
+type FlushResult struct {
+	// Uploaded are the escrow IDs of the uploaded records.
+	Uploaded []string
+	// Deferred is the number of records whose next attempt is not due yet.
+	Deferred int
+	// Failed is the number of records which could not be uploaded.
+	Failed int
+}
+
+// Flush uploads the spooled records whose next attempt is due at now, or all records when force is set.
+//
+// Records are removed once uploaded. A failed upload is retried later, with an exponential
+// backoff. Uploading stops at the first failure, as the endpoint is likely still unavailable
+// for the later records.
+func (s *Spool) Flush(ctx context.Context, escrow *Client, now time.Time, force bool) (*FlushResult, error) {
+	records, err := s.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &FlushResult{}
+	for i, sr := range records {
+		if !force && now.Before(sr.NextAttempt) {
+			res.Deferred = len(records) - i
+			break
+		}
+
+		escrowID, err := escrow.Submit(ctx, sr.Record)
+		if err != nil {
+			res.Failed = len(records) - i
+			return res, s.retryLater(sr, now, err)
+		}
+		res.Uploaded = append(res.Uploaded, escrowID)
+
+		if err := s.remove(sr); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// retryLater records a failed attempt and schedules the next one. It returns the upload error.
+func (s *Spool) retryLater(sr *SpooledRecord, now time.Time, err error) error {
+	sr.Attempts++
+	sr.LastError = err.Error()
+	sr.NextAttempt = now.Add(spoolBackoff(sr.Attempts)).UTC()
+
+	if sErr := s.save(sr); sErr != nil {
+		return errors.Join(err, sErr)
+	}
+
+	return fmt.Errorf("failed to upload spooled record %s: %w", sr.ID, err)
+}
+
+// spoolBackoff returns the delay before the next attempt, doubling after each failed attempt.
+func spoolBackoff(attempts int) time.Duration {
+	backoff := spoolMinBackoff
+	for range attempts - 1 {
+		backoff *= 2
+		if backoff >= spoolMaxBackoff {
+			return spoolMaxBackoff
+		}
+	}
+	return backoff
+}
+
+func (s *Spool) path(sr *SpooledRecord) string {
+	return filepath.Join(s.dir, sr.ID+".json")
+}
+
+func (s *Spool) remove(sr *SpooledRecord) error {
+	if err := os.Remove(s.path(sr)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove spooled record: %w", err)
+	}
+	return nil
+}
+
+// save atomically writes the record to the root-only spool directory.
+func (s *Spool) save(sr *SpooledRecord) error {
+	data, err := json.MarshalIndent(sr, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode spooled record: %w", err)
+	}
+
+	if err := fileutils.WriteFileAtomic(s.path(sr), data); err != nil {
+		return fmt.Errorf("failed to write spooled record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package enterprise_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestSpoolFlush(t *testing.T) {
+	t.Parallel()
+
+	machine := enterprise.Identity{MachineID: "0123456789abcdef"}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := map[string]struct {
+		records      int
+		serverStatus int
+		attempts     int
+		force        bool
+
+		wantUploaded    []string
+		wantDeferred    int
+		wantFailed      int
+		wantRemaining   int
+		wantNextAttempt time.Duration
+		wantErr         string
+	}{
+		"Empty spool": {},
+		"Uploads spooled records in order": {
+			records:      2,
+			wantUploaded: []string{"escrow-2", "escrow-3"},
+		},
+		"Defers records whose next attempt is not due": {
+			records:       1,
+			attempts:      1,
+			wantDeferred:  1,
+			wantRemaining: 1,
+		},
+		"Uploads records not due when forced": {
+			records:      1,
+			attempts:     1,
+			force:        true,
+			wantUploaded: []string{"escrow-2"},
+		},
+
+		"Error when server is unavailable keeps records for later": {
+			records:         2,
+			serverStatus:    http.StatusServiceUnavailable,
+			wantFailed:      2,
+			wantRemaining:   2,
+			wantNextAttempt: time.Minute,
+			wantErr:         "503 Service Unavailable",
+		},
+		"Error when server is unavailable again doubles the backoff": {
+			records:         1,
+			serverStatus:    http.StatusServiceUnavailable,
+			attempts:        3,
+			force:           true,
+			wantFailed:      1,
+			wantRemaining:   1,
+			wantNextAttempt: 8 * time.Minute,
+			wantErr:         "503 Service Unavailable",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := testutils.NewEscrowServer(t, testutils.EscrowServerOptions{})
+			server.AddRecord(testutils.EscrowRecord{KeySlot: enterprise.DefaultKeySlot, Machine: testutils.EscrowMachine{MachineID: machine.MachineID}})
+			escrow, _ := newTestClient(t, server.URL)
+
+			spool := enterprise.NewSpool(t.TempDir())
+			for i := range tc.records {
+				record := enterprise.EscrowRecord{KeyID: "key", KeySlot: enterprise.DefaultKeySlot, Machine: machine}
+				_, err := spool.Add(record)
+				be.Err(t, err, nil)
+				// Spool entries are ordered by time, make sure they are distinct.
+				if i < tc.records-1 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			// Fail as many times as needed to reach the wanted number of previous attempts.
+			server.SetStatus(http.StatusServiceUnavailable)
+			for range tc.attempts {
+				_, _ = spool.Flush(context.Background(), escrow, now, true)
+			}
+			server.SetStatus(tc.serverStatus)
+
+			res, err := spool.Flush(context.Background(), escrow, now, tc.force)
+
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+			} else {
+				be.Err(t, err, nil)
+			}
+			be.Equal(t, res.Uploaded, tc.wantUploaded)
+			be.Equal(t, res.Deferred, tc.wantDeferred)
+			be.Equal(t, res.Failed, tc.wantFailed)
+
+			pending, err := spool.Pending()
+			be.Err(t, err, nil)
+			be.Equal(t, len(pending), tc.wantRemaining)
+			if tc.wantNextAttempt != 0 {
+				be.Equal(t, pending[0].Attempts, tc.attempts+1)
+				be.Equal(t, pending[0].NextAttempt, now.Add(tc.wantNextAttempt))
+				be.True(t, pending[0].LastError != "")
+			}
+		})
+	}
+}
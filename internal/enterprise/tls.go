@@ -0,0 +1,128 @@
+package enterprise
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ErrInsecureRedirect is returned when the escrow endpoint redirects to a non-HTTPS URL.
+var ErrInsecureRedirect = errors.New("refusing redirect to a non-HTTPS URL")
+
+// ClientOption configures the TLS connection of a Client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	caBundle string
+	certFile string
+	keyFile  string
+}
+
+// WithCABundle pins the certificate authorities trusted for the endpoint to the ones of the
+// PEM file at path, instead of the system ones.
+func WithCABundle(path string) ClientOption {
+	return func(o *clientOptions) {
+		o.caBundle = path
+	}
+}
+
+// WithClientCertificate authenticates the machine to the endpoint with the PEM encoded
+// certificate and private key at the given paths.
+func WithClientCertificate(certFile, keyFile string) ClientOption {
+	return func(o *clientOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// newTLSConfig returns the TLS configuration of the options, nil if they use the defaults.
+func (o clientOptions) newTLSConfig() (*tls.Config, error) {
+	if o.caBundle == "" && o.certFile == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.caBundle != "" {
+		pool, err := loadCertPool(o.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.certFile != "" {
+		if err := checkPrivateFile(o.keyFile); err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// checkRedirect follows redirects to HTTPS URLs only, so that neither the records nor the
+// client certificate are sent in clear.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrInsecureRedirect, req.URL.Redacted())
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// ReadCertificate returns the first certificate of the PEM file at path.
+func ReadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cert, nil
+}
+
+// loadCertPool returns the pool of the certificates of the PEM file at path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM encoded certificate found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// checkPrivateFile returns an error if the file at path is accessible to other users.
+func checkPrivateFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %w", err)
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%s must not be accessible to other users (mode %04o)", path, fi.Mode().Perm())
+	}
+	return nil
+}
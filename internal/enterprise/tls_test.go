@@ -0,0 +1,205 @@
+package enterprise_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/enterprise"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestClientTLS(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		server     testutils.EscrowServerOptions
+		noCABundle bool
+		clientCert *testutils.ClientCertificateOptions
+		keyMode    os.FileMode
+		redirect   string
+		plainHTTP  bool
+
+		wantNewClientErr string
+		wantErr          string
+		wantTemporary    bool
+	}{
+		"Uploads with a client certificate": {
+			server:     testutils.EscrowServerOptions{RequireClientCert: true},
+			clientCert: &testutils.ClientCertificateOptions{},
+		},
+		"Uploads to a server of the pinned CA": {
+			server: testutils.EscrowServerOptions{TLS: true},
+		},
+		"Follows redirects to HTTPS": {
+			server:   testutils.EscrowServerOptions{TLS: true},
+			redirect: "https",
+		},
+
+		"Error when server requires a client certificate": {
+			server:        testutils.EscrowServerOptions{RequireClientCert: true},
+			wantErr:       "tls: certificate required",
+			wantTemporary: true,
+		},
+		"Error when client certificate is not trusted by the server": {
+			server:        testutils.EscrowServerOptions{RequireClientCert: true},
+			clientCert:    &testutils.ClientCertificateOptions{Untrusted: true},
+			wantErr:       "tls: certificate required",
+			wantTemporary: true,
+		},
+		"Error when server CA is not pinned nor trusted": {
+			server:        testutils.EscrowServerOptions{TLS: true},
+			noCABundle:    true,
+			wantErr:       "certificate",
+			wantTemporary: true,
+		},
+		"Error when redirected to a non-HTTPS URL": {
+			server:   testutils.EscrowServerOptions{TLS: true},
+			redirect: "http",
+			wantErr:  "refusing redirect to a non-HTTPS URL",
+		},
+
+		"Error when client key is accessible to other users": {
+			server:           testutils.EscrowServerOptions{TLS: true},
+			clientCert:       &testutils.ClientCertificateOptions{},
+			keyMode:          0o644,
+			wantNewClientErr: "must not be accessible to other users",
+		},
+		"Error when endpoint is not HTTPS": {
+			server:           testutils.EscrowServerOptions{TLS: true},
+			plainHTTP:        true,
+			wantNewClientErr: "requires an https URL",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := testutils.NewEscrowServer(t, tc.server)
+			endpoint := server.URL
+			switch tc.redirect {
+			case "https":
+				// The httptest servers share their certificate, so the CA bundle of one pins the other.
+				endpoint = testutils.NewEscrowServer(t, testutils.EscrowServerOptions{TLS: true, RedirectTo: server.URL}).URL
+			case "http":
+				plain := testutils.NewEscrowServer(t, testutils.EscrowServerOptions{})
+				endpoint = testutils.NewEscrowServer(t, testutils.EscrowServerOptions{TLS: true, RedirectTo: plain.URL}).URL
+				t.Cleanup(func() { be.Equal(t, len(plain.Records()), 0) })
+			}
+			if tc.plainHTTP {
+				endpoint = "http://" + endpoint[len("https://"):]
+			}
+
+			var opts []enterprise.ClientOption
+			if !tc.noCABundle {
+				opts = append(opts, enterprise.WithCABundle(server.WriteCABundle(t)))
+			}
+			if tc.clientCert != nil {
+				cert, key := testutils.WriteClientCertificate(t, *tc.clientCert)
+				if tc.keyMode != 0 {
+					be.Err(t, os.Chmod(key, tc.keyMode), nil)
+				}
+				opts = append(opts, enterprise.WithClientCertificate(cert, key))
+			}
+
+			pub, _ := testutils.WriteOrgKey(t)
+			orgKey, err := enterprise.LoadOrgKey(pub)
+			be.Err(t, err, nil)
+
+			client, err := enterprise.NewClient(endpoint, orgKey, opts...)
+			if tc.wantNewClientErr != "" {
+				be.Err(t, err, tc.wantNewClientErr)
+				return
+			}
+			be.Err(t, err, nil)
+
+			record := enterprise.EscrowRecord{KeyID: "key", KeySlot: enterprise.DefaultKeySlot, Machine: enterprise.Identity{MachineID: "0123456789abcdef"}}
+			id, err := client.Submit(context.Background(), record)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				be.Equal(t, enterprise.IsTemporary(err), tc.wantTemporary)
+				be.Equal(t, len(server.Records()), 0)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, id, "escrow-1")
+			be.Equal(t, len(server.Records()), 1)
+		})
+	}
+}
+
+func TestNewClientTLSErrors(t *testing.T) {
+	t.Parallel()
+
+	cert, key := testutils.WriteClientCertificate(t, testutils.ClientCertificateOptions{})
+	otherCert, _ := testutils.WriteClientCertificate(t, testutils.ClientCertificateOptions{})
+	pub, _ := testutils.WriteOrgKey(t)
+
+	tests := map[string]struct {
+		opts []enterprise.ClientOption
+
+		wantErr string
+	}{
+		"Error when CA bundle is missing": {
+			opts:    []enterprise.ClientOption{enterprise.WithCABundle(filepath.Join(t.TempDir(), "missing.pem"))},
+			wantErr: "failed to read CA bundle",
+		},
+		"Error when CA bundle has no certificate": {
+			opts:    []enterprise.ClientOption{enterprise.WithCABundle(pub)},
+			wantErr: "no PEM encoded certificate",
+		},
+		"Error when client key is missing": {
+			opts:    []enterprise.ClientOption{enterprise.WithClientCertificate(cert, filepath.Join(t.TempDir(), "missing.key"))},
+			wantErr: "failed to read private key",
+		},
+		"Error when client certificate does not match its key": {
+			opts:    []enterprise.ClientOption{enterprise.WithClientCertificate(otherCert, key)},
+			wantErr: "failed to load client certificate",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := enterprise.NewClient("https://landscape.example.com", nil, tc.opts...)
+			be.Err(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestReadCertificate(t *testing.T) {
+	t.Parallel()
+
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	cert, key := testutils.WriteClientCertificate(t, testutils.ClientCertificateOptions{NotAfter: notAfter})
+
+	tests := map[string]struct {
+		path string
+
+		wantErr bool
+	}{
+		"Reads the certificate": {path: cert},
+
+		"Error when file is missing":         {path: filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+		"Error when file is not certificate": {path: key, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := enterprise.ReadCertificate(tc.path)
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.True(t, got.NotAfter.Equal(notAfter))
+		})
+	}
+}
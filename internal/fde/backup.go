@@ -0,0 +1,358 @@
+package fde
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	headerBackupFormat  = "snap-tpmctl-luks2-header-backup"
+	headerBackupVersion = 1
+
+	backupCipher = "aes-256-gcm"
+)
+
+// ErrBackupEncrypted is returned when reading an encrypted header backup without a passphrase.
+var ErrBackupEncrypted = errors.New("header backup is encrypted: a passphrase is required")
+
+// ErrUnidentifiedTarget is returned when the target of a restore has no readable header left to check
+// it is the device the backup was taken from.
+var ErrUnidentifiedTarget = errors.New("no readable header to verify the identity of the device")
+
+// backupKDF are the argon2id costs deriving the encryption key of encrypted backups.
+var backupKDF = LUKS2KDF{Type: "argon2id", Time: 4, Memory: 64 * 1024, CPUs: 4}
+
+// HeaderBackupManifest describes the content of a header backup.
+// It is stored in clear as the first line of the backup file.
+type HeaderBackupManifest struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+
+	UUID         string `json:"uuid"`
+	Label        string `json:"label,omitempty"`
+	HeaderSize   uint64 `json:"header_size"`
+	KeyslotsSize uint64 `json:"keyslots_size"`
+	// DataOffset is the offset of the first data segment, which must not change on restore.
+	DataOffset uint64 `json:"data_offset"`
+
+	// Size is the number of bytes copied from the start of the device.
+	Size   uint64             `json:"size"`
+	SHA256 string             `json:"sha256"`
+	Areas  []HeaderBackupArea `json:"areas"`
+
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+}
+
+// HeaderBackupArea is a region of the backup with its own checksum.
+type HeaderBackupArea struct {
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupEncryption describes how the content of an encrypted backup is protected.
+type BackupEncryption struct {
+	Cipher string   `json:"cipher"`
+	KDF    LUKS2KDF `json:"kdf"`
+	Nonce  []byte   `json:"nonce"`
+}
+
+// HeaderBackup is a verified header backup.
+type HeaderBackup struct {
+	Manifest HeaderBackupManifest
+	data     []byte
+}
+
+// BackupHeader copies both LUKS2 header areas and the keyslots area of the device into a new
+// backup file. If passphrase is not empty, the copied content is encrypted with it.
+func BackupHeader(device, path string, passphrase []byte) (*HeaderBackupManifest, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer f.Close()
+
+	hdr, err := readConsistentHeaders(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", device, err)
+	}
+
+	m := HeaderBackupManifest{
+		Format:       headerBackupFormat,
+		Version:      headerBackupVersion,
+		Created:      time.Now().UTC(),
+		UUID:         hdr.UUID,
+		Label:        hdr.Label,
+		HeaderSize:   hdr.HeaderSize,
+		KeyslotsSize: uint64(hdr.Metadata.Config.KeyslotsSize),
+		DataOffset:   dataOffset(hdr),
+		Size:         2*hdr.HeaderSize + uint64(hdr.Metadata.Config.KeyslotsSize),
+	}
+
+	data := make([]byte, m.Size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil, fmt.Errorf("failed to read header areas of %s: %w", device, err)
+	}
+	m.SHA256, m.Areas = backupChecksums(data, m.HeaderSize)
+
+	payload := data
+	if len(passphrase) > 0 {
+		m.Encryption = &BackupEncryption{
+			Cipher: backupCipher,
+			KDF:    backupKDF,
+			Nonce:  make([]byte, 12),
+		}
+		m.Encryption.KDF.Salt = make([]byte, 32)
+		if _, err := rand.Read(m.Encryption.KDF.Salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		if _, err := rand.Read(m.Encryption.Nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+	}
+
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	manifest = append(manifest, '\n')
+
+	if m.Encryption != nil {
+		aead, err := backupAEAD(m.Encryption, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		// The manifest is authenticated, so that the geometry checked on restore cannot be tampered with.
+		payload = aead.Seal(nil, m.Encryption.Nonce, data, manifest)
+	}
+
+	if err := writeNewFile(path, append(manifest, payload...)); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// ReadHeaderBackup reads a backup file and verifies its checksums.
+// The passphrase is only used for encrypted backups.
+func ReadHeaderBackup(path string, passphrase []byte) (*HeaderBackup, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header backup: %w", err)
+	}
+
+	manifest, payload, ok := bytes.Cut(content, []byte("\n"))
+	if !ok {
+		return nil, errors.New("invalid header backup: missing manifest")
+	}
+	manifest = append(manifest, '\n')
+
+	var m HeaderBackupManifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("invalid header backup manifest: %w", err)
+	}
+	if m.Format != headerBackupFormat || m.Version != headerBackupVersion {
+		return nil, fmt.Errorf("unsupported header backup format %q version %d", m.Format, m.Version)
+	}
+
+	data := payload
+	if m.Encryption != nil {
+		if len(passphrase) == 0 {
+			return nil, ErrBackupEncrypted
+		}
+		aead, err := backupAEAD(m.Encryption, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		data, err = aead.Open(nil, m.Encryption.Nonce, payload, manifest)
+		if err != nil {
+			return nil, errors.New("failed to decrypt header backup: wrong passphrase or corrupted file")
+		}
+	}
+
+	if m.Size < 2*m.HeaderSize {
+		return nil, fmt.Errorf("invalid header backup size %d for header size %d", m.Size, m.HeaderSize)
+	}
+	if uint64(len(data)) != m.Size {
+		return nil, fmt.Errorf("header backup is truncated: expected %d bytes, got %d", m.Size, len(data))
+	}
+	sum, areas := backupChecksums(data, m.HeaderSize)
+	if sum != m.SHA256 {
+		return nil, errors.New("header backup checksum mismatch")
+	}
+	for i, a := range areas {
+		if i >= len(m.Areas) || m.Areas[i] != a {
+			return nil, fmt.Errorf("header backup checksum mismatch in %s", a.Name)
+		}
+	}
+
+	// The backup content must itself be a valid header.
+	hdr, err := readConsistentHeaders(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid header backup content: %w", err)
+	}
+	if hdr.UUID != m.UUID {
+		return nil, fmt.Errorf("header backup content UUID %s does not match manifest UUID %s", hdr.UUID, m.UUID)
+	}
+
+	return &HeaderBackup{Manifest: m, data: data}, nil
+}
+
+// CheckTarget verifies that the device is the one the backup was taken from: same UUID and geometry.
+// If both headers of the device are damaged, only its size is checked and ErrUnidentifiedTarget is returned.
+func (b *HeaderBackup) CheckTarget(device string) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer f.Close()
+
+	return b.checkTarget(f, "")
+}
+
+// checkTarget verifies the device as CheckTarget. A device without a readable header is accepted
+// when confirmedUUID is the UUID of the backup.
+func (b *HeaderBackup) checkTarget(f *os.File, confirmedUUID string) error {
+	m := b.Manifest
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to get size of %s: %w", f.Name(), err)
+	}
+	if uint64(size) < m.DataOffset || uint64(size) < m.Size {
+		return fmt.Errorf("%s is too small for the backup: %d bytes", f.Name(), size)
+	}
+
+	hdr, err := ReadLUKS2Header(f)
+	if err != nil {
+		if confirmedUUID != "" && confirmedUUID == m.UUID {
+			return nil
+		}
+		return fmt.Errorf("%s: %w: %w", f.Name(), ErrUnidentifiedTarget, err)
+	}
+
+	if hdr.UUID != m.UUID {
+		return fmt.Errorf("UUID mismatch: %s has UUID %s, backup has UUID %s", f.Name(), hdr.UUID, m.UUID)
+	}
+	if hdr.HeaderSize != m.HeaderSize ||
+		uint64(hdr.Metadata.Config.KeyslotsSize) != m.KeyslotsSize ||
+		dataOffset(hdr) != m.DataOffset {
+		return fmt.Errorf("geometry mismatch: %s has header size %d, keyslots size %d and data offset %d, backup has %d, %d and %d",
+			f.Name(), hdr.HeaderSize, hdr.Metadata.Config.KeyslotsSize, dataOffset(hdr), m.HeaderSize, m.KeyslotsSize, m.DataOffset)
+	}
+
+	return nil
+}
+
+// RestoreHeader writes the backup back to the device after checking it is the one the backup was
+// taken from, and verifies what was written.
+// If the device has no readable header left, confirmedUUID must be the UUID of the backup, as
+// typed by the user, since only the size of the device can be checked.
+func RestoreHeader(device string, b *HeaderBackup, confirmedUUID string) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer f.Close()
+
+	if err := b.checkTarget(f, confirmedUUID); err != nil {
+		return err
+	}
+
+	if _, err := f.WriteAt(b.data, 0); err != nil {
+		return fmt.Errorf("failed to write header areas of %s: %w", device, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to write header areas of %s: %w", device, err)
+	}
+
+	written := make([]byte, len(b.data))
+	if _, err := f.ReadAt(written, 0); err != nil {
+		return fmt.Errorf("failed to read back header areas of %s: %w", device, err)
+	}
+	if !bytes.Equal(written, b.data) {
+		return fmt.Errorf("header areas of %s differ from the backup after restore", device)
+	}
+
+	return nil
+}
+
+// readConsistentHeaders returns the header when both the primary and secondary headers are valid and identical.
+func readConsistentHeaders(r io.ReaderAt) (*LUKS2Header, error) {
+	primary, err := ReadLUKS2HeaderAt(r, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary header: %w", err)
+	}
+	secondary, err := ReadLUKS2HeaderAt(r, int64(primary.HeaderSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secondary header: %w", err)
+	}
+	if primary.SeqID != secondary.SeqID {
+		return nil, fmt.Errorf("primary and secondary headers differ: sequence IDs %d and %d", primary.SeqID, secondary.SeqID)
+	}
+
+	return primary, nil
+}
+
+// dataOffset returns the lowest offset of the data segments.
+func dataOffset(hdr *LUKS2Header) uint64 {
+	var offset uint64
+	for _, s := range hdr.Metadata.Segments {
+		if offset == 0 || uint64(s.Offset) < offset {
+			offset = uint64(s.Offset)
+		}
+	}
+	return offset
+}
+
+// backupChecksums returns the checksum of the whole data and of each of its areas.
+func backupChecksums(data []byte, headerSize uint64) (string, []HeaderBackupArea) {
+	areas := []HeaderBackupArea{
+		{Name: "primary header", Offset: 0, Size: headerSize},
+		{Name: "secondary header", Offset: headerSize, Size: headerSize},
+		{Name: "keyslots", Offset: 2 * headerSize, Size: uint64(len(data)) - 2*headerSize},
+	}
+	for i, a := range areas {
+		sum := sha256.Sum256(data[a.Offset : a.Offset+a.Size])
+		areas[i].SHA256 = hex.EncodeToString(sum[:])
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), areas
+}
+
+// backupAEAD returns the cipher of an encrypted backup.
+func backupAEAD(enc *BackupEncryption, passphrase []byte) (cipher.AEAD, error) {
+	if enc.Cipher != backupCipher || enc.KDF.Type != "argon2id" {
+		return nil, fmt.Errorf("unsupported header backup encryption %s with %s", enc.Cipher, enc.KDF.Type)
+	}
+
+	key := argon2.IDKey(passphrase, enc.KDF.Salt, enc.KDF.Time, enc.KDF.Memory, enc.KDF.CPUs, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid header backup nonce")
+	}
+
+	return aead, nil
+}
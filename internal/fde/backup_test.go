@@ -0,0 +1,180 @@
+package fde_test
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestMain(m *testing.M) {
+	fde.SetBackupKDFMemory(64)
+	os.Exit(m.Run())
+}
+
+func TestHeaderBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		passphrase        string
+		restorePassphrase string
+		tamperBackup      func(backup []byte) []byte
+		target            testutils.LUKS2Options
+		keyslotsSize      string
+		damageBothHeaders bool
+		confirmedUUID     string
+		truncateTarget    bool
+
+		wantReadErr         bool
+		wantEncryptedErr    bool
+		wantUnidentifiedErr bool
+		wantRestoreErr      bool
+	}{
+		"Restores backup":           {},
+		"Restores encrypted backup": {passphrase: "backup secret", restorePassphrase: "backup secret"},
+		"Restores backup over both damaged headers with confirmed UUID": {
+			damageBothHeaders:   true,
+			confirmedUUID:       backupTestUUID,
+			wantUnidentifiedErr: true,
+		},
+
+		"Error on wrong passphrase":   {passphrase: "backup secret", restorePassphrase: "other", wantReadErr: true},
+		"Error on missing passphrase": {passphrase: "backup secret", wantReadErr: true, wantEncryptedErr: true},
+		"Error on corrupted backup":   {tamperBackup: func(b []byte) []byte { b[len(b)-1] ^= 1; return b }, wantReadErr: true},
+		"Error on truncated backup":   {tamperBackup: func(b []byte) []byte { return b[:len(b)-16] }, wantReadErr: true},
+		"Error on tampered encrypted manifest": {
+			passphrase:        "backup secret",
+			restorePassphrase: "backup secret",
+			tamperBackup: func(b []byte) []byte {
+				copy(b[bytes.Index(b, []byte(`"label":"`))+9:], "X")
+				return b
+			},
+			wantReadErr: true,
+		},
+		"Error on UUID mismatch": {
+			target:         testutils.LUKS2Options{UUID: "0d0c0b0a-0000-4000-8000-000000000000"},
+			wantRestoreErr: true,
+		},
+		"Error on geometry mismatch": {
+			keyslotsSize:   "253952",
+			wantRestoreErr: true,
+		},
+		"Error on both damaged headers without confirmation": {
+			damageBothHeaders:   true,
+			wantUnidentifiedErr: true,
+			wantRestoreErr:      true,
+		},
+		"Error on both damaged headers with other UUID": {
+			damageBothHeaders:   true,
+			confirmedUUID:       "0d0c0b0a-0000-4000-8000-000000000000",
+			wantUnidentifiedErr: true,
+			wantRestoreErr:      true,
+		},
+		"Error on both damaged headers of a too small device": {
+			damageBothHeaders: true,
+			confirmedUUID:     backupTestUUID,
+			truncateTarget:    true,
+			wantRestoreErr:    true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			device := filepath.Join(dir, "disk.img")
+			backupPath := filepath.Join(dir, "header.backup")
+
+			image := newBackupTestImage(t, testutils.LUKS2Options{Label: "ubuntu-data-enc"}, "")
+			be.Err(t, os.WriteFile(device, image, 0600), nil)
+
+			m, err := fde.BackupHeader(device, backupPath, []byte(tc.passphrase))
+			be.Err(t, err, nil)
+			be.Equal(t, uint64(2*testutils.LUKS2HeaderSize+0x40000), m.Size)
+			be.Equal(t, 3, len(m.Areas))
+			be.Equal(t, tc.passphrase != "", m.Encryption != nil)
+
+			fi, err := os.Stat(backupPath)
+			be.Err(t, err, nil)
+			be.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+			// Backups never overwrite an existing file.
+			_, err = fde.BackupHeader(device, backupPath, nil)
+			be.Err(t, err)
+
+			if tc.tamperBackup != nil {
+				content, err := os.ReadFile(backupPath)
+				be.Err(t, err, nil)
+				be.Err(t, os.WriteFile(backupPath, tc.tamperBackup(content), 0600), nil)
+			}
+
+			backup, err := fde.ReadHeaderBackup(backupPath, []byte(tc.restorePassphrase))
+			if tc.wantReadErr {
+				be.Err(t, err)
+				be.Equal(t, tc.wantEncryptedErr, errors.Is(err, fde.ErrBackupEncrypted))
+				return
+			}
+			be.Err(t, err, nil)
+
+			// Damage the primary header and the keyslots of the target.
+			target := bytes.Clone(image)
+			if tc.target.UUID != "" || tc.keyslotsSize != "" {
+				target = newBackupTestImage(t, tc.target, tc.keyslotsSize)
+			}
+			clear(target[:100])
+			clear(target[2*testutils.LUKS2HeaderSize : 3*testutils.LUKS2HeaderSize])
+			if tc.damageBothHeaders {
+				clear(target[testutils.LUKS2HeaderSize : testutils.LUKS2HeaderSize+100])
+			}
+			if tc.truncateTarget {
+				target = target[:backup.Manifest.DataOffset-4096]
+			}
+			be.Err(t, os.WriteFile(device, target, 0600), nil)
+
+			be.Equal(t, tc.wantUnidentifiedErr, errors.Is(backup.CheckTarget(device), fde.ErrUnidentifiedTarget))
+
+			err = fde.RestoreHeader(device, backup, tc.confirmedUUID)
+			if tc.wantRestoreErr {
+				be.Err(t, err)
+				got, err := os.ReadFile(device)
+				be.Err(t, err, nil)
+				be.True(t, bytes.Equal(target, got))
+				return
+			}
+			be.Err(t, err, nil)
+
+			got, err := os.ReadFile(device)
+			be.Err(t, err, nil)
+			be.True(t, bytes.Equal(image, got))
+
+			hdr, err := fde.ReadLUKS2Header(bytes.NewReader(got))
+			be.Err(t, err, nil)
+			keyslot, err := fde.VerifyPassphrase(bytes.NewReader(got), hdr, []byte("secret"))
+			be.Err(t, err, nil)
+			be.Equal(t, "0", keyslot)
+		})
+	}
+}
+
+// backupTestUUID is the UUID of the images of newBackupTestImage.
+const backupTestUUID = "4b7c8c2e-6f5d-4c8e-9a1b-3d2f1e0c9b8a"
+
+// newBackupTestImage returns a small image with a keyslot unlocked by "secret", and a data segment right after its keyslots area.
+func newBackupTestImage(t *testing.T, opts testutils.LUKS2Options, keyslotsSize string) []byte {
+	t.Helper()
+
+	md := testutils.DefaultLUKS2Metadata()
+	md["config"] = map[string]any{"json_size": "12288", "keyslots_size": cmp.Or(keyslotsSize, "262144")}
+	md["segments"].(map[string]any)["0"].(map[string]any)["offset"] = "294912"
+	opts.Metadata = md
+	opts.Size = 294912 + 4096
+
+	return testutils.NewLUKS2ImageWithKeyslots(t, opts, testutils.LUKS2KeyslotOptions{Passphrase: []byte("secret"), KDF: "pbkdf2"})
+}
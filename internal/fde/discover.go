@@ -0,0 +1,155 @@
+package fde
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Managers of a discovered volume, as reported by DiscoveredVolume.ManagedBy.
+const (
+	ManagedBySnapd   = "snapd"
+	ManagedBySystemd = "systemd"
+	ManagedByNone    = "none"
+)
+
+// DiscoveredVolume is a LUKS2 device found on the system.
+type DiscoveredVolume struct {
+	// Device is the path of the encrypted block device.
+	Device string
+	UUID   string
+	Label  string
+
+	// ContainerRole is set for the system volumes.
+	ContainerRole string
+
+	// Open is true when the device is unlocked, as the DMName mapping.
+	Open   bool
+	DMName string
+
+	Header *LUKS2Header
+}
+
+// ManagedBy returns what manages the keyslots of the volume, deduced from its tokens.
+func (v DiscoveredVolume) ManagedBy() string {
+	if v.ContainerRole != "" {
+		return ManagedBySnapd
+	}
+	for _, id := range v.Header.Metadata.TokenIDs() {
+		switch t := v.Header.Metadata.Tokens[id].Type; {
+		case t == TokenTypePlatform || t == TokenTypeRecovery:
+			return ManagedBySnapd
+		case strings.HasPrefix(t, "systemd-"):
+			return ManagedBySystemd
+		}
+	}
+	return ManagedByNone
+}
+
+// DiscoverVolumes finds the LUKS2 devices of the system and whether they are open.
+//
+// Candidate devices are the disks and partitions listed in sysfs and the devices linked
+// in /dev/disk/by-uuid. Mappings opened by cryptsetup are found from their device-mapper UUID.
+// Devices which cannot be read are skipped.
+func DiscoverVolumes(paths SystemPaths) ([]DiscoveredVolume, error) {
+	candidates, err := blockDevices(paths.SysBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	// The by-uuid links may point to devices which are not in sysfs, like loop devices in a rescue system.
+	links, _ := filepath.Glob(filepath.Join(paths.ByUUID, "*"))
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		if name := filepath.Base(target); !slices.Contains(candidates, name) {
+			candidates = append(candidates, name)
+		}
+	}
+
+	mappings := cryptMappings(paths.SysBlock)
+	labels := make(map[string]string, len(systemVolumeLabels))
+	for role, label := range systemVolumeLabels {
+		labels[label] = role
+	}
+
+	var volumes []DiscoveredVolume
+	for _, name := range candidates {
+		device := filepath.Join(paths.Dev, name)
+
+		hdr, err := OpenLUKS2Header(device)
+		if err != nil {
+			continue
+		}
+
+		dmName, open := mappings[name]
+		volumes = append(volumes, DiscoveredVolume{
+			Device:        device,
+			UUID:          hdr.UUID,
+			Label:         hdr.Label,
+			ContainerRole: labels[hdr.Label],
+			Open:          open,
+			DMName:        dmName,
+			Header:        hdr,
+		})
+	}
+
+	slices.SortFunc(volumes, func(a, b DiscoveredVolume) int {
+		return cmp.Compare(a.Device, b.Device)
+	})
+
+	return volumes, nil
+}
+
+// blockDevices returns the names of the disks and partitions in sysfs, leaving out the
+// unlocked side of crypt mappings.
+func blockDevices(sysBlock string) ([]string, error) {
+	entries, err := os.ReadDir(sysBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		dir := filepath.Join(sysBlock, e.Name())
+		if uuid, err := os.ReadFile(filepath.Join(dir, "dm", "uuid")); err == nil && strings.HasPrefix(string(uuid), "CRYPT-") {
+			continue
+		}
+		names = append(names, e.Name())
+
+		parts, _ := filepath.Glob(filepath.Join(dir, "*", "partition"))
+		for _, p := range parts {
+			names = append(names, filepath.Base(filepath.Dir(p)))
+		}
+	}
+
+	return names, nil
+}
+
+// cryptMappings returns the name of the LUKS mapping opened on top of each block device.
+func cryptMappings(sysBlock string) map[string]string {
+	mappings := make(map[string]string)
+
+	dms, _ := filepath.Glob(filepath.Join(sysBlock, "dm-*"))
+	for _, dm := range dms {
+		uuid, err := os.ReadFile(filepath.Join(dm, "dm", "uuid"))
+		if err != nil || !strings.HasPrefix(string(uuid), "CRYPT-LUKS") {
+			continue
+		}
+		name, err := os.ReadFile(filepath.Join(dm, "dm", "name"))
+		if err != nil {
+			continue
+		}
+
+		slaves, _ := os.ReadDir(filepath.Join(dm, "slaves"))
+		for _, s := range slaves {
+			mappings[s.Name()] = strings.TrimSpace(string(name))
+		}
+	}
+
+	return mappings
+}
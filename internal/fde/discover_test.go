@@ -0,0 +1,73 @@
+package fde_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestDiscoverVolumes(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	paths := fde.SystemPaths{
+		SysBlock: filepath.Join(root, "sys", "block"),
+		Dev:      filepath.Join(root, "dev"),
+		ByUUID:   filepath.Join(root, "dev", "disk", "by-uuid"),
+	}
+
+	// sda1 is system-data, sda2 is not encrypted, sdb is a secondary disk opened as dm-0,
+	// and loop0 is only linked by UUID.
+	for _, dir := range []string{"sda/sda1", "sda/sda2", "sdb", "dm-0/dm", "dm-0/slaves/sdb"} {
+		be.Err(t, os.MkdirAll(filepath.Join(paths.SysBlock, dir), 0700), nil)
+	}
+	for _, part := range []string{"sda/sda1", "sda/sda2"} {
+		be.Err(t, os.WriteFile(filepath.Join(paths.SysBlock, part, "partition"), []byte("1\n"), 0600), nil)
+	}
+	be.Err(t, os.WriteFile(filepath.Join(paths.SysBlock, "dm-0", "dm", "name"), []byte("secure-data\n"), 0600), nil)
+	be.Err(t, os.WriteFile(filepath.Join(paths.SysBlock, "dm-0", "dm", "uuid"), []byte("CRYPT-LUKS2-0123-secure-data\n"), 0600), nil)
+
+	systemdMetadata := testutils.DefaultLUKS2Metadata()
+	systemdMetadata["tokens"] = map[string]any{"0": map[string]any{"type": "systemd-tpm2", "keyslots": []string{"0"}}}
+	plainMetadata := testutils.DefaultLUKS2Metadata()
+	plainMetadata["tokens"] = map[string]any{}
+
+	be.Err(t, os.MkdirAll(paths.ByUUID, 0700), nil)
+	for name, image := range map[string][]byte{
+		"sda":   make([]byte, 4096),
+		"sda1":  testutils.NewLUKS2Image(t, testutils.LUKS2Options{Label: "ubuntu-data-enc"}),
+		"sda2":  make([]byte, 4096),
+		"sdb":   testutils.NewLUKS2Image(t, testutils.LUKS2Options{Label: "secondary", Metadata: plainMetadata}),
+		"dm-0":  make([]byte, 4096),
+		"loop0": testutils.NewLUKS2Image(t, testutils.LUKS2Options{UUID: "9f0e-loop", Metadata: systemdMetadata}),
+	} {
+		be.Err(t, os.WriteFile(filepath.Join(paths.Dev, name), image, 0600), nil)
+	}
+	be.Err(t, os.Symlink("../../loop0", filepath.Join(paths.ByUUID, "9f0e-loop")), nil)
+	be.Err(t, os.Symlink("../../sdb", filepath.Join(paths.ByUUID, "4b7c8c2e-6f5d-4c8e-9a1b-3d2f1e0c9b8a")), nil)
+
+	volumes, err := fde.DiscoverVolumes(paths)
+	be.Err(t, err, nil)
+
+	type summary struct {
+		device, label, role, dmName, managedBy string
+		open                                   bool
+	}
+	var got []summary
+	for _, v := range volumes {
+		got = append(got, summary{filepath.Base(v.Device), v.Label, v.ContainerRole, v.DMName, v.ManagedBy(), v.Open})
+	}
+
+	be.Equal(t, []summary{
+		{device: "loop0", managedBy: fde.ManagedBySystemd},
+		{device: "sda1", label: "ubuntu-data-enc", role: "system-data", managedBy: fde.ManagedBySnapd},
+		{device: "sdb", label: "secondary", dmName: "secure-data", managedBy: fde.ManagedByNone, open: true},
+	}, got)
+
+	_, err = fde.DiscoverVolumes(fde.SystemPaths{SysBlock: filepath.Join(root, "missing")})
+	be.Err(t, err)
+}
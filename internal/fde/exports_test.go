@@ -0,0 +1,14 @@
+package fde
+
+// Export private functions for testing.
+var (
+	RecommendKDFTime = recommendKDFTime
+	ParseMemTotalKiB = parseMemTotalKiB
+	GFMul            = gfMul
+	GFInv            = gfInv
+)
+
+// SetBackupKDFMemory lowers the memory cost of backup encryption for fast tests.
+func SetBackupKDFMemory(kib uint32) {
+	backupKDF.Memory = kib
+}
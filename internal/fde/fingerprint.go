@@ -0,0 +1,46 @@
+package fde
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// FingerprintSaltSize is the size of the salts generated for key fingerprints.
+	FingerprintSaltSize = 16
+	// fingerprintGroups is the number of hyphen separated groups of a fingerprint.
+	fingerprintGroups = 4
+)
+
+// NewFingerprintSalt returns a random salt for the fingerprint of a recovery key.
+func NewFingerprintSalt() ([]byte, error) {
+	salt := make([]byte, FingerprintSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate fingerprint salt: %w", err)
+	}
+	return salt, nil
+}
+
+// Fingerprint returns a short code identifying a recovery key, to check that a key read out
+// over the phone is the recorded one without disclosing it.
+// For example, 30896-10332-13470-37566.
+//
+// It is the truncated HMAC-SHA256 of the key keyed with the salt, in the recovery key form.
+// A slow KDF such as argon2 would not make it safer: the key has 128 random bits, so the
+// fingerprint cannot be brute forced, and being truncated, it matches many keys anyway.
+func Fingerprint(key RecoveryKey, salt []byte) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(key[:])
+	sum := mac.Sum(nil)
+
+	groups := make([]string, fingerprintGroups)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%05d", binary.LittleEndian.Uint16(sum[i*2:]))
+	}
+
+	return strings.Join(groups, "-")
+}
@@ -0,0 +1,61 @@
+package fde_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+)
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+
+	key := fde.RecoveryKey{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+	otherKey := key
+	otherKey[15] ^= 1
+	salt := []byte("0123456789abcdef")
+
+	tests := map[string]struct {
+		key  fde.RecoveryKey
+		salt []byte
+
+		want     string
+		wantSame bool
+	}{
+		"Known vector":         {key: key, salt: salt, want: "30896-10332-13470-37566", wantSame: true},
+		"Other salt differs":   {key: key, salt: []byte("0123456789abcdeg")},
+		"Other key differs":    {key: otherKey, salt: salt},
+		"Empty salt is usable": {key: key},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := fde.Fingerprint(tc.key, tc.salt)
+
+			// The fingerprint is deterministic and never contains the key.
+			be.Equal(t, fde.Fingerprint(tc.key, tc.salt), got)
+			be.Equal(t, len(got), 23)
+			_, err := fde.ParseRecoveryKey(got)
+			be.Err(t, err, "got 4 groups")
+
+			if tc.want != "" {
+				be.Equal(t, got, tc.want)
+			}
+			be.Equal(t, got == fde.Fingerprint(key, salt), tc.wantSame)
+		})
+	}
+}
+
+func TestNewFingerprintSalt(t *testing.T) {
+	t.Parallel()
+
+	a, err := fde.NewFingerprintSalt()
+	be.Err(t, err, nil)
+	b, err := fde.NewFingerprintSalt()
+	be.Err(t, err, nil)
+
+	be.Equal(t, len(a), fde.FingerprintSaltSize)
+	be.True(t, string(a) != string(b))
+}
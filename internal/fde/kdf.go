@@ -0,0 +1,160 @@
+package fde
+
+import (
+	"bufio"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// maxArgon2MemoryKiB caps the memory cost used for argon2, as cryptsetup does.
+	maxArgon2MemoryKiB = 1024 * 1024
+	// maxArgon2Threads caps the parallelism used for argon2, as cryptsetup does.
+	maxArgon2Threads = 4
+
+	// minArgon2Iterations is the minimum argon2 time cost considered safe at the benchmarked memory cost.
+	minArgon2Iterations = 4
+	// minPBKDF2Iterations is the minimum number of PBKDF2-SHA256 iterations considered safe.
+	minPBKDF2Iterations = 1_000_000
+
+	// minKDFTime is the lowest time target ever recommended.
+	minKDFTime = time.Second
+	// kdfTimeStep is the granularity of the recommended time target.
+	kdfTimeStep = 250 * time.Millisecond
+
+	// pbkdf2BenchmarkIterations is the number of iterations used to measure the PBKDF2 speed.
+	pbkdf2BenchmarkIterations = 100_000
+)
+
+// BenchmarkOptions configures the KDF benchmark. Zero values are derived from the machine resources.
+type BenchmarkOptions struct {
+	// Argon2MemoryKiB is the argon2 memory cost in KiB.
+	Argon2MemoryKiB uint32
+	// Argon2Threads is the argon2 parallelism.
+	Argon2Threads uint8
+	// PBKDF2Iterations is the number of iterations to measure PBKDF2 speed with.
+	PBKDF2Iterations int
+}
+
+// BenchmarkResult contains the measured costs of the KDFs and the recommended time target.
+type BenchmarkResult struct {
+	Argon2MemoryKiB uint32
+	Argon2Threads   uint8
+	// Argon2Iteration is the duration of a single argon2id iteration at the benchmarked memory cost.
+	Argon2Iteration time.Duration
+	// PBKDF2IterationsPerSecond is the PBKDF2-SHA256 speed.
+	PBKDF2IterationsPerSecond int
+
+	// RecommendedTime is the lowest time target reaching the minimum safe cost for both KDFs.
+	RecommendedTime time.Duration
+}
+
+// BenchmarkKDF measures the local cost of argon2id and PBKDF2-SHA256 and recommends a KDF time target.
+func BenchmarkKDF(ctx context.Context, opts BenchmarkOptions) (*BenchmarkResult, error) {
+	if opts.Argon2MemoryKiB == 0 {
+		mem, err := defaultArgon2MemoryKiB()
+		if err != nil {
+			return nil, err
+		}
+		opts.Argon2MemoryKiB = mem
+	}
+	if opts.Argon2Threads == 0 {
+		opts.Argon2Threads = uint8(min(runtime.NumCPU(), maxArgon2Threads))
+	}
+	if opts.PBKDF2Iterations <= 0 {
+		opts.PBKDF2Iterations = pbkdf2BenchmarkIterations
+	}
+
+	salt := make([]byte, 32)
+	passphrase := []byte("snap-tpmctl-benchmark")
+
+	start := time.Now()
+	if _, err := pbkdf2.Key(sha256.New, string(passphrase), salt, opts.PBKDF2Iterations, 32); err != nil {
+		return nil, fmt.Errorf("failed to benchmark pbkdf2: %w", err)
+	}
+	pbkdf2Elapsed := max(time.Since(start), time.Microsecond)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	argon2.IDKey(passphrase, salt, 1, opts.Argon2MemoryKiB, opts.Argon2Threads, 32)
+	argon2Elapsed := max(time.Since(start), time.Microsecond)
+
+	res := &BenchmarkResult{
+		Argon2MemoryKiB:           opts.Argon2MemoryKiB,
+		Argon2Threads:             opts.Argon2Threads,
+		Argon2Iteration:           argon2Elapsed,
+		PBKDF2IterationsPerSecond: int(float64(opts.PBKDF2Iterations) / pbkdf2Elapsed.Seconds()),
+	}
+	res.RecommendedTime = recommendKDFTime(res.Argon2Iteration, res.PBKDF2IterationsPerSecond)
+
+	return res, nil
+}
+
+// recommendKDFTime returns the lowest time target, rounded up to kdfTimeStep, reaching the minimum safe cost for both KDFs.
+func recommendKDFTime(argon2Iteration time.Duration, pbkdf2PerSecond int) time.Duration {
+	target := minKDFTime
+
+	target = max(target, argon2Iteration*minArgon2Iterations)
+	if pbkdf2PerSecond > 0 {
+		target = max(target, time.Duration(math.Ceil(float64(minPBKDF2Iterations)/float64(pbkdf2PerSecond)*float64(time.Second))))
+	}
+
+	if rem := target % kdfTimeStep; rem != 0 {
+		target += kdfTimeStep - rem
+	}
+
+	return target
+}
+
+// defaultArgon2MemoryKiB returns half of the machine memory, capped to maxArgon2MemoryKiB.
+func defaultArgon2MemoryKiB() (uint32, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory information: %w", err)
+	}
+	defer f.Close()
+
+	total, err := parseMemTotalKiB(f)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(min(total/2, maxArgon2MemoryKiB)), nil
+}
+
+// parseMemTotalKiB returns the MemTotal entry of a /proc/meminfo formatted content.
+func parseMemTotalKiB(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "MemTotal:")
+		if !ok {
+			continue
+		}
+
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB"))
+		total, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal value %q: %w", value, err)
+		}
+		return total, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read memory information: %w", err)
+	}
+
+	return 0, fmt.Errorf("MemTotal not found in memory information")
+}
@@ -0,0 +1,84 @@
+package fde_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+)
+
+func TestBenchmarkKDF(t *testing.T) {
+	t.Parallel()
+
+	res, err := fde.BenchmarkKDF(context.Background(), fde.BenchmarkOptions{
+		Argon2MemoryKiB:  64,
+		Argon2Threads:    1,
+		PBKDF2Iterations: 1000,
+	})
+	be.Err(t, err, nil)
+	be.Equal(t, uint32(64), res.Argon2MemoryKiB)
+	be.Equal(t, uint8(1), res.Argon2Threads)
+	be.True(t, res.Argon2Iteration > 0)
+	be.True(t, res.PBKDF2IterationsPerSecond > 0)
+	be.True(t, res.RecommendedTime >= time.Second)
+}
+
+func TestRecommendKDFTime(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		argon2Iteration time.Duration
+		pbkdf2PerSecond int
+
+		want time.Duration
+	}{
+		"Minimum target on fast machines":    {argon2Iteration: 10 * time.Millisecond, pbkdf2PerSecond: 10_000_000, want: time.Second},
+		"Target driven by argon2":            {argon2Iteration: 400 * time.Millisecond, pbkdf2PerSecond: 10_000_000, want: 1750 * time.Millisecond},
+		"Target driven by pbkdf2":            {argon2Iteration: 10 * time.Millisecond, pbkdf2PerSecond: 500_000, want: 2 * time.Second},
+		"Target rounded up to the next step": {argon2Iteration: 260 * time.Millisecond, pbkdf2PerSecond: 10_000_000, want: 1250 * time.Millisecond},
+		"Ignores pbkdf2 when not measurable": {argon2Iteration: 10 * time.Millisecond, want: time.Second},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := fde.RecommendKDFTime(tc.argon2Iteration, tc.pbkdf2PerSecond)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseMemTotalKiB(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		meminfo string
+
+		want    uint64
+		wantErr bool
+	}{
+		"Parses MemTotal": {meminfo: "MemTotal:       16303012 kB\nMemFree:         1234 kB\n", want: 16303012},
+
+		"Error when MemTotal missing": {meminfo: "MemFree:         1234 kB\n", wantErr: true},
+		"Error when MemTotal invalid": {meminfo: "MemTotal:       lots kB\n", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.ParseMemTotalKiB(strings.NewReader(tc.meminfo))
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package fde
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// KeyFormat is the encoding of a LUKS passphrase written out for other tools.
+type KeyFormat string
+
+// Supported LUKS passphrase encodings.
+const (
+	// KeyFormatRaw is the raw binary passphrase, usable as a cryptsetup --key-file.
+	KeyFormatRaw    KeyFormat = "raw"
+	KeyFormatHex    KeyFormat = "hex"
+	KeyFormatBase64 KeyFormat = "base64"
+)
+
+// LUKSPassphrase returns the raw passphrase of the LUKS keyslot protected by the recovery key.
+// snapd uses the 16 bytes of the recovery key as is.
+func (k RecoveryKey) LUKSPassphrase() []byte {
+	return k[:]
+}
+
+// EncodeLUKSPassphrase returns the LUKS passphrase of the recovery key in the given format.
+// Text formats are terminated by a newline.
+func EncodeLUKSPassphrase(key RecoveryKey, format KeyFormat) ([]byte, error) {
+	passphrase := key.LUKSPassphrase()
+
+	switch format {
+	case KeyFormatRaw:
+		return passphrase, nil
+	case KeyFormatHex:
+		return []byte(hex.EncodeToString(passphrase) + "\n"), nil
+	case KeyFormatBase64:
+		return []byte(base64.StdEncoding.EncodeToString(passphrase) + "\n"), nil
+	default:
+		return nil, fmt.Errorf("invalid key format %q: must be one of raw, hex or base64", format)
+	}
+}
+
+// WriteKeyFile atomically creates a root-only file with the given content.
+// It refuses to overwrite an existing file, and never leaves a partially written file behind.
+func WriteKeyFile(path string, data []byte) error {
+	return writeNewFile(path, data)
+}
+
+// writeNewFile atomically creates a root-only file with the given content, refusing to overwrite an existing one.
+func writeNewFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	// Unlike a rename, a hard link fails if the destination already exists.
+	if err := os.Link(f.Name(), path); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("refusing to overwrite existing file %s", path)
+		}
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package fde_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+)
+
+func TestEncodeLUKSPassphrase(t *testing.T) {
+	t.Parallel()
+
+	key := fde.RecoveryKey{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+
+	tests := map[string]struct {
+		format fde.KeyFormat
+
+		want    []byte
+		wantErr bool
+	}{
+		"Raw":    {format: fde.KeyFormatRaw, want: key[:]},
+		"Hex":    {format: fde.KeyFormatHex, want: []byte("000102030405060708090a0b0c0d0e0f\n")},
+		"Base64": {format: fde.KeyFormatBase64, want: []byte("AAECAwQFBgcICQoLDA0ODw==\n")},
+
+		"Error on unknown format": {format: "pem", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.EncodeLUKSPassphrase(key, tc.format)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestWriteKeyFile(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		existing bool
+		noParent bool
+
+		wantErr bool
+	}{
+		"Creates key file": {},
+
+		"Error when file exists":      {existing: true, wantErr: true},
+		"Error when parent is absent": {noParent: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "luks.key")
+			if tc.noParent {
+				path = filepath.Join(dir, "missing", "luks.key")
+			}
+			if tc.existing {
+				be.Err(t, os.WriteFile(path, []byte("previous"), 0600), nil)
+			}
+
+			err := fde.WriteKeyFile(path, []byte("secret"))
+
+			entries, dErr := os.ReadDir(dir)
+			be.Err(t, dErr, nil)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				if tc.existing {
+					got, rErr := os.ReadFile(path)
+					be.Err(t, rErr, nil)
+					be.Equal(t, "previous", string(got))
+					be.Equal(t, 1, len(entries))
+				}
+				return
+			}
+			be.Err(t, err, nil)
+
+			got, err := os.ReadFile(path)
+			be.Err(t, err, nil)
+			be.Equal(t, "secret", string(got))
+
+			fi, err := os.Stat(path)
+			be.Err(t, err, nil)
+			be.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+			// No temporary file is left behind.
+			be.Equal(t, 1, len(entries))
+		})
+	}
+}
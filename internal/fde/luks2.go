@@ -0,0 +1,364 @@
+package fde
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+)
+
+const (
+	// luks2BinaryHeaderSize is the size of the binary part of a LUKS2 header, preceding the JSON area.
+	luks2BinaryHeaderSize = 4096
+
+	luks2ChecksumOffset = 448
+	luks2ChecksumSize   = 64
+)
+
+var (
+	luks2MagicPrimary   = []byte{'L', 'U', 'K', 'S', 0xba, 0xbe}
+	luks2MagicSecondary = []byte{'S', 'K', 'U', 'L', 0xba, 0xbe}
+
+	// luks2SecondaryOffsets are the possible offsets of the secondary header, used when the primary one is damaged.
+	luks2SecondaryOffsets = []int64{
+		0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000,
+	}
+)
+
+// luks2BinaryHeader is the on-disk layout of the binary LUKS2 header. All integers are big-endian.
+type luks2BinaryHeader struct {
+	Magic       [6]byte
+	Version     uint16
+	HeaderSize  uint64
+	SeqID       uint64
+	Label       [48]byte
+	ChecksumAlg [32]byte
+	Salt        [64]byte
+	UUID        [40]byte
+	Subsystem   [48]byte
+	HeaderOff   uint64
+	_           [184]byte
+	Checksum    [luks2ChecksumSize]byte
+	_           [7 * 512]byte
+}
+
+// LUKS2Header is a decoded and verified LUKS2 header.
+type LUKS2Header struct {
+	// Offset is the position of this header on the device.
+	Offset int64
+	// Primary is true for the primary header, false for the secondary one.
+	Primary bool
+
+	Version           uint16
+	HeaderSize        uint64
+	SeqID             uint64
+	Label             string
+	ChecksumAlgorithm string
+	UUID              string
+	Subsystem         string
+
+	Metadata LUKS2Metadata
+}
+
+// LUKS2Metadata is the JSON metadata area of a LUKS2 header.
+type LUKS2Metadata struct {
+	Keyslots map[string]LUKS2Keyslot `json:"keyslots"`
+	Tokens   map[string]LUKS2Token   `json:"tokens"`
+	Segments map[string]LUKS2Segment `json:"segments"`
+	Digests  map[string]LUKS2Digest  `json:"digests"`
+	Config   LUKS2Config             `json:"config"`
+}
+
+// KeyslotIDs returns the IDs of the keyslots, in numerical order.
+func (m LUKS2Metadata) KeyslotIDs() []string {
+	return sortedIDs(m.Keyslots)
+}
+
+// TokenIDs returns the IDs of the tokens, in numerical order.
+func (m LUKS2Metadata) TokenIDs() []string {
+	return sortedIDs(m.Tokens)
+}
+
+// KeyslotTokens returns the IDs of the tokens attached to a keyslot, in numerical order.
+func (m LUKS2Metadata) KeyslotTokens(keyslot string) []string {
+	var ids []string
+	for _, id := range m.TokenIDs() {
+		if slices.Contains(m.Tokens[id].Keyslots, keyslot) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// LUKS2Keyslot describes a keyslot and how to derive the key decrypting its area.
+type LUKS2Keyslot struct {
+	Type     string           `json:"type"`
+	KeySize  int              `json:"key_size"`
+	Priority *int             `json:"priority,omitempty"`
+	Area     LUKS2KeyslotArea `json:"area"`
+	KDF      LUKS2KDF         `json:"kdf"`
+	AF       LUKS2AF          `json:"af"`
+}
+
+// LUKS2KeyslotArea is the encrypted area storing the anti-forensic split volume key.
+type LUKS2KeyslotArea struct {
+	Type       string     `json:"type"`
+	Offset     JSONUint64 `json:"offset"`
+	Size       JSONUint64 `json:"size"`
+	Encryption string     `json:"encryption"`
+	KeySize    int        `json:"key_size"`
+}
+
+// LUKS2KDF are the parameters of the key derivation function of a keyslot or digest.
+type LUKS2KDF struct {
+	Type string `json:"type"`
+	Salt []byte `json:"salt"`
+
+	// Argon2 parameters.
+	Time   uint32 `json:"time,omitempty"`
+	Memory uint32 `json:"memory,omitempty"`
+	CPUs   uint8  `json:"cpus,omitempty"`
+
+	// PBKDF2 parameters.
+	Hash       string `json:"hash,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// LUKS2AF are the parameters of the anti-forensic splitter of a keyslot.
+type LUKS2AF struct {
+	Type    string `json:"type"`
+	Stripes int    `json:"stripes"`
+	Hash    string `json:"hash"`
+}
+
+// LUKS2Segment is an encrypted data segment of the device.
+type LUKS2Segment struct {
+	Type   string     `json:"type"`
+	Offset JSONUint64 `json:"offset"`
+	// Size is either a number of bytes or "dynamic".
+	Size       string `json:"size"`
+	IVTweak    string `json:"iv_tweak"`
+	Encryption string `json:"encryption"`
+	SectorSize int    `json:"sector_size"`
+}
+
+// LUKS2Digest verifies the volume key recovered from a keyslot.
+type LUKS2Digest struct {
+	Type       string   `json:"type"`
+	Keyslots   []string `json:"keyslots"`
+	Segments   []string `json:"segments"`
+	Hash       string   `json:"hash"`
+	Iterations int      `json:"iterations"`
+	Salt       []byte   `json:"salt"`
+	Digest     []byte   `json:"digest"`
+}
+
+// LUKS2Token is a token attached to keyslots. Its content depends on its type.
+type LUKS2Token struct {
+	Type     string   `json:"type"`
+	Keyslots []string `json:"keyslots"`
+
+	// Raw is the full JSON object of the token.
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the common fields of the token and keeps its raw content.
+func (t *LUKS2Token) UnmarshalJSON(data []byte) error {
+	type token LUKS2Token
+	var tok token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return err
+	}
+
+	*t = LUKS2Token(tok)
+	t.Raw = append(json.RawMessage(nil), data...)
+
+	return nil
+}
+
+// LUKS2Config is the global configuration of the LUKS2 header.
+type LUKS2Config struct {
+	JSONSize     JSONUint64 `json:"json_size"`
+	KeyslotsSize JSONUint64 `json:"keyslots_size"`
+}
+
+// JSONUint64 is a uint64 encoded as a JSON string, as LUKS2 does for offsets and sizes.
+type JSONUint64 uint64
+
+// UnmarshalJSON decodes a uint64 stored as a JSON string.
+func (u *JSONUint64) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a number as string: %w", err)
+	}
+
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q: %w", s, err)
+	}
+	*u = JSONUint64(v)
+
+	return nil
+}
+
+// MarshalJSON encodes the uint64 as a JSON string.
+func (u JSONUint64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatUint(uint64(u), 10))
+}
+
+// OpenLUKS2Header reads the most recent valid LUKS2 header of a device or image file.
+func OpenLUKS2Header(path string) (*LUKS2Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hdr, err := ReadLUKS2Header(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return hdr, nil
+}
+
+// ReadLUKS2Header reads both LUKS2 headers and returns the valid one with the highest sequence ID.
+// The secondary header is searched at all its possible offsets when the primary one is damaged.
+func ReadLUKS2Header(r io.ReaderAt) (*LUKS2Header, error) {
+	primary, primaryErr := ReadLUKS2HeaderAt(r, 0)
+
+	offsets := luks2SecondaryOffsets
+	if primaryErr == nil {
+		offsets = []int64{int64(primary.HeaderSize)}
+	}
+
+	var secondary *LUKS2Header
+	var secondaryErr error
+	for _, off := range offsets {
+		secondary, secondaryErr = ReadLUKS2HeaderAt(r, off)
+		if secondaryErr == nil {
+			break
+		}
+	}
+
+	switch {
+	case primaryErr != nil && secondaryErr != nil:
+		return nil, fmt.Errorf("no valid LUKS2 header found: primary: %v, secondary: %v", primaryErr, secondaryErr)
+	case primaryErr != nil:
+		return secondary, nil
+	case secondaryErr != nil:
+		return primary, nil
+	case secondary.SeqID > primary.SeqID:
+		return secondary, nil
+	default:
+		return primary, nil
+	}
+}
+
+// ReadLUKS2HeaderAt reads and verifies the LUKS2 header stored at the given offset.
+func ReadLUKS2HeaderAt(r io.ReaderAt, offset int64) (*LUKS2Header, error) {
+	buf := make([]byte, luks2BinaryHeaderSize)
+	if _, err := r.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("failed to read header at offset %d: %w", offset, err)
+	}
+
+	var bin luks2BinaryHeader
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &bin); err != nil {
+		return nil, fmt.Errorf("failed to decode header at offset %d: %w", offset, err)
+	}
+
+	var primary bool
+	switch {
+	case bytes.Equal(bin.Magic[:], luks2MagicPrimary):
+		primary = true
+	case bytes.Equal(bin.Magic[:], luks2MagicSecondary):
+	default:
+		return nil, fmt.Errorf("no LUKS header at offset %d", offset)
+	}
+
+	if bin.Version != 2 {
+		return nil, fmt.Errorf("unsupported LUKS version %d at offset %d", bin.Version, offset)
+	}
+	if bin.HeaderOff != uint64(offset) {
+		return nil, fmt.Errorf("header at offset %d claims to be at offset %d", offset, bin.HeaderOff)
+	}
+	if bin.HeaderSize <= luks2BinaryHeaderSize || bin.HeaderSize > 4*1024*1024 {
+		return nil, fmt.Errorf("invalid header size %d at offset %d", bin.HeaderSize, offset)
+	}
+
+	area := make([]byte, bin.HeaderSize)
+	if _, err := r.ReadAt(area, offset); err != nil {
+		return nil, fmt.Errorf("failed to read header area at offset %d: %w", offset, err)
+	}
+
+	alg := cString(bin.ChecksumAlg[:])
+	sum, err := luks2Checksum(alg, area)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, bin.Checksum[:len(sum)]) {
+		return nil, fmt.Errorf("header checksum mismatch at offset %d", offset)
+	}
+
+	hdr := &LUKS2Header{
+		Offset:            offset,
+		Primary:           primary,
+		Version:           bin.Version,
+		HeaderSize:        bin.HeaderSize,
+		SeqID:             bin.SeqID,
+		Label:             cString(bin.Label[:]),
+		ChecksumAlgorithm: alg,
+		UUID:              cString(bin.UUID[:]),
+		Subsystem:         cString(bin.Subsystem[:]),
+	}
+
+	jsonArea := bytes.TrimRight(area[luks2BinaryHeaderSize:], "\x00")
+	if err := json.Unmarshal(jsonArea, &hdr.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata at offset %d: %w", offset, err)
+	}
+
+	return hdr, nil
+}
+
+// luks2Checksum computes the checksum of a full header area, ignoring its checksum field.
+func luks2Checksum(alg string, area []byte) ([]byte, error) {
+	var h hash.Hash
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("unsupported header checksum algorithm %q", alg)
+	}
+
+	h.Write(area[:luks2ChecksumOffset])
+	h.Write(make([]byte, luks2ChecksumSize))
+	h.Write(area[luks2ChecksumOffset+luks2ChecksumSize:])
+
+	return h.Sum(nil), nil
+}
+
+// sortedIDs returns the keys of a LUKS2 JSON object, sorted numerically.
+func sortedIDs[V any](m map[string]V) []string {
+	return slices.SortedFunc(maps.Keys(m), func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), cmp.Compare(a, b))
+	})
+}
+
+// cString returns the content of a NUL terminated string field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
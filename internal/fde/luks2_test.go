@@ -0,0 +1,141 @@
+package fde_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+// luks2JSONOffset is the offset of the JSON metadata inside a LUKS2 header.
+const luks2JSONOffset = 4096
+
+func TestReadLUKS2Header(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		seqID          uint64
+		secondarySeqID uint64
+		corrupt        func(image []byte)
+
+		wantPrimary bool
+		wantSeqID   uint64
+		wantErr     bool
+	}{
+		"Reads primary header":                    {seqID: 3, wantPrimary: true, wantSeqID: 3},
+		"Prefers the most recent header":          {seqID: 3, secondarySeqID: 4, wantSeqID: 4},
+		"Falls back to secondary on bad magic":    {seqID: 3, corrupt: func(image []byte) { image[0] = 0 }, wantSeqID: 3},
+		"Falls back to secondary on bad checksum": {seqID: 3, corrupt: func(image []byte) { image[luks2JSONOffset] = ' ' }, wantSeqID: 3},
+		"Finds secondary at probed offset": {
+			seqID: 3,
+			corrupt: func(image []byte) {
+				clear(image[8:16])
+				testutils.SealLUKS2Header(image, 0)
+			},
+			wantSeqID: 3,
+		},
+
+		"Error when both headers are damaged": {
+			seqID: 3,
+			corrupt: func(image []byte) {
+				image[0] = 0
+				image[testutils.LUKS2HeaderSize+luks2JSONOffset] = ' '
+			},
+			wantErr: true,
+		},
+		"Error on unsupported version": {
+			seqID: 3,
+			corrupt: func(image []byte) {
+				for _, off := range []int{0, testutils.LUKS2HeaderSize} {
+					image[off+7] = 1
+					testutils.SealLUKS2Header(image, off)
+				}
+			},
+			wantErr: true,
+		},
+		"Error on invalid metadata": {
+			seqID: 3,
+			corrupt: func(image []byte) {
+				for _, off := range []int{0, testutils.LUKS2HeaderSize} {
+					copy(image[off+luks2JSONOffset:], "{]")
+					testutils.SealLUKS2Header(image, off)
+				}
+			},
+			wantErr: true,
+		},
+		"Error when not a LUKS device": {
+			corrupt: func(image []byte) { clear(image) },
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			image := testutils.NewLUKS2Image(t, testutils.LUKS2Options{
+				Label:          "ubuntu-data-enc",
+				SeqID:          tc.seqID,
+				SecondarySeqID: tc.secondarySeqID,
+			})
+			if tc.corrupt != nil {
+				tc.corrupt(image)
+			}
+
+			hdr, err := fde.ReadLUKS2Header(bytes.NewReader(image))
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.wantPrimary, hdr.Primary)
+			be.Equal(t, tc.wantSeqID, hdr.SeqID)
+			be.Equal(t, "ubuntu-data-enc", hdr.Label)
+			be.Equal(t, "4b7c8c2e-6f5d-4c8e-9a1b-3d2f1e0c9b8a", hdr.UUID)
+			be.Equal(t, uint64(testutils.LUKS2HeaderSize), hdr.HeaderSize)
+		})
+	}
+}
+
+func TestOpenLUKS2HeaderMetadata(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "disk.img")
+	err := os.WriteFile(path, testutils.NewLUKS2Image(t, testutils.LUKS2Options{SeqID: 1}), 0600)
+	be.Err(t, err, nil)
+
+	hdr, err := fde.OpenLUKS2Header(path)
+	be.Err(t, err, nil)
+
+	md := hdr.Metadata
+	be.Equal(t, 2, len(md.Keyslots))
+	be.Equal(t, "luks2", md.Keyslots["0"].Type)
+	be.Equal(t, 64, md.Keyslots["0"].KeySize)
+	be.Equal(t, "argon2id", md.Keyslots["0"].KDF.Type)
+	be.Equal(t, uint32(4), md.Keyslots["0"].KDF.Time)
+	be.Equal(t, fde.JSONUint64(0x8000), md.Keyslots["0"].Area.Offset)
+	be.Equal(t, "pbkdf2", md.Keyslots["1"].KDF.Type)
+	be.Equal(t, 1000, md.Keyslots["1"].KDF.Iterations)
+	be.Equal(t, 4000, md.Keyslots["1"].AF.Stripes)
+
+	be.Equal(t, []string{"0", "1"}, md.KeyslotIDs())
+	be.Equal(t, []string{"0"}, md.KeyslotTokens("0"))
+	be.Equal(t, []string{"1"}, md.KeyslotTokens("1"))
+
+	be.Equal(t, "ubuntu-fde", md.Tokens["0"].Type)
+	be.Equal(t, []string{"0"}, md.Tokens["0"].Keyslots)
+	be.True(t, len(md.Tokens["0"].Raw) > 0)
+
+	be.Equal(t, "aes-xts-plain64", md.Segments["0"].Encryption)
+	be.Equal(t, fde.JSONUint64(16777216), md.Segments["0"].Offset)
+	be.Equal(t, []string{"0", "1"}, md.Digests["0"].Keyslots)
+	be.Equal(t, fde.JSONUint64(12288), md.Config.JSONSize)
+
+	_, err = fde.OpenLUKS2Header(filepath.Join(t.TempDir(), "missing.img"))
+	be.Err(t, err)
+}
@@ -0,0 +1,63 @@
+package fde
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// recoveryKeyGroups is the number of decimal groups of a recovery key.
+	recoveryKeyGroups = 8
+	// recoveryKeyGroupDigits is the number of digits of each group.
+	recoveryKeyGroupDigits = 5
+)
+
+// RecoveryKey is a 16 bytes recovery key, as generated by snapd.
+//
+// Its text form is 8 groups of 5 decimal digits separated by hyphens, each group
+// encoding 2 bytes of the key as a little-endian uint16.
+type RecoveryKey [16]byte
+
+// String returns the text form of the recovery key.
+func (k RecoveryKey) String() string {
+	groups := make([]string, recoveryKeyGroups)
+	for i := range groups {
+		x := binary.LittleEndian.Uint16(k[i*2:])
+		groups[i] = fmt.Sprintf("%05d", x)
+	}
+
+	return strings.Join(groups, "-")
+}
+
+// ParseRecoveryKey parses the text form of a recovery key.
+// Errors report the first group, numbered from 1, which is malformed or out of range.
+func ParseRecoveryKey(s string) (RecoveryKey, error) {
+	var key RecoveryKey
+
+	groups := strings.Split(s, "-")
+	if len(groups) != recoveryKeyGroups {
+		return key, fmt.Errorf("expected %d groups of %d digits separated by hyphens, got %d groups",
+			recoveryKeyGroups, recoveryKeyGroupDigits, len(groups))
+	}
+
+	for i, group := range groups {
+		if len(group) != recoveryKeyGroupDigits {
+			return key, fmt.Errorf("group %d %q must have exactly %d digits", i+1, group, recoveryKeyGroupDigits)
+		}
+		for _, ch := range group {
+			if ch < '0' || ch > '9' {
+				return key, fmt.Errorf("group %d %q must contain only digits", i+1, group)
+			}
+		}
+
+		x, err := strconv.ParseUint(group, 10, 16)
+		if err != nil {
+			return key, fmt.Errorf("group %d %q is out of range: must be at most 65535", i+1, group)
+		}
+		binary.LittleEndian.PutUint16(key[i*2:], uint16(x))
+	}
+
+	return key, nil
+}
@@ -0,0 +1,109 @@
+package fde_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestParseRecoveryKey(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		key string
+
+		want      fde.RecoveryKey
+		wantInErr string
+	}{
+		"Known vector": {
+			key:  "00256-00770-01284-01798-02312-02826-03340-03854",
+			want: fde.RecoveryKey{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f},
+		},
+		"All zeros": {
+			key: "00000-00000-00000-00000-00000-00000-00000-00000",
+		},
+		"All ones": {
+			key:  "65535-65535-65535-65535-65535-65535-65535-65535",
+			want: fde.RecoveryKey{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+
+		"Error when empty":               {key: "", wantInErr: "got 1 groups"},
+		"Error when too few groups":      {key: "12345-12345-12345", wantInErr: "got 3 groups"},
+		"Error when too many groups":     {key: "12345-12345-12345-12345-12345-12345-12345-12345-12345", wantInErr: "got 9 groups"},
+		"Error when group too short":     {key: "12345-1234-12345-12345-12345-12345-12345-12345", wantInErr: `group 2 "1234" must have exactly 5 digits`},
+		"Error when group too long":      {key: "12345-12345-12345-12345-12345-12345-12345-123456", wantInErr: `group 8 "123456" must have exactly 5 digits`},
+		"Error when group has letters":   {key: "12345-12345-1a345-12345-12345-12345-12345-12345", wantInErr: `group 3 "1a345" must contain only digits`},
+		"Error when group has sign":      {key: "12345-12345-12345-+1234-12345-12345-12345-12345", wantInErr: `group 4 "+1234" must contain only digits`},
+		"Error when group out of range":  {key: "12345-12345-12345-12345-65536-12345-12345-12345", wantInErr: `group 5 "65536" is out of range`},
+		"Error when wrong separator":     {key: "12345_12345_12345_12345_12345_12345_12345_12345", wantInErr: "got 1 groups"},
+		"Error when surrounded by space": {key: " 12345-12345-12345-12345-12345-12345-12345-12345", wantInErr: `group 1 " 12345" must have exactly 5 digits`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.ParseRecoveryKey(tc.key)
+
+			if tc.wantInErr != "" {
+				be.Err(t, err, tc.wantInErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+			be.Equal(t, tc.key, got.String())
+		})
+	}
+}
+
+func TestRecoveryKeyString(t *testing.T) {
+	t.Parallel()
+
+	key := fde.RecoveryKey{0xe1, 0x5f, 0x1a, 0x10, 0x9b, 0x6d, 0x3c, 0x41, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0x80}
+	be.Equal(t, "24545-04122-28059-16700-00000-65535-00001-32768", key.String())
+}
+
+func FuzzRecoveryKeyRoundTrip(f *testing.F) {
+	f.Add([]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f})
+	f.Add(make([]byte, 16))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var key fde.RecoveryKey
+		copy(key[:], data)
+
+		got, err := fde.ParseRecoveryKey(key.String())
+		be.Err(t, err, nil)
+		be.Equal(t, key, got)
+	})
+}
+
+func FuzzParseRecoveryKey(f *testing.F) {
+	f.Add("00256-00770-01284-01798-02312-02826-03340-03854")
+	f.Add("65535-65535-65535-65535-65535-65535-65535-65536")
+	f.Add("12345-12345")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		key, err := fde.ParseRecoveryKey(s)
+		if err != nil {
+			return
+		}
+
+		// Accepted keys are always in canonical form.
+		be.Equal(t, s, key.String())
+	})
+}
+
+func TestMockRecoveryKeyIsValid(t *testing.T) {
+	t.Parallel()
+
+	// The tests of the callers of snapd rely on the mock generating keys the codec accepts.
+	key, err := testutils.NewMockSnapdClient(testutils.MockConfig{}).GenerateRecoveryKey(context.Background())
+	be.Err(t, err, nil)
+
+	got, err := fde.ParseRecoveryKey(key.RecoveryKey)
+	be.Err(t, err, nil)
+	be.Equal(t, got.String(), key.RecoveryKey)
+}
@@ -0,0 +1,199 @@
+package fde
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxKeyShares is the maximum number of shares, as share indexes are non-zero elements of GF(256).
+const maxKeyShares = 255
+
+// keyShareGroups is the number of hyphen separated groups of the text form of a share:
+// the threshold, the index, the 8 groups of the share value and the checksum.
+const keyShareGroups = 2 + recoveryKeyGroups + 1
+
+// KeyShare is a share of a recovery key split with Shamir's secret sharing over GF(256).
+// Any Threshold shares of a split reconstruct the key, fewer reveal nothing about it.
+type KeyShare struct {
+	// Threshold is the number of shares needed to reconstruct the key.
+	Threshold int
+	// Index is the x coordinate of the share, from 1.
+	Index int
+	// Value has the y coordinates of the share for each byte of the key.
+	Value [16]byte
+}
+
+// String returns the text form of the share: its threshold, its index, its value in the
+// recovery key form and a checksum, all separated by hyphens.
+// For example, 2-1-24545-04122-28059-16700-00000-65535-00001-32768-29559.
+func (s KeyShare) String() string {
+	return fmt.Sprintf("%d-%d-%s-%05d", s.Threshold, s.Index, RecoveryKey(s.Value), s.checksum())
+}
+
+// checksum detects typos in any group of the text form of the share.
+func (s KeyShare) checksum() uint16 {
+	sum := sha256.Sum256(append([]byte{byte(s.Threshold), byte(s.Index)}, s.Value[:]...))
+	return binary.LittleEndian.Uint16(sum[:])
+}
+
+// ParseKeyShare parses the text form of a key share.
+func ParseKeyShare(s string) (KeyShare, error) {
+	var share KeyShare
+
+	groups := strings.Split(s, "-")
+	if len(groups) != keyShareGroups {
+		return share, fmt.Errorf("expected %d groups separated by hyphens, got %d groups", keyShareGroups, len(groups))
+	}
+
+	threshold, err := strconv.Atoi(groups[0])
+	if err != nil || threshold < 2 || threshold > maxKeyShares || groups[0] != strconv.Itoa(threshold) {
+		return share, fmt.Errorf("invalid threshold %q: must be between 2 and %d", groups[0], maxKeyShares)
+	}
+	index, err := strconv.Atoi(groups[1])
+	if err != nil || index < 1 || index > maxKeyShares || groups[1] != strconv.Itoa(index) {
+		return share, fmt.Errorf("invalid share index %q: must be between 1 and %d", groups[1], maxKeyShares)
+	}
+
+	value, err := ParseRecoveryKey(strings.Join(groups[2:2+recoveryKeyGroups], "-"))
+	if err != nil {
+		return share, fmt.Errorf("invalid share value: %w", err)
+	}
+
+	share = KeyShare{Threshold: threshold, Index: index, Value: value}
+
+	checksum, err := strconv.ParseUint(groups[keyShareGroups-1], 10, 16)
+	if err != nil || len(groups[keyShareGroups-1]) != recoveryKeyGroupDigits || uint16(checksum) != share.checksum() {
+		return KeyShare{}, errors.New("checksum mismatch: the share was mistyped")
+	}
+
+	return share, nil
+}
+
+// SplitRecoveryKey splits a recovery key in shares, any threshold of which reconstruct it.
+func SplitRecoveryKey(key RecoveryKey, threshold, shares int) ([]KeyShare, error) {
+	if threshold < 2 {
+		return nil, fmt.Errorf("invalid threshold %d: at least 2 shares must be needed to reconstruct the key", threshold)
+	}
+	if shares < threshold || shares > maxKeyShares {
+		return nil, fmt.Errorf("invalid number of shares %d: must be between the threshold %d and %d", shares, threshold, maxKeyShares)
+	}
+
+	res := make([]KeyShare, shares)
+	for i := range res {
+		res[i] = KeyShare{Threshold: threshold, Index: i + 1}
+	}
+
+	// Each byte of the key is the constant term of a random polynomial of degree threshold-1,
+	// evaluated at the index of each share.
+	coeffs := make([]byte, threshold)
+	for b := range key {
+		coeffs[0] = key[b]
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate key shares: %w", err)
+		}
+		for i := range res {
+			res[i].Value[b] = gfEval(coeffs, byte(res[i].Index))
+		}
+	}
+	clear(coeffs)
+
+	return res, nil
+}
+
+// CombineKeyShares reconstructs a recovery key from at least threshold of its shares.
+// Extra shares are checked against the reconstructed key.
+func CombineKeyShares(shares []KeyShare) (RecoveryKey, error) {
+	var key RecoveryKey
+
+	if len(shares) == 0 {
+		return key, errors.New("no key share given")
+	}
+	threshold := shares[0].Threshold
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if s.Threshold != threshold {
+			return key, fmt.Errorf("share %d needs %d shares while share %d needs %d: they are not from the same key",
+				s.Index, s.Threshold, shares[0].Index, threshold)
+		}
+		if s.Index < 1 || s.Index > maxKeyShares {
+			return key, fmt.Errorf("invalid share index %d", s.Index)
+		}
+		if seen[s.Index] {
+			return key, fmt.Errorf("share %d was given twice", s.Index)
+		}
+		seen[s.Index] = true
+	}
+	if len(shares) < threshold {
+		return key, fmt.Errorf("%d shares are needed to reconstruct the key, got %d", threshold, len(shares))
+	}
+
+	base := shares[:threshold]
+	for b := range key {
+		key[b] = gfInterpolate(base, b, 0)
+	}
+
+	for _, s := range shares[threshold:] {
+		for b := range key {
+			if gfInterpolate(base, b, byte(s.Index)) != s.Value[b] {
+				return RecoveryKey{}, fmt.Errorf("share %d does not match the others: they are not from the same key", s.Index)
+			}
+		}
+	}
+
+	return key, nil
+}
+
+// gfInterpolate returns the value at x of the polynomial going through byte b of the shares.
+func gfInterpolate(shares []KeyShare, b int, x byte) byte {
+	var y byte
+	for i, si := range shares {
+		xi := byte(si.Index)
+		// Lagrange basis polynomial of share i at x. Subtraction is addition in GF(256).
+		l := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			xj := byte(sj.Index)
+			l = gfMul(l, gfMul(x^xj, gfInv(xi^xj)))
+		}
+		y ^= gfMul(si.Value[b], l)
+	}
+	return y
+}
+
+// gfEval returns the value at x of the polynomial with the given coefficients, constant term first.
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// gfMul multiplies in GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// It runs in constant time, not to leak key bytes through timing.
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element, a^254.
+func gfInv(a byte) byte {
+	res := byte(1)
+	for range 7 {
+		a = gfMul(a, a)
+		res = gfMul(res, a)
+	}
+	return res
+}
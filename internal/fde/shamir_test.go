@@ -0,0 +1,172 @@
+package fde_test
+
+import (
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+)
+
+func TestGFArithmetic(t *testing.T) {
+	t.Parallel()
+
+	// Example of FIPS 197, section 4.2.
+	be.Equal(t, fde.GFMul(0x57, 0x83), byte(0xc1))
+	be.Equal(t, fde.GFMul(0x57, 0x13), byte(0xfe))
+
+	for a := 1; a < 256; a++ {
+		be.Equal(t, fde.GFMul(byte(a), fde.GFInv(byte(a))), byte(1))
+	}
+}
+
+func TestSplitRecoveryKey(t *testing.T) {
+	t.Parallel()
+
+	key := fde.RecoveryKey{0xe1, 0x5f, 0x1a, 0x10, 0x9b, 0x6d, 0x3c, 0x41, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0x80}
+
+	tests := map[string]struct {
+		threshold int
+		shares    int
+
+		wantErr string
+	}{
+		"2 of 2":     {threshold: 2, shares: 2},
+		"2 of 3":     {threshold: 2, shares: 3},
+		"3 of 5":     {threshold: 3, shares: 5},
+		"5 of 5":     {threshold: 5, shares: 5},
+		"Max shares": {threshold: 2, shares: 255},
+
+		"Error when threshold is 1":               {threshold: 1, shares: 3, wantErr: "invalid threshold 1"},
+		"Error when shares are below threshold":   {threshold: 3, shares: 2, wantErr: "invalid number of shares 2"},
+		"Error when there are too many shares":    {threshold: 2, shares: 256, wantErr: "invalid number of shares 256"},
+		"Error when threshold is above the limit": {threshold: 256, shares: 256, wantErr: "invalid number of shares 256"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			shares, err := fde.SplitRecoveryKey(key, tc.threshold, tc.shares)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, len(shares), tc.shares)
+
+			for i, s := range shares {
+				be.Equal(t, s.Index, i+1)
+				be.Equal(t, s.Threshold, tc.threshold)
+				be.True(t, s.Value != [16]byte(key))
+
+				parsed, err := fde.ParseKeyShare(s.String())
+				be.Err(t, err, nil)
+				be.Equal(t, parsed, s)
+			}
+
+			// Any threshold consecutive shares, wrapping around, reconstruct the key.
+			for start := range shares {
+				subset := make([]fde.KeyShare, tc.threshold)
+				for i := range subset {
+					subset[i] = shares[(start+i)%len(shares)]
+				}
+				got, err := fde.CombineKeyShares(subset)
+				be.Err(t, err, nil)
+				be.Equal(t, got, key)
+			}
+
+			// All shares reconstruct the key too, the extra ones being checked.
+			got, err := fde.CombineKeyShares(shares)
+			be.Err(t, err, nil)
+			be.Equal(t, got, key)
+		})
+	}
+}
+
+func TestCombineKeyShares(t *testing.T) {
+	t.Parallel()
+
+	key := fde.RecoveryKey{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+	shares, err := fde.SplitRecoveryKey(key, 2, 3)
+	be.Err(t, err, nil)
+	other, err := fde.SplitRecoveryKey(key, 3, 3)
+	be.Err(t, err, nil)
+	unrelated, err := fde.SplitRecoveryKey(fde.RecoveryKey{}, 2, 3)
+	be.Err(t, err, nil)
+
+	tests := map[string]struct {
+		shares []fde.KeyShare
+
+		wantErr string
+	}{
+		"Threshold shares": {shares: []fde.KeyShare{shares[2], shares[0]}},
+
+		"Error when no share is given":      {wantErr: "no key share given"},
+		"Error when too few shares":         {shares: other[:2], wantErr: "3 shares are needed to reconstruct the key, got 2"},
+		"Error when share is given twice":   {shares: []fde.KeyShare{shares[1], shares[1]}, wantErr: "share 2 was given twice"},
+		"Error when thresholds differ":      {shares: []fde.KeyShare{shares[0], other[1]}, wantErr: "not from the same key"},
+		"Error when extra share mismatches": {shares: []fde.KeyShare{shares[0], shares[1], unrelated[2]}, wantErr: "share 3 does not match the others"},
+		"Error when index is invalid":       {shares: []fde.KeyShare{{Threshold: 2, Index: 0}, shares[0]}, wantErr: "invalid share index 0"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.CombineKeyShares(tc.shares)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, key)
+		})
+	}
+}
+
+func TestParseKeyShare(t *testing.T) {
+	t.Parallel()
+
+	const share = "2-1-24545-04122-28059-16700-00000-65535-00001-32768-29559"
+
+	tests := map[string]struct {
+		share string
+
+		want    fde.KeyShare
+		wantErr string
+	}{
+		"Known vector": {
+			share: share,
+			want: fde.KeyShare{
+				Threshold: 2,
+				Index:     1,
+				Value:     [16]byte{0xe1, 0x5f, 0x1a, 0x10, 0x9b, 0x6d, 0x3c, 0x41, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0x80},
+			},
+		},
+
+		"Error when recovery key":        {share: "24545-04122-28059-16700-00000-65535-00001-32768", wantErr: "expected 11 groups"},
+		"Error when threshold is 1":      {share: "1-1-24545-04122-28059-16700-00000-65535-00001-32768-29559", wantErr: `invalid threshold "1"`},
+		"Error when threshold has zeros": {share: "02-1-24545-04122-28059-16700-00000-65535-00001-32768-29559", wantErr: `invalid threshold "02"`},
+		"Error when index is 0":          {share: "2-0-24545-04122-28059-16700-00000-65535-00001-32768-29559", wantErr: `invalid share index "0"`},
+		"Error when index is too large":  {share: "2-256-24545-04122-28059-16700-00000-65535-00001-32768-29559", wantErr: `invalid share index "256"`},
+		"Error when value is malformed":  {share: "2-1-24545-04122-28059-1670a-00000-65535-00001-32768-29559", wantErr: `invalid share value: group 4 "1670a"`},
+		"Error when value is mistyped":   {share: "2-1-24545-04122-28059-16700-00000-65535-00001-32769-29559", wantErr: "checksum mismatch"},
+		"Error when index is mistyped":   {share: "2-2-24545-04122-28059-16700-00000-65535-00001-32768-29559", wantErr: "checksum mismatch"},
+		"Error when checksum is short":   {share: "2-1-24545-04122-28059-16700-00000-65535-00001-32768-2955", wantErr: "checksum mismatch"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.ParseKeyShare(tc.share)
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, tc.want)
+			be.Equal(t, got.String(), tc.share)
+		})
+	}
+}
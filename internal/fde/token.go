@@ -0,0 +1,186 @@
+package fde
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"snap-tpmctl/internal/snapd"
+)
+
+const (
+	// TokenTypePlatform is the type of the LUKS2 tokens holding the key data of a platform keyslot.
+	TokenTypePlatform = "ubuntu-fde"
+	// TokenTypeRecovery is the type of the LUKS2 tokens naming a recovery keyslot.
+	TokenTypeRecovery = "ubuntu-fde-recovery"
+
+	// DefaultByLabelDir is where udev links partitions by filesystem label.
+	DefaultByLabelDir = "/dev/disk/by-label"
+)
+
+// systemVolumeLabels maps the container roles to the labels of their encrypted partitions.
+var systemVolumeLabels = map[string]string{
+	"system-data": "ubuntu-data-enc",
+	"system-save": "ubuntu-save-enc",
+}
+
+// KeySlot is a keyslot decoded from the ubuntu-fde tokens of a LUKS2 header.
+type KeySlot struct {
+	snapd.KeySlotInfo
+
+	Name string
+	// LUKS2Keyslot is the ID of the LUKS2 keyslot the token is attached to.
+	LUKS2Keyslot string
+
+	// KDF protects the passphrase of the platform key, if any.
+	KDF *LUKS2KDF
+}
+
+// ubuntuFDEToken is the content of the ubuntu-fde and ubuntu-fde-recovery tokens written by secboot.
+type ubuntuFDEToken struct {
+	Name string   `json:"ubuntu_fde_name"`
+	Data *keyData `json:"ubuntu_fde_data"`
+}
+
+// keyData is the part of the secboot key data describing how the platform key is protected.
+// Only the fields checked against key data written by secboot are decoded.
+type keyData struct {
+	PlatformName     string             `json:"platform_name"`
+	Role             string             `json:"role"`
+	PassphraseParams *keyDataAuthParams `json:"passphrase_params"`
+}
+
+type keyDataAuthParams struct {
+	KDF LUKS2KDF `json:"kdf"`
+}
+
+// DecodeKeySlots decodes the ubuntu-fde tokens of the metadata into keyslots, in token order.
+// Tokens of other types are ignored.
+func DecodeKeySlots(md LUKS2Metadata) ([]KeySlot, error) {
+	var keySlots []KeySlot
+	for _, id := range md.TokenIDs() {
+		token := md.Tokens[id]
+		if token.Type != TokenTypePlatform && token.Type != TokenTypeRecovery {
+			continue
+		}
+
+		ks, err := decodeKeySlot(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %s: %w", id, err)
+		}
+		keySlots = append(keySlots, ks)
+	}
+
+	return keySlots, nil
+}
+
+func decodeKeySlot(token LUKS2Token) (KeySlot, error) {
+	var t ubuntuFDEToken
+	if err := json.Unmarshal(token.Raw, &t); err != nil {
+		return KeySlot{}, err
+	}
+	if t.Name == "" {
+		return KeySlot{}, errors.New("missing keyslot name")
+	}
+	if len(token.Keyslots) != 1 {
+		return KeySlot{}, fmt.Errorf("expected 1 keyslot, got %d", len(token.Keyslots))
+	}
+
+	ks := KeySlot{
+		Name:         t.Name,
+		LUKS2Keyslot: token.Keyslots[0],
+	}
+
+	if token.Type == TokenTypeRecovery {
+		ks.Type = "recovery"
+		return ks, nil
+	}
+
+	if t.Data == nil {
+		return KeySlot{}, errors.New("missing key data")
+	}
+
+	ks.Type = "platform"
+	ks.PlatformName = t.Data.PlatformName
+	if t.Data.Role != "" {
+		ks.Roles = []string{t.Data.Role}
+	}
+
+	// Other auth modes are left unknown, as only the passphrase params are decoded.
+	if t.Data.PassphraseParams != nil {
+		ks.AuthMode = "passphrase"
+		ks.KDF = &t.Data.PassphraseParams.KDF
+	}
+
+	return ks, nil
+}
+
+// OfflineVolume is a system volume read directly from its LUKS2 header.
+type OfflineVolume struct {
+	ContainerRole string
+	Device        string
+	Header        *LUKS2Header
+	KeySlots      []KeySlot
+}
+
+// VolumeInfo returns the volume in the shape of the snapd system-volumes API.
+func (v OfflineVolume) VolumeInfo() snapd.VolumeInfo {
+	info := snapd.VolumeInfo{
+		Name:      strings.TrimSuffix(systemVolumeLabels[v.ContainerRole], "-enc"),
+		Encrypted: true,
+		KeySlots:  make(map[string]snapd.KeySlotInfo, len(v.KeySlots)),
+	}
+	for _, ks := range v.KeySlots {
+		info.KeySlots[ks.Name] = ks.KeySlotInfo
+	}
+	return info
+}
+
+// ReadSystemVolumes reads the keyslots of the system volumes from their LUKS2 headers, without snapd.
+// The devices are looked up by label in byLabelDir. Missing volumes are skipped.
+func ReadSystemVolumes(byLabelDir string) ([]OfflineVolume, error) {
+	var volumes []OfflineVolume
+	for _, role := range []string{"system-data", "system-save"} {
+		device := filepath.Join(byLabelDir, systemVolumeLabels[role])
+
+		hdr, err := OpenLUKS2Header(device)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keySlots, err := DecodeKeySlots(hdr.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", device, err)
+		}
+
+		volumes = append(volumes, OfflineVolume{
+			ContainerRole: role,
+			Device:        device,
+			Header:        hdr,
+			KeySlots:      keySlots,
+		})
+	}
+
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("no encrypted system volume found in %s", byLabelDir)
+	}
+
+	return volumes, nil
+}
+
+// SystemVolumes returns the volumes in the shape of the snapd system-volumes API.
+func SystemVolumes(volumes []OfflineVolume) *snapd.SystemVolumesResult {
+	res := &snapd.SystemVolumesResult{
+		ByContainerRole: make(map[string]snapd.VolumeInfo, len(volumes)),
+	}
+	for _, v := range volumes {
+		res.ByContainerRole[v.ContainerRole] = v.VolumeInfo()
+	}
+	return res
+}
@@ -0,0 +1,151 @@
+package fde_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestDecodeKeySlots(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tokens map[string]any
+
+		want    []fde.KeySlot
+		wantErr bool
+	}{
+		"Decodes platform and recovery tokens": {
+			tokens: map[string]any{
+				"0":  testutils.LUKS2PlatformToken("default", "0", "passphrase"),
+				"1":  testutils.LUKS2PlatformToken("default-fallback", "1", "none"),
+				"10": testutils.LUKS2RecoveryToken("additional-recovery", "3"),
+				"2":  testutils.LUKS2RecoveryToken("default-recovery", "2"),
+				"3":  map[string]any{"type": "systemd-tpm2", "keyslots": []string{"4"}},
+			},
+			want: []fde.KeySlot{
+				platformKeySlot("default", "0", "passphrase"),
+				platformKeySlot("default-fallback", "1", ""),
+				recoveryKeySlot("default-recovery", "2"),
+				recoveryKeySlot("additional-recovery", "3"),
+			},
+		},
+		"Leaves auth mode unknown without passphrase params": {
+			tokens: map[string]any{"0": testutils.LUKS2PlatformToken("default", "0", "none")},
+			want:   []fde.KeySlot{platformKeySlot("default", "0", "")},
+		},
+		"No keyslot without ubuntu-fde token": {
+			tokens: map[string]any{},
+		},
+
+		"Error on missing name": {
+			tokens:  map[string]any{"0": testutils.LUKS2RecoveryToken("", "0")},
+			wantErr: true,
+		},
+		"Error on missing key data": {
+			tokens:  map[string]any{"0": map[string]any{"type": "ubuntu-fde", "keyslots": []string{"0"}, "ubuntu_fde_name": "default"}},
+			wantErr: true,
+		},
+		"Error on token attached to several keyslots": {
+			tokens:  map[string]any{"0": map[string]any{"type": "ubuntu-fde-recovery", "keyslots": []string{"0", "1"}, "ubuntu_fde_name": "default-recovery"}},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			md := testutils.DefaultLUKS2Metadata()
+			md["tokens"] = tc.tokens
+			image := testutils.NewLUKS2Image(t, testutils.LUKS2Options{Metadata: md})
+			hdr, err := fde.ReadLUKS2Header(bytes.NewReader(image))
+			be.Err(t, err, nil)
+
+			got, err := fde.DecodeKeySlots(hdr.Metadata)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReadSystemVolumes(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		labels []string
+
+		wantRoles []string
+		wantErr   bool
+	}{
+		"Reads data and save volumes": {labels: []string{"ubuntu-data-enc", "ubuntu-save-enc"}, wantRoles: []string{"system-data", "system-save"}},
+		"Skips missing save volume":   {labels: []string{"ubuntu-data-enc"}, wantRoles: []string{"system-data"}},
+
+		"Error when no volume is found": {wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for _, label := range tc.labels {
+				image := testutils.NewLUKS2Image(t, testutils.LUKS2Options{Label: label})
+				err := os.WriteFile(filepath.Join(dir, label), image, 0600)
+				be.Err(t, err, nil)
+			}
+
+			volumes, err := fde.ReadSystemVolumes(dir)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+
+			var roles []string
+			for _, v := range volumes {
+				roles = append(roles, v.ContainerRole)
+			}
+			be.Equal(t, tc.wantRoles, roles)
+
+			res := fde.SystemVolumes(volumes)
+			data := res.ByContainerRole["system-data"]
+			be.Equal(t, "ubuntu-data", data.Name)
+			be.True(t, data.Encrypted)
+			be.Equal(t, "passphrase", data.KeySlots["default"].AuthMode)
+			be.Equal(t, "recovery", data.KeySlots["default-recovery"].Type)
+		})
+	}
+}
+
+func platformKeySlot(name, keyslot, authMode string) fde.KeySlot {
+	ks := fde.KeySlot{
+		Name:         name,
+		LUKS2Keyslot: keyslot,
+	}
+	ks.Type = "platform"
+	ks.AuthMode = authMode
+	ks.PlatformName = "tpm2"
+	ks.Roles = []string{"run+recover"}
+	if authMode == "passphrase" {
+		ks.KDF = &fde.LUKS2KDF{Type: "argon2id", Time: 4, Memory: 1024, CPUs: 1}
+	}
+	return ks
+}
+
+func recoveryKeySlot(name, keyslot string) fde.KeySlot {
+	ks := fde.KeySlot{Name: name, LUKS2Keyslot: keyslot}
+	ks.Type = "recovery"
+	return ks
+}
@@ -0,0 +1,316 @@
+package fde
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UnmountOptions configures how a volume is unmounted and closed.
+type UnmountOptions struct {
+	// Target is the mount point, the device mapper name or the /dev/mapper path of the volume.
+	Target string
+	// Lazy detaches the mounts immediately and defers closing the mapping until the volume is no longer used.
+	Lazy bool
+}
+
+// UnmountedVolume describes a volume which was unmounted and closed.
+type UnmountedVolume struct {
+	DMName      string
+	MountPoints []string
+	// Deferred is true when closing the mapping was deferred until the volume is no longer used.
+	Deferred bool
+}
+
+// Process is a process using a volume.
+type Process struct {
+	PID  int
+	Name string
+}
+
+// String returns the process as "name (pid)".
+func (p Process) String() string {
+	return fmt.Sprintf("%s (%d)", p.Name, p.PID)
+}
+
+// BusyError is returned when a volume cannot be torn down because it is still in use.
+type BusyError struct {
+	// Resource is the mount point or device mapper name still in use.
+	Resource string
+	// Holders are the devices stacked on top of the volume.
+	Holders []string
+	// Processes are the processes using the resource.
+	Processes []Process
+	Err       error
+}
+
+func (e *BusyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is busy", e.Resource)
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	if len(e.Holders) > 0 {
+		fmt.Fprintf(&b, "\nheld by devices: %s", strings.Join(e.Holders, ", "))
+	}
+	if len(e.Processes) > 0 {
+		procs := make([]string, 0, len(e.Processes))
+		for _, p := range e.Processes {
+			procs = append(procs, p.String())
+		}
+		fmt.Fprintf(&b, "\nused by processes: %s", strings.Join(procs, ", "))
+	}
+	return b.String()
+}
+
+func (e *BusyError) Unwrap() error {
+	return e.Err
+}
+
+// mountInfo is an entry of a mountinfo file.
+type mountInfo struct {
+	MountPoint string
+	Source     string
+}
+
+// UnmountVolume unmounts every mount of a device mapper volume, checks that nothing holds it
+// anymore and closes it with cryptsetup.
+//
+// With the lazy option, the mounts are detached even when busy and closing is deferred by
+// the kernel until the last user is gone.
+func UnmountVolume(ctx context.Context, executor Executor, paths SystemPaths, opts UnmountOptions) (*UnmountedVolume, error) {
+	if opts.Target == "" {
+		return nil, errors.New("missing mount point or device mapper name")
+	}
+
+	mounts, err := readMountInfo(paths.MountInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	dmName, err := resolveDMName(paths, mounts, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	dmDev, err := findDMDevice(paths.SysBlock, dmName)
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &UnmountedVolume{DMName: dmName}
+	sources := []string{filepath.Join("/dev/mapper", dmName), filepath.Join("/dev", dmDev)}
+	for _, m := range mounts {
+		if slices.Contains(sources, m.Source) {
+			vol.MountPoints = append(vol.MountPoints, m.MountPoint)
+		}
+	}
+
+	// Unmount the most recent mounts first, as they may be stacked on top of older ones.
+	for _, mp := range slices.Backward(vol.MountPoints) {
+		args := []string{mp}
+		if opts.Lazy {
+			args = []string{"--lazy", mp}
+		}
+		if err := executor.Run(ctx, nil, "umount", args...); err != nil {
+			return nil, &BusyError{
+				Resource:  mp,
+				Processes: findProcesses(paths.Proc, mp, nil),
+				Err:       err,
+			}
+		}
+	}
+
+	holders, err := readHolders(paths.SysBlock, dmDev)
+	if err != nil {
+		return nil, err
+	}
+	if len(holders) > 0 {
+		return nil, &BusyError{Resource: dmName, Holders: holders}
+	}
+
+	args := []string{"close", dmName}
+	if opts.Lazy {
+		args = []string{"close", "--deferred", dmName}
+		vol.Deferred = true
+	}
+	if err := executor.Run(ctx, nil, "cryptsetup", args...); err != nil {
+		return nil, &BusyError{
+			Resource:  dmName,
+			Processes: findProcesses(paths.Proc, "", sources),
+			Err:       err,
+		}
+	}
+
+	return vol, nil
+}
+
+// resolveDMName returns the device mapper name of the target, which is either a mount
+// point, a device mapper name or a /dev/mapper path.
+func resolveDMName(paths SystemPaths, mounts []mountInfo, target string) (string, error) {
+	if name, ok := strings.CutPrefix(target, "/dev/mapper/"); ok {
+		return name, nil
+	}
+	if !strings.Contains(target, "/") {
+		return target, nil
+	}
+
+	mp := filepath.Clean(target)
+	var source string
+	for _, m := range mounts {
+		if m.MountPoint == mp {
+			source = m.Source
+		}
+	}
+	if source == "" {
+		return "", fmt.Errorf("%s is not a mount point", mp)
+	}
+
+	if name, ok := strings.CutPrefix(source, "/dev/mapper/"); ok {
+		return name, nil
+	}
+	if dev, ok := strings.CutPrefix(source, "/dev/dm-"); ok {
+		name, err := os.ReadFile(filepath.Join(paths.SysBlock, "dm-"+dev, "dm", "name"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read device mapper name of %s: %w", source, err)
+		}
+		return strings.TrimSpace(string(name)), nil
+	}
+
+	return "", fmt.Errorf("%s is mounted from %s, which is not a device mapper volume", mp, source)
+}
+
+// findDMDevice returns the kernel name, e.g. dm-0, of the device mapper volume with the given name.
+func findDMDevice(sysBlock, dmName string) (string, error) {
+	entries, err := filepath.Glob(filepath.Join(sysBlock, "dm-*"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range entries {
+		name, err := os.ReadFile(filepath.Join(entry, "dm", "name"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(name)) == dmName {
+			return filepath.Base(entry), nil
+		}
+	}
+
+	return "", fmt.Errorf("no device mapper volume named %q", dmName)
+}
+
+// readHolders returns the devices stacked on top of a block device.
+func readHolders(sysBlock, dev string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(sysBlock, dev, "holders"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read holders of %s: %w", dev, err)
+	}
+
+	holders := make([]string, 0, len(entries))
+	for _, e := range entries {
+		holders = append(holders, e.Name())
+	}
+	return holders, nil
+}
+
+// readMountInfo returns the mount point and source of each entry of a mountinfo file.
+func readMountInfo(path string) ([]mountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount information: %w", err)
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mount-point options [optional fields...] - fstype source super-options
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			return nil, fmt.Errorf("invalid mount information line %q", scanner.Text())
+		}
+
+		mounts = append(mounts, mountInfo{
+			MountPoint: unescapeMountInfo(fields[4]),
+			Source:     unescapeMountInfo(fields[sep+2]),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount information: %w", err)
+	}
+
+	return mounts, nil
+}
+
+// unescapeMountInfo decodes the octal escapes, e.g. \040 for a space, of a mountinfo field.
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// findProcesses returns the processes whose working directory, root or open files are
+// under the mount point, or are one of the devices.
+func findProcesses(proc, mountPoint string, devices []string) []Process {
+	uses := func(path string) bool {
+		if slices.Contains(devices, path) {
+			return true
+		}
+		return mountPoint != "" && (path == mountPoint || strings.HasPrefix(path, strings.TrimSuffix(mountPoint, "/")+"/"))
+	}
+
+	entries, err := os.ReadDir(proc)
+	if err != nil {
+		return nil
+	}
+
+	var procs []Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(proc, e.Name())
+
+		links := []string{filepath.Join(dir, "cwd"), filepath.Join(dir, "root")}
+		fds, _ := filepath.Glob(filepath.Join(dir, "fd", "*"))
+		links = append(links, fds...)
+
+		if !slices.ContainsFunc(links, func(link string) bool {
+			target, err := os.Readlink(link)
+			return err == nil && uses(target)
+		}) {
+			continue
+		}
+
+		comm, _ := os.ReadFile(filepath.Join(dir, "comm"))
+		procs = append(procs, Process{PID: pid, Name: strings.TrimSpace(string(comm))})
+	}
+
+	slices.SortFunc(procs, func(a, b Process) int { return a.PID - b.PID })
+
+	return procs
+}
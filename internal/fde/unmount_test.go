@@ -0,0 +1,164 @@
+package fde_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+const testMountInfo = `22 1 0:21 / /proc rw,nosuid shared:12 - proc proc rw
+29 1 253:1 / / rw,relatime shared:1 - ext4 /dev/mapper/root rw
+45 29 253:0 / /mnt/rescue\040data rw,relatime shared:30 - ext4 /dev/mapper/rescue rw
+46 29 253:0 /home /srv/home rw,relatime shared:31 - ext4 /dev/dm-0 rw
+47 29 8:1 / /boot rw,relatime shared:32 - ext4 /dev/sda1 rw
+`
+
+func TestUnmountVolume(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		opts    fde.UnmountOptions
+		failOn  []string
+		holders []string
+
+		wantCommands    []string
+		wantMountPoints []string
+		wantProcesses   []fde.Process
+		wantHolders     []string
+		wantErr         bool
+	}{
+		"Unmounts and closes by mount point": {
+			opts: fde.UnmountOptions{Target: "/mnt/rescue data/"},
+			wantCommands: []string{
+				"umount /srv/home",
+				"umount /mnt/rescue data",
+				"cryptsetup close rescue",
+			},
+			wantMountPoints: []string{"/mnt/rescue data", "/srv/home"},
+		},
+		"Unmounts and closes by dm name": {
+			opts:            fde.UnmountOptions{Target: "rescue"},
+			wantCommands:    []string{"umount /srv/home", "umount /mnt/rescue data", "cryptsetup close rescue"},
+			wantMountPoints: []string{"/mnt/rescue data", "/srv/home"},
+		},
+		"Unmounts lazily and defers close": {
+			opts: fde.UnmountOptions{Target: "/dev/mapper/rescue", Lazy: true},
+			wantCommands: []string{
+				"umount --lazy /srv/home",
+				"umount --lazy /mnt/rescue data",
+				"cryptsetup close --deferred rescue",
+			},
+			wantMountPoints: []string{"/mnt/rescue data", "/srv/home"},
+		},
+		"Closes unmounted volume": {
+			opts:         fde.UnmountOptions{Target: "idle"},
+			wantCommands: []string{"cryptsetup close idle"},
+		},
+
+		"Error listing processes when unmount fails": {
+			opts:          fde.UnmountOptions{Target: "rescue"},
+			failOn:        []string{"umount /mnt/rescue data"},
+			wantCommands:  []string{"umount /srv/home", "umount /mnt/rescue data"},
+			wantProcesses: []fde.Process{{PID: 42, Name: "bash"}},
+			wantErr:       true,
+		},
+		"Error listing processes when close fails": {
+			opts:          fde.UnmountOptions{Target: "rescue"},
+			failOn:        []string{"cryptsetup close"},
+			wantCommands:  []string{"umount /srv/home", "umount /mnt/rescue data", "cryptsetup close rescue"},
+			wantProcesses: []fde.Process{{PID: 1234, Name: "dd"}},
+			wantErr:       true,
+		},
+		"Error when volume is held by another device": {
+			opts:         fde.UnmountOptions{Target: "rescue"},
+			holders:      []string{"dm-5"},
+			wantCommands: []string{"umount /srv/home", "umount /mnt/rescue data"},
+			wantHolders:  []string{"dm-5"},
+			wantErr:      true,
+		},
+		"Error when mount point is not a dm volume": {
+			opts:    fde.UnmountOptions{Target: "/boot"},
+			wantErr: true,
+		},
+		"Error when path is not a mount point": {
+			opts:    fde.UnmountOptions{Target: "/mnt"},
+			wantErr: true,
+		},
+		"Error on unknown dm name": {
+			opts:    fde.UnmountOptions{Target: "other"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			paths := newFakeSystem(t, tc.holders)
+			executor := &testutils.FakeExecutor{FailOn: tc.failOn}
+
+			vol, err := fde.UnmountVolume(context.Background(), executor, paths, tc.opts)
+
+			be.Equal(t, tc.wantCommands, executor.Commands())
+			if tc.wantErr {
+				be.Err(t, err)
+
+				var busy *fde.BusyError
+				if errors.As(err, &busy) {
+					be.Equal(t, tc.wantProcesses, busy.Processes)
+					be.Equal(t, tc.wantHolders, busy.Holders)
+				}
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.wantMountPoints, vol.MountPoints)
+			be.Equal(t, tc.opts.Lazy, vol.Deferred)
+		})
+	}
+}
+
+// newFakeSystem creates the mountinfo, sysfs and procfs of a system where the rescue volume
+// is mounted twice and used by two processes, and the idle volume is not mounted.
+func newFakeSystem(t *testing.T, holders []string) fde.SystemPaths {
+	t.Helper()
+
+	root := t.TempDir()
+	paths := fde.SystemPaths{
+		MountInfo: filepath.Join(root, "mountinfo"),
+		SysBlock:  filepath.Join(root, "sys", "block"),
+		Proc:      filepath.Join(root, "proc"),
+	}
+	be.Err(t, os.WriteFile(paths.MountInfo, []byte(testMountInfo), 0600), nil)
+
+	for dev, name := range map[string]string{"dm-0": "rescue", "dm-1": "root", "dm-2": "idle"} {
+		dir := filepath.Join(paths.SysBlock, dev)
+		be.Err(t, os.MkdirAll(filepath.Join(dir, "dm"), 0700), nil)
+		be.Err(t, os.MkdirAll(filepath.Join(dir, "holders"), 0700), nil)
+		be.Err(t, os.WriteFile(filepath.Join(dir, "dm", "name"), []byte(name+"\n"), 0600), nil)
+	}
+	for _, h := range holders {
+		be.Err(t, os.Symlink("../../"+h, filepath.Join(paths.SysBlock, "dm-0", "holders", h)), nil)
+	}
+
+	for pid, p := range map[string]struct{ comm, cwd, fd string }{
+		"1":    {comm: "init", cwd: "/", fd: "/dev/null"},
+		"42":   {comm: "bash", cwd: "/mnt/rescue data/etc", fd: "/dev/pts/0"},
+		"1234": {comm: "dd", cwd: "/root", fd: "/dev/dm-0"},
+	} {
+		dir := filepath.Join(paths.Proc, pid)
+		be.Err(t, os.MkdirAll(filepath.Join(dir, "fd"), 0700), nil)
+		be.Err(t, os.WriteFile(filepath.Join(dir, "comm"), []byte(p.comm+"\n"), 0600), nil)
+		be.Err(t, os.Symlink(p.cwd, filepath.Join(dir, "cwd")), nil)
+		be.Err(t, os.Symlink("/", filepath.Join(dir, "root")), nil)
+		be.Err(t, os.Symlink(p.fd, filepath.Join(dir, "fd", "3")), nil)
+	}
+	be.Err(t, os.WriteFile(filepath.Join(paths.Proc, "self"), nil, 0600), nil)
+
+	return paths
+}
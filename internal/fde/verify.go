@@ -0,0 +1,213 @@
+package fde
+
+import (
+	"crypto/aes"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/xts"
+)
+
+// luks2SectorSize is the sector size of the keyslot areas encryption.
+const luks2SectorSize = 512
+
+// ErrKeyMismatch is returned when a key does not unlock any keyslot of a device.
+var ErrKeyMismatch = errors.New("key does not unlock any keyslot")
+
+// VerifyKey checks, without snapd nor cryptsetup, that the recovery key unlocks a keyslot
+// of the LUKS2 device or image at path. It returns the ID of the matching keyslot.
+func VerifyKey(path string, key RecoveryKey) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hdr, err := ReadLUKS2Header(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	return VerifyPassphrase(f, hdr, key.LUKSPassphrase())
+}
+
+// VerifyPassphrase checks that the passphrase unlocks a keyslot of the device described by hdr.
+// It returns the ID of the matching keyslot, or ErrKeyMismatch.
+//
+// Each keyslot is checked by deriving its key with its KDF, decrypting its area, merging
+// the anti-forensic stripes into a volume key and comparing that key against the digests.
+func VerifyPassphrase(r io.ReaderAt, hdr *LUKS2Header, passphrase []byte) (string, error) {
+	var errs []error
+	for _, id := range hdr.Metadata.KeyslotIDs() {
+		volumeKey, err := openKeyslot(r, hdr.Metadata.Keyslots[id], passphrase)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keyslot %s: %w", id, err))
+			continue
+		}
+
+		ok, err := checkDigests(hdr.Metadata, id, volumeKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keyslot %s: %w", id, err))
+			continue
+		}
+		if ok {
+			return id, nil
+		}
+	}
+
+	// Unsupported keyslots are only worth reporting when the key could not be verified at all.
+	if err := errors.Join(errs...); err != nil && len(errs) == len(hdr.Metadata.Keyslots) {
+		return "", fmt.Errorf("%w: %w", ErrKeyMismatch, err)
+	}
+
+	return "", ErrKeyMismatch
+}
+
+// openKeyslot returns the volume key candidate stored in a keyslot for the passphrase.
+func openKeyslot(r io.ReaderAt, ks LUKS2Keyslot, passphrase []byte) ([]byte, error) {
+	if ks.Type != "luks2" {
+		return nil, fmt.Errorf("unsupported keyslot type %q", ks.Type)
+	}
+	if ks.Area.Type != "raw" {
+		return nil, fmt.Errorf("unsupported keyslot area type %q", ks.Area.Type)
+	}
+	if ks.Area.Encryption != "aes-xts-plain64" {
+		return nil, fmt.Errorf("unsupported keyslot encryption %q", ks.Area.Encryption)
+	}
+	if ks.AF.Type != "luks1" || ks.AF.Stripes <= 0 || ks.KeySize <= 0 {
+		return nil, fmt.Errorf("unsupported anti-forensic splitter %q with %d stripes", ks.AF.Type, ks.AF.Stripes)
+	}
+
+	areaKey, err := deriveKey(ks.KDF, passphrase, ks.Area.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	splitSize := ks.KeySize * ks.AF.Stripes
+	size := (splitSize + luks2SectorSize - 1) / luks2SectorSize * luks2SectorSize
+	if uint64(size) > uint64(ks.Area.Size) {
+		return nil, fmt.Errorf("keyslot area of %d bytes is too small for %d bytes of key material", ks.Area.Size, size)
+	}
+
+	area := make([]byte, size)
+	if _, err := r.ReadAt(area, int64(ks.Area.Offset)); err != nil {
+		return nil, fmt.Errorf("failed to read keyslot area: %w", err)
+	}
+
+	c, err := xts.NewCipher(aes.NewCipher, areaKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyslot area key: %w", err)
+	}
+	for sector := range size / luks2SectorSize {
+		block := area[sector*luks2SectorSize : (sector+1)*luks2SectorSize]
+		c.Decrypt(block, block, uint64(sector))
+	}
+
+	newHash, err := hashFunc(ks.AF.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return afMerge(area[:splitSize], ks.KeySize, ks.AF.Stripes, newHash), nil
+}
+
+// deriveKey derives a key of the given size from the passphrase with the keyslot KDF.
+func deriveKey(kdf LUKS2KDF, passphrase []byte, size int) ([]byte, error) {
+	switch kdf.Type {
+	case "pbkdf2":
+		newHash, err := hashFunc(kdf.Hash)
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key(newHash, string(passphrase), kdf.Salt, kdf.Iterations, size)
+	case "argon2i":
+		return argon2.Key(passphrase, kdf.Salt, kdf.Time, kdf.Memory, kdf.CPUs, uint32(size)), nil
+	case "argon2id":
+		return argon2.IDKey(passphrase, kdf.Salt, kdf.Time, kdf.Memory, kdf.CPUs, uint32(size)), nil
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", kdf.Type)
+	}
+}
+
+// checkDigests returns true if a digest of the keyslot matches the volume key.
+func checkDigests(md LUKS2Metadata, keyslot string, volumeKey []byte) (bool, error) {
+	for _, id := range sortedIDs(md.Digests) {
+		d := md.Digests[id]
+		if !slices.Contains(d.Keyslots, keyslot) {
+			continue
+		}
+		if d.Type != "pbkdf2" {
+			return false, fmt.Errorf("unsupported digest type %q", d.Type)
+		}
+
+		newHash, err := hashFunc(d.Hash)
+		if err != nil {
+			return false, err
+		}
+		sum, err := pbkdf2.Key(newHash, string(volumeKey), d.Salt, d.Iterations, len(d.Digest))
+		if err != nil {
+			return false, fmt.Errorf("failed to compute digest: %w", err)
+		}
+		if subtle.ConstantTimeCompare(sum, d.Digest) == 1 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// afMerge recovers the key split by the LUKS anti-forensic splitter into stripes.
+func afMerge(split []byte, keySize, stripes int, newHash func() hash.Hash) []byte {
+	buf := make([]byte, keySize)
+	for i := range stripes - 1 {
+		subtle.XORBytes(buf, buf, split[i*keySize:(i+1)*keySize])
+		afDiffuse(buf, newHash)
+	}
+
+	key := make([]byte, keySize)
+	subtle.XORBytes(key, buf, split[(stripes-1)*keySize:])
+
+	return key
+}
+
+// afDiffuse hashes each digest sized block of buf, prefixed by its big-endian index, in place.
+func afDiffuse(buf []byte, newHash func() hash.Hash) {
+	h := newHash()
+	size := h.Size()
+
+	var iv [4]byte
+	for i := 0; i*size < len(buf); i++ {
+		block := buf[i*size : min((i+1)*size, len(buf))]
+
+		h.Reset()
+		binary.BigEndian.PutUint32(iv[:], uint32(i))
+		h.Write(iv[:])
+		h.Write(block)
+		copy(block, h.Sum(nil))
+	}
+}
+
+// hashFunc returns the hash named as in the LUKS2 metadata.
+func hashFunc(name string) (func() hash.Hash, error) {
+	switch name {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash %q", name)
+	}
+}
@@ -0,0 +1,183 @@
+package fde_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestVerifyKey(t *testing.T) {
+	t.Parallel()
+
+	// The recovery key used as LUKS passphrase is its raw 16 bytes.
+	recoveryKey, err := fde.ParseRecoveryKey("00256-00770-01284-01798-02312-02826-03340-03854")
+	be.Err(t, err, nil)
+	otherKey, err := fde.ParseRecoveryKey("11111-22222-33333-44444-55555-11111-22222-33333")
+	be.Err(t, err, nil)
+
+	tests := map[string]struct {
+		keyslots []testutils.LUKS2KeyslotOptions
+		key      fde.RecoveryKey
+		noImage  bool
+
+		wantKeyslot  string
+		wantMismatch bool
+		wantErr      bool
+	}{
+		"Matches pbkdf2 keyslot": {
+			keyslots:    []testutils.LUKS2KeyslotOptions{{Passphrase: recoveryKey.LUKSPassphrase(), KDF: "pbkdf2"}},
+			key:         recoveryKey,
+			wantKeyslot: "0",
+		},
+		"Matches argon2i keyslot": {
+			keyslots:    []testutils.LUKS2KeyslotOptions{{Passphrase: recoveryKey.LUKSPassphrase(), KDF: "argon2i"}},
+			key:         recoveryKey,
+			wantKeyslot: "0",
+		},
+		"Matches argon2id keyslot after other keyslots": {
+			keyslots: []testutils.LUKS2KeyslotOptions{
+				{Passphrase: []byte("platform key"), KDF: "argon2id"},
+				{Passphrase: otherKey.LUKSPassphrase(), KDF: "pbkdf2"},
+				{Passphrase: recoveryKey.LUKSPassphrase(), KDF: "argon2id"},
+			},
+			key:         recoveryKey,
+			wantKeyslot: "2",
+		},
+
+		"Error when key unlocks no keyslot": {
+			keyslots: []testutils.LUKS2KeyslotOptions{
+				{Passphrase: []byte("platform key"), KDF: "argon2id"},
+				{Passphrase: otherKey.LUKSPassphrase(), KDF: "pbkdf2"},
+			},
+			key:          recoveryKey,
+			wantMismatch: true,
+			wantErr:      true,
+		},
+		"Error when device does not exist": {
+			noImage: true,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "disk.img")
+			if !tc.noImage {
+				image := testutils.NewLUKS2ImageWithKeyslots(t, testutils.LUKS2Options{}, tc.keyslots...)
+				be.Err(t, os.WriteFile(path, image, 0600), nil)
+			}
+
+			got, err := fde.VerifyKey(path, tc.key)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				be.Equal(t, tc.wantMismatch, errors.Is(err, fde.ErrKeyMismatch))
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.wantKeyslot, got)
+		})
+	}
+}
+
+func TestVerifyPassphraseUnsupportedKeyslot(t *testing.T) {
+	t.Parallel()
+
+	image := testutils.NewLUKS2ImageWithKeyslots(t, testutils.LUKS2Options{},
+		testutils.LUKS2KeyslotOptions{Passphrase: []byte("secret"), KDF: "pbkdf2"})
+	hdr, err := fde.ReadLUKS2Header(bytes.NewReader(image))
+	be.Err(t, err, nil)
+
+	ks := hdr.Metadata.Keyslots["0"]
+	ks.Area.Encryption = "serpent-xts-plain64"
+	hdr.Metadata.Keyslots["0"] = ks
+
+	_, err = fde.VerifyPassphrase(bytes.NewReader(image), hdr, []byte("secret"))
+	be.Err(t, err, fde.ErrKeyMismatch)
+	be.Err(t, err, "unsupported keyslot encryption")
+}
+
+// cryptsetupImage is the header and keyslot areas of a LUKS2 image formatted by libcryptsetup,
+// not by the code under test, see testdata/README.md.
+const cryptsetupImage = "testdata/cryptsetup-luks2.img"
+
+func TestVerifyKeyCryptsetupImage(t *testing.T) {
+	t.Parallel()
+
+	recoveryKey, err := fde.ParseRecoveryKey("00256-00770-01284-01798-02312-02826-03340-03854")
+	be.Err(t, err, nil)
+	otherKey, err := fde.ParseRecoveryKey("11111-22222-33333-44444-55555-11111-22222-33333")
+	be.Err(t, err, nil)
+
+	tests := map[string]struct {
+		key fde.RecoveryKey
+
+		wantKeyslot string
+		wantErr     bool
+	}{
+		"Matches pbkdf2 keyslot": {key: recoveryKey, wantKeyslot: "0"},
+
+		"Error when key unlocks no keyslot": {key: otherKey, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := fde.VerifyKey(cryptsetupImage, tc.key)
+			if tc.wantErr {
+				be.Err(t, err, fde.ErrKeyMismatch)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, tc.wantKeyslot)
+		})
+	}
+}
+
+func TestVerifyPassphraseCryptsetupImage(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		passphrase []byte
+
+		wantKeyslot string
+		wantErr     bool
+	}{
+		"Matches pbkdf2 keyslot":  {passphrase: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, wantKeyslot: "0"},
+		"Matches argon2i keyslot": {passphrase: []byte("argon2i passphrase"), wantKeyslot: "1"},
+
+		"Error when passphrase unlocks no keyslot": {passphrase: []byte("argon2i passphrase!"), wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := os.Open(cryptsetupImage)
+			be.Err(t, err, nil)
+			defer f.Close()
+
+			hdr, err := fde.ReadLUKS2Header(f)
+			be.Err(t, err, nil)
+			be.Equal(t, hdr.Metadata.Keyslots["0"].KDF.Type, "pbkdf2")
+			be.Equal(t, hdr.Metadata.Keyslots["1"].KDF.Type, "argon2i")
+
+			got, err := fde.VerifyPassphrase(f, hdr, tc.passphrase)
+			if tc.wantErr {
+				be.Err(t, err, fde.ErrKeyMismatch)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, tc.wantKeyslot)
+		})
+	}
+}
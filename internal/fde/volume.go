@@ -0,0 +1,177 @@
+package fde
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dmNamePrefix prefixes the device mapper names derived from the unlocked devices.
+const dmNamePrefix = "snap-tpmctl-"
+
+// Executor runs the external commands used to unlock and mount volumes.
+type Executor interface {
+	// Run runs the command with stdin as its input.
+	Run(ctx context.Context, stdin []byte, name string, args ...string) error
+}
+
+// CommandExecutor runs commands on the system.
+type CommandExecutor struct{}
+
+// Run runs the command, returning its output in the error on failure.
+func (CommandExecutor) Run(ctx context.Context, stdin []byte, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s failed: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return nil
+}
+
+// SystemPaths are the kernel interfaces inspected to discover volumes and safely tear them down.
+type SystemPaths struct {
+	// MountInfo is the mountinfo file of the current mount namespace.
+	MountInfo string
+	// SysBlock is the sysfs directory of block devices.
+	SysBlock string
+	// Proc is the procfs root, used to find the processes using a volume.
+	Proc string
+	// Dev is the directory of the block device nodes.
+	Dev string
+	// ByUUID is the directory linking block devices by filesystem UUID.
+	ByUUID string
+}
+
+// DefaultSystemPaths are the kernel interfaces of the running system.
+var DefaultSystemPaths = SystemPaths{
+	MountInfo: "/proc/self/mountinfo",
+	SysBlock:  "/sys/block",
+	Proc:      "/proc",
+	Dev:       "/dev",
+	ByUUID:    "/dev/disk/by-uuid",
+}
+
+// VolumeSelector identifies an encrypted volume. Exactly one field must be set.
+type VolumeSelector struct {
+	// ContainerRole is the role of a system volume, e.g. system-data.
+	ContainerRole string
+	// Label is the label of the encrypted partition, e.g. ubuntu-data-enc.
+	Label string
+	// Device is the path of the encrypted block device.
+	Device string
+}
+
+// ResolveDevice returns the path of the block device selected by s.
+// Labels and container roles are looked up in byLabelDir.
+func (s VolumeSelector) ResolveDevice(byLabelDir string) (string, error) {
+	var set int
+	for _, v := range []string{s.ContainerRole, s.Label, s.Device} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", errors.New("exactly one of container role, label or device must be given")
+	}
+
+	device := s.Device
+	switch {
+	case s.ContainerRole != "":
+		label, ok := systemVolumeLabels[s.ContainerRole]
+		if !ok {
+			return "", fmt.Errorf("unknown container role %q", s.ContainerRole)
+		}
+		device = filepath.Join(byLabelDir, label)
+	case s.Label != "":
+		if strings.Contains(s.Label, "/") {
+			return "", fmt.Errorf("invalid label %q", s.Label)
+		}
+		device = filepath.Join(byLabelDir, s.Label)
+	}
+
+	if _, err := os.Stat(device); err != nil {
+		return "", fmt.Errorf("encrypted volume not found: %w", err)
+	}
+
+	return device, nil
+}
+
+// MountOptions configures how a volume is unlocked and mounted.
+type MountOptions struct {
+	// Device is the encrypted block device to unlock.
+	Device     string
+	MountPoint string
+
+	// DMName is the device mapper name of the unlocked volume. Defaults to a name derived from the device.
+	DMName   string
+	ReadOnly bool
+}
+
+// MountedVolume describes an unlocked and mounted volume.
+type MountedVolume struct {
+	Device     string
+	DMName     string
+	MapperPath string
+	MountPoint string
+}
+
+// MountVolume unlocks the device with the recovery key through cryptsetup and mounts it.
+// If mounting fails, the mapping opened for it is closed again.
+func MountVolume(ctx context.Context, executor Executor, opts MountOptions, key RecoveryKey) (*MountedVolume, error) {
+	if opts.Device == "" {
+		return nil, errors.New("missing device")
+	}
+	if opts.MountPoint == "" {
+		return nil, errors.New("missing mount point")
+	}
+
+	dmName := opts.DMName
+	if dmName == "" {
+		dmName = dmNamePrefix + filepath.Base(opts.Device)
+	}
+	if strings.ContainsAny(dmName, "/ ") {
+		return nil, fmt.Errorf("invalid device mapper name %q", dmName)
+	}
+
+	vol := &MountedVolume{
+		Device:     opts.Device,
+		DMName:     dmName,
+		MapperPath: filepath.Join("/dev/mapper", dmName),
+		MountPoint: opts.MountPoint,
+	}
+
+	openArgs := []string{"open", "--type", "luks2", "--key-file", "-"}
+	mountArgs := []string{}
+	if opts.ReadOnly {
+		openArgs = append(openArgs, "--readonly")
+		mountArgs = append(mountArgs, "-o", "ro")
+	}
+	openArgs = append(openArgs, vol.Device, vol.DMName)
+	mountArgs = append(mountArgs, vol.MapperPath, vol.MountPoint)
+
+	// A failed open does not leave a mapping behind, and an existing one is not ours to close.
+	if err := executor.Run(ctx, key.LUKSPassphrase(), "cryptsetup", openArgs...); err != nil {
+		return nil, fmt.Errorf("failed to unlock %s: %w", vol.Device, err)
+	}
+
+	if err := executor.Run(ctx, nil, "mount", mountArgs...); err != nil {
+		err = fmt.Errorf("failed to mount %s on %s: %w", vol.MapperPath, vol.MountPoint, err)
+		if cerr := executor.Run(context.WithoutCancel(ctx), nil, "cryptsetup", "close", vol.DMName); cerr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to close %s: %w", vol.DMName, cerr))
+		}
+		return nil, err
+	}
+
+	return vol, nil
+}
@@ -0,0 +1,145 @@
+package fde_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/testutils"
+)
+
+func TestVolumeSelectorResolveDevice(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		selector fde.VolumeSelector
+
+		want    string
+		wantErr bool
+	}{
+		"Resolves container role": {selector: fde.VolumeSelector{ContainerRole: "system-save"}, want: "ubuntu-save-enc"},
+		"Resolves label":          {selector: fde.VolumeSelector{Label: "ubuntu-data-enc"}, want: "ubuntu-data-enc"},
+		"Resolves device":         {selector: fde.VolumeSelector{Device: "DIR/ubuntu-data-enc"}, want: "ubuntu-data-enc"},
+
+		"Error on unknown container role": {selector: fde.VolumeSelector{ContainerRole: "system-boot"}, wantErr: true},
+		"Error on missing volume":         {selector: fde.VolumeSelector{Label: "other-enc"}, wantErr: true},
+		"Error on label with slash":       {selector: fde.VolumeSelector{Label: "../ubuntu-data-enc"}, wantErr: true},
+		"Error when nothing is selected":  {wantErr: true},
+		"Error when several are selected": {
+			selector: fde.VolumeSelector{ContainerRole: "system-data", Label: "ubuntu-data-enc"},
+			wantErr:  true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for _, label := range []string{"ubuntu-data-enc", "ubuntu-save-enc"} {
+				be.Err(t, os.WriteFile(filepath.Join(dir, label), nil, 0600), nil)
+			}
+			if tc.selector.Device != "" {
+				tc.selector.Device = filepath.Join(dir, filepath.Base(tc.selector.Device))
+			}
+
+			got, err := tc.selector.ResolveDevice(dir)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, filepath.Join(dir, tc.want), got)
+		})
+	}
+}
+
+func TestMountVolume(t *testing.T) {
+	t.Parallel()
+
+	key, err := fde.ParseRecoveryKey("00256-00770-01284-01798-02312-02826-03340-03854")
+	be.Err(t, err, nil)
+
+	tests := map[string]struct {
+		opts   fde.MountOptions
+		failOn []string
+
+		wantCommands []string
+		wantErr      bool
+	}{
+		"Unlocks and mounts": {
+			opts: fde.MountOptions{Device: "/dev/disk/by-label/ubuntu-data-enc", MountPoint: "/mnt"},
+			wantCommands: []string{
+				"cryptsetup open --type luks2 --key-file - /dev/disk/by-label/ubuntu-data-enc snap-tpmctl-ubuntu-data-enc",
+				"mount /dev/mapper/snap-tpmctl-ubuntu-data-enc /mnt",
+			},
+		},
+		"Unlocks and mounts read-only with dm name": {
+			opts: fde.MountOptions{Device: "/dev/sda4", MountPoint: "/mnt", DMName: "rescue", ReadOnly: true},
+			wantCommands: []string{
+				"cryptsetup open --type luks2 --key-file - --readonly /dev/sda4 rescue",
+				"mount -o ro /dev/mapper/rescue /mnt",
+			},
+		},
+
+		"Error when unlock fails": {
+			opts:         fde.MountOptions{Device: "/dev/sda4", MountPoint: "/mnt"},
+			failOn:       []string{"cryptsetup open"},
+			wantCommands: []string{"cryptsetup open --type luks2 --key-file - /dev/sda4 snap-tpmctl-sda4"},
+			wantErr:      true,
+		},
+		"Error and close mapping when mount fails": {
+			opts:   fde.MountOptions{Device: "/dev/sda4", MountPoint: "/mnt"},
+			failOn: []string{"mount"},
+			wantCommands: []string{
+				"cryptsetup open --type luks2 --key-file - /dev/sda4 snap-tpmctl-sda4",
+				"mount /dev/mapper/snap-tpmctl-sda4 /mnt",
+				"cryptsetup close snap-tpmctl-sda4",
+			},
+			wantErr: true,
+		},
+		"Error when mount and close fail": {
+			opts:   fde.MountOptions{Device: "/dev/sda4", MountPoint: "/mnt"},
+			failOn: []string{"mount", "cryptsetup close"},
+			wantCommands: []string{
+				"cryptsetup open --type luks2 --key-file - /dev/sda4 snap-tpmctl-sda4",
+				"mount /dev/mapper/snap-tpmctl-sda4 /mnt",
+				"cryptsetup close snap-tpmctl-sda4",
+			},
+			wantErr: true,
+		},
+		"Error on invalid dm name": {
+			opts:    fde.MountOptions{Device: "/dev/sda4", MountPoint: "/mnt", DMName: "../control"},
+			wantErr: true,
+		},
+		"Error on missing mount point": {
+			opts:    fde.MountOptions{Device: "/dev/sda4"},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			executor := &testutils.FakeExecutor{FailOn: tc.failOn}
+
+			vol, err := fde.MountVolume(context.Background(), executor, tc.opts, key)
+
+			be.Equal(t, tc.wantCommands, executor.Commands())
+			if len(tc.wantCommands) > 0 {
+				be.Equal(t, key.LUKSPassphrase(), executor.Stdin(0))
+			}
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.opts.MountPoint, vol.MountPoint)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package fileutils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fileutils"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		existing string
+
+		wantErr bool
+	}{
+		"Creates file and its directory": {},
+		"Replaces existing file":         {existing: "previous"},
+
+		"Error when the path is a directory": {existing: "dir", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := filepath.Join(t.TempDir(), "state")
+			path := filepath.Join(dir, "file.json")
+			switch tc.existing {
+			case "":
+			case "dir":
+				be.Err(t, os.MkdirAll(filepath.Join(path, "child"), 0700), nil)
+			default:
+				be.Err(t, os.MkdirAll(dir, 0700), nil)
+				be.Err(t, os.WriteFile(path, []byte(tc.existing), 0600), nil)
+			}
+
+			err := fileutils.WriteFileAtomic(path, []byte("content"))
+
+			entries, rErr := os.ReadDir(dir)
+			be.Err(t, rErr, nil)
+			be.Equal(t, 1, len(entries))
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+
+			got, err := os.ReadFile(path)
+			be.Err(t, err, nil)
+			be.Equal(t, "content", string(got))
+
+			fi, err := os.Stat(path)
+			be.Err(t, err, nil)
+			be.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+			fi, err = os.Stat(dir)
+			be.Err(t, err, nil)
+			if tc.existing == "" {
+				be.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+			}
+		})
+	}
+}
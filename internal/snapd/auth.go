@@ -3,6 +3,7 @@ package snapd
 import (
 	"context"
 	"net/http"
+	"time"
 )
 
 // PassphraseRequest represents a request to manage passphrases in snapd.
@@ -109,6 +110,14 @@ const (
 	KDFTypePBKDF2   KDFType = "pbkdf2"
 )
 
+// KDFOptions tunes the key derivation function protecting the platform key with a PIN or passphrase.
+// Zero values let snapd pick its defaults.
+type KDFOptions struct {
+	Type KDFType
+	// Time is the target duration of the key derivation.
+	Time time.Duration
+}
+
 // PlatformKeyRequest represents the request body for replacing a platform key.
 type PlatformKeyRequest struct {
 	Action     string    `json:"action"`
@@ -121,12 +130,19 @@ type PlatformKeyRequest struct {
 }
 
 // ReplacePlatformKey replaces the platform key with the specified authentication.
-func (c *Client) ReplacePlatformKey(ctx context.Context, authMode AuthMode, pin, passphrase string) (*AsyncResponse, error) {
+func (c *Client) ReplacePlatformKey(ctx context.Context, authMode AuthMode, pin, passphrase string, kdf KDFOptions) (*AsyncResponse, error) {
 	body := PlatformKeyRequest{
 		Action:     "replace-platform-key",
 		AuthMode:   authMode,
 		Pin:        pin,
 		Passphrase: passphrase,
+		KDFType:    kdf.Type,
+	}
+
+	// snapd decodes the KDF time as a duration, in nanoseconds.
+	if kdf.Time > 0 {
+		kdfTime := int(kdf.Time)
+		body.KDFTime = &kdfTime
 	}
 
 	resp, err := c.doAsyncRequest(ctx, http.MethodPost,
@@ -0,0 +1,346 @@
+package testutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// EscrowServerOptions configures the behavior of an EscrowServer.
+type EscrowServerOptions struct {
+	// Status makes the server answer every request with this status instead of storing the record.
+	Status int
+	// Body replaces the acknowledgement sent for stored records.
+	Body string
+	// RevokeStatus makes the server answer revocation requests with this status instead of revoking.
+	RevokeStatus int
+	// RedirectTo makes the server redirect record submissions to this URL.
+	RedirectTo string
+
+	// TLS serves HTTPS with a self-signed certificate, see WriteCABundle.
+	TLS bool
+	// RequireClientCert serves HTTPS and requires the client certificates of WriteClientCertificate.
+	RequireClientCert bool
+}
+
+// EscrowMachine is the machine identity of an escrowed record.
+type EscrowMachine struct {
+	MachineID string `json:"machine-id"`
+	Hostname  string `json:"hostname"`
+	Landscape *struct {
+		AccountName string `json:"account-name"`
+		ComputerID  int64  `json:"computer-id"`
+	} `json:"landscape"`
+}
+
+// EscrowRecord is a record received by an EscrowServer.
+type EscrowRecord struct {
+	ID          string        `json:"-"`
+	ContentType string        `json:"-"`
+	KeyID       string        `json:"key-id"`
+	KeySlot     string        `json:"keyslot"`
+	Machine     EscrowMachine `json:"machine"`
+	Created     time.Time     `json:"created"`
+
+	// EncryptedKey is the recovery key encrypted to the organisation key.
+	EncryptedKey json.RawMessage `json:"encrypted-recovery-key"`
+	// RecoveryKey is set if the key was sent in clear, which must never happen.
+	RecoveryKey string `json:"recovery-key"`
+
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSalt string `json:"fingerprint-salt"`
+
+	// Revoked is true once the record was superseded by a newer one.
+	Revoked bool `json:"-"`
+}
+
+// escrowRevocation is a revocation request received by an EscrowServer.
+type escrowRevocation struct {
+	Machine      EscrowMachine `json:"machine"`
+	KeySlot      string        `json:"keyslot"`
+	SupersededBy string        `json:"superseded-by"`
+}
+
+// EscrowServer is a local stand-in for an enterprise recovery-key escrow endpoint.
+type EscrowServer struct {
+	*httptest.Server
+
+	opts    EscrowServerOptions
+	mu      sync.Mutex
+	records []EscrowRecord
+}
+
+// NewEscrowServer starts an escrow server which is closed when the test ends.
+// Records are stored by posting them to any path, and revoked by posting to a path ending in /revoke.
+func NewEscrowServer(t *testing.T, opts EscrowServerOptions) *EscrowServer {
+	t.Helper()
+
+	s := &EscrowServer{opts: opts}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	// Rejected TLS handshakes are expected by the tests, do not log them.
+	s.Config.ErrorLog = log.New(io.Discard, "", 0)
+	switch {
+	case opts.RequireClientCert:
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA().cert)
+		s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+		s.StartTLS()
+	case opts.TLS:
+		s.StartTLS()
+	default:
+		s.Start()
+	}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// WriteCABundle writes the certificate of the TLS server in a temporary directory and returns its path.
+func (s *EscrowServer) WriteCABundle(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Setup: failed to write CA bundle: %v", err)
+	}
+
+	return path
+}
+
+// Records returns the records stored so far, in order.
+func (s *EscrowServer) Records() []EscrowRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]EscrowRecord(nil), s.records...)
+}
+
+// SetStatus changes the status the server answers record submissions with, 0 to store them again.
+func (s *EscrowServer) SetStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts.Status = status
+}
+
+// AddRecord stores a record as if it had been escrowed, and returns its ID.
+func (s *EscrowServer) AddRecord(rec EscrowRecord) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec.ID = fmt.Sprintf("escrow-%d", len(s.records)+1)
+	s.records = append(s.records, rec)
+
+	return rec.ID
+}
+
+func (s *EscrowServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/revoke") {
+		s.revoke(w, r)
+		return
+	}
+	if s.opts.RedirectTo != "" {
+		http.Redirect(w, r, s.opts.RedirectTo, http.StatusTemporaryRedirect)
+		return
+	}
+	s.mu.Lock()
+	status := s.opts.Status
+	s.mu.Unlock()
+	if status != 0 {
+		http.Error(w, "mocked escrow failure", status)
+		return
+	}
+
+	var rec EscrowRecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.ContentType = r.Header.Get("Content-Type")
+	rec.ID = s.AddRecord(rec)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if s.opts.Body != "" {
+		fmt.Fprint(w, s.opts.Body)
+		return
+	}
+	fmt.Fprintf(w, `{"id": %q}`, rec.ID)
+}
+
+// revoke marks the records of the machine keyslot as revoked, except the superseding one.
+func (s *EscrowServer) revoke(w http.ResponseWriter, r *http.Request) {
+	if s.opts.RevokeStatus != 0 {
+		http.Error(w, "mocked revocation failure", s.opts.RevokeStatus)
+		return
+	}
+
+	var req escrowRevocation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	revoked := []string{}
+	for i, rec := range s.records {
+		if rec.Revoked || rec.ID == req.SupersededBy || rec.KeySlot != req.KeySlot || rec.Machine.MachineID != req.Machine.MachineID {
+			continue
+		}
+		s.records[i].Revoked = true
+		revoked = append(revoked, rec.ID)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"revoked": revoked})
+}
+
+// orgKey is the organisation key of the tests, generated once as RSA keys are slow to generate.
+var orgKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// WriteOrgKey writes a PEM encoded organisation RSA key pair in a temporary directory and
+// returns the paths of the public key and of the private key.
+func WriteOrgKey(t *testing.T) (publicKey, privateKey string) {
+	t.Helper()
+
+	key := orgKey()
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Setup: failed to marshal public key: %v", err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Setup: failed to marshal private key: %v", err)
+	}
+
+	dir := t.TempDir()
+	publicKey = filepath.Join(dir, "org.pub.pem")
+	privateKey = filepath.Join(dir, "org.pem")
+	if err := os.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600); err != nil {
+		t.Fatalf("Setup: failed to write public key: %v", err)
+	}
+	if err := os.WriteFile(privateKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600); err != nil {
+		t.Fatalf("Setup: failed to write private key: %v", err)
+	}
+
+	return publicKey, privateKey
+}
+
+// ClientCertificateOptions configures the certificate written by WriteClientCertificate.
+type ClientCertificateOptions struct {
+	// Untrusted signs the certificate with a CA unknown to the escrow servers.
+	Untrusted bool
+	// NotAfter is the expiry of the certificate, in a day by default.
+	NotAfter time.Time
+}
+
+// testCA is a certificate authority of the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// clientCA signs the client certificates trusted by the escrow servers.
+var clientCA = sync.OnceValue(func() testCA { return newTestCA("Escrow Client CA") })
+
+func newTestCA(name string) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return testCA{cert: cert, key: key}
+}
+
+// WriteClientCertificate writes a PEM encoded client certificate and its private key in a
+// temporary directory and returns their paths.
+func WriteClientCertificate(t *testing.T, opts ClientCertificateOptions) (certFile, keyFile string) {
+	t.Helper()
+
+	ca := clientCA()
+	if opts.Untrusted {
+		ca = newTestCA("Untrusted CA")
+	}
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Setup: failed to generate client key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "0123456789abcdef"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     opts.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Setup: failed to create client certificate: %v", err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Setup: failed to marshal client key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Setup: failed to write client certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600); err != nil {
+		t.Fatalf("Setup: failed to write client key: %v", err)
+	}
+
+	return certFile, keyFile
+}
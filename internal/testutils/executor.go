@@ -0,0 +1,52 @@
+package testutils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// FakeExecutor records the commands it is asked to run instead of running them.
+type FakeExecutor struct {
+	// FailOn makes any command whose command line starts with one of these prefixes fail.
+	FailOn []string
+
+	mu       sync.Mutex
+	commands []string
+	stdins   [][]byte
+}
+
+// Run records the command line and its input, and fails if it matches FailOn.
+func (e *FakeExecutor) Run(ctx context.Context, stdin []byte, name string, args ...string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cmdline := strings.Join(append([]string{name}, args...), " ")
+	e.commands = append(e.commands, cmdline)
+	e.stdins = append(e.stdins, stdin)
+
+	for _, prefix := range e.FailOn {
+		if strings.HasPrefix(cmdline, prefix) {
+			return errors.New("mocked error for " + cmdline)
+		}
+	}
+
+	return nil
+}
+
+// Commands returns the command lines run so far, in order.
+func (e *FakeExecutor) Commands() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]string(nil), e.commands...)
+}
+
+// Stdin returns the input given to the i-th command.
+func (e *FakeExecutor) Stdin(i int) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.stdins[i]
+}
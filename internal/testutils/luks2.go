@@ -0,0 +1,343 @@
+package testutils
+
+import (
+	"crypto/aes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/xts"
+)
+
+const (
+	// LUKS2HeaderSize is the size of each generated LUKS2 header, binary and JSON areas included.
+	LUKS2HeaderSize = 0x4000
+
+	luks2BinaryHeaderSize = 4096
+	luks2ChecksumOffset   = 448
+)
+
+// LUKS2Options configures a generated LUKS2 header.
+type LUKS2Options struct {
+	Label string
+	UUID  string
+	// SeqID is the sequence ID of the primary header.
+	SeqID uint64
+	// SecondarySeqID is the sequence ID of the secondary header. If not set, defaults to SeqID.
+	SecondarySeqID uint64
+
+	// Metadata is encoded in the JSON area. If not set, defaults to DefaultLUKS2Metadata.
+	Metadata any
+
+	// Size is the total size of the image. If not set, the image only contains both headers.
+	Size int
+}
+
+// NewLUKS2Image returns an image holding a primary and a secondary LUKS2 header with valid checksums.
+func NewLUKS2Image(t testing.TB, opts LUKS2Options) []byte {
+	t.Helper()
+
+	if opts.UUID == "" {
+		opts.UUID = "4b7c8c2e-6f5d-4c8e-9a1b-3d2f1e0c9b8a"
+	}
+	if opts.SecondarySeqID == 0 {
+		opts.SecondarySeqID = opts.SeqID
+	}
+	if opts.Metadata == nil {
+		opts.Metadata = DefaultLUKS2Metadata()
+	}
+
+	metadata, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		t.Fatalf("Setup: failed to encode LUKS2 metadata: %v", err)
+	}
+	if len(metadata) >= LUKS2HeaderSize-luks2BinaryHeaderSize {
+		t.Fatalf("Setup: LUKS2 metadata too large: %d bytes", len(metadata))
+	}
+
+	image := make([]byte, max(opts.Size, 2*LUKS2HeaderSize))
+	writeLUKS2Header(image[:LUKS2HeaderSize], "LUKS\xba\xbe", 0, opts.SeqID, opts, metadata)
+	writeLUKS2Header(image[LUKS2HeaderSize:2*LUKS2HeaderSize], "SKUL\xba\xbe", LUKS2HeaderSize, opts.SecondarySeqID, opts, metadata)
+
+	return image
+}
+
+// SealLUKS2Header recomputes the checksum of the header stored at offset in the image.
+func SealLUKS2Header(image []byte, offset int) {
+	area := image[offset : offset+LUKS2HeaderSize]
+	clear(area[luks2ChecksumOffset : luks2ChecksumOffset+sha256.Size])
+	sum := sha256.Sum256(area)
+	copy(area[luks2ChecksumOffset:], sum[:])
+}
+
+func writeLUKS2Header(area []byte, magic string, offset int, seqID uint64, opts LUKS2Options, metadata []byte) {
+	copy(area[0:6], magic)
+	binary.BigEndian.PutUint16(area[6:8], 2)
+	binary.BigEndian.PutUint64(area[8:16], LUKS2HeaderSize)
+	binary.BigEndian.PutUint64(area[16:24], seqID)
+	copy(area[24:72], opts.Label)
+	copy(area[72:104], "sha256")
+	copy(area[168:208], opts.UUID)
+	binary.BigEndian.PutUint64(area[256:264], uint64(offset))
+	copy(area[luks2BinaryHeaderSize:], metadata)
+
+	sum := sha256.Sum256(area)
+	copy(area[luks2ChecksumOffset:], sum[:])
+}
+
+// DefaultLUKS2Metadata returns the metadata of a device with a passphrase keyslot and a recovery keyslot.
+func DefaultLUKS2Metadata() map[string]any {
+	return map[string]any{
+		"keyslots": map[string]any{
+			"0": luks2Keyslot(0x8000, map[string]any{
+				"type":   "argon2id",
+				"time":   4,
+				"memory": 32,
+				"cpus":   1,
+				"salt":   make([]byte, 32),
+			}),
+			"1": luks2Keyslot(0x8000+0x40000, map[string]any{
+				"type":       "pbkdf2",
+				"hash":       "sha256",
+				"iterations": 1000,
+				"salt":       make([]byte, 32),
+			}),
+		},
+		"tokens": map[string]any{
+			"0": LUKS2PlatformToken("default", "0", "passphrase"),
+			"1": LUKS2RecoveryToken("default-recovery", "1"),
+		},
+		"segments": map[string]any{
+			"0": map[string]any{
+				"type":        "crypt",
+				"offset":      "16777216",
+				"size":        "dynamic",
+				"iv_tweak":    "0",
+				"encryption":  "aes-xts-plain64",
+				"sector_size": 512,
+			},
+		},
+		"digests": map[string]any{
+			"0": map[string]any{
+				"type":       "pbkdf2",
+				"keyslots":   []string{"0", "1"},
+				"segments":   []string{"0"},
+				"hash":       "sha256",
+				"iterations": 1000,
+				"salt":       make([]byte, 32),
+				"digest":     make([]byte, 32),
+			},
+		},
+		"config": map[string]any{
+			"json_size":     "12288",
+			"keyslots_size": "16744448",
+		},
+	}
+}
+
+func luks2Keyslot(areaOffset int, kdf map[string]any) map[string]any {
+	return map[string]any{
+		"type":     "luks2",
+		"key_size": 64,
+		"area": map[string]any{
+			"type":       "raw",
+			"offset":     strconv.Itoa(areaOffset),
+			"size":       "258048",
+			"encryption": "aes-xts-plain64",
+			"key_size":   64,
+		},
+		"kdf": kdf,
+		"af": map[string]any{
+			"type":    "luks1",
+			"stripes": 4000,
+			"hash":    "sha256",
+		},
+	}
+}
+
+// LUKS2PlatformToken returns an ubuntu-fde token for a tpm2 platform keyslot.
+// The auth mode is either none or passphrase.
+func LUKS2PlatformToken(name, keyslot, authMode string) map[string]any {
+	data := map[string]any{
+		"generation":    2,
+		"platform_name": "tpm2",
+		"role":          "run+recover",
+	}
+
+	if authMode == "passphrase" {
+		data["passphrase_params"] = map[string]any{
+			"kdf": map[string]any{"type": "argon2id", "time": 4, "memory": 1024, "cpus": 1},
+		}
+	}
+
+	return map[string]any{
+		"type":            "ubuntu-fde",
+		"keyslots":        []string{keyslot},
+		"ubuntu_fde_name": name,
+		"ubuntu_fde_data": data,
+	}
+}
+
+// LUKS2RecoveryToken returns an ubuntu-fde-recovery token naming a recovery keyslot.
+func LUKS2RecoveryToken(name, keyslot string) map[string]any {
+	return map[string]any{
+		"type":            "ubuntu-fde-recovery",
+		"keyslots":        []string{keyslot},
+		"ubuntu_fde_name": name,
+	}
+}
+
+// LUKS2KeyslotOptions configures a keyslot of a generated LUKS2 image.
+type LUKS2KeyslotOptions struct {
+	Passphrase []byte
+	// KDF is one of pbkdf2, argon2i or argon2id, with the lowest costs for fast tests.
+	KDF string
+}
+
+const (
+	luks2KeySize      = 64
+	luks2Stripes      = 4000
+	luks2AreaOffset   = 0x8000
+	luks2AreaStride   = 0x40000
+	luks2SectorSize   = 512
+	luks2KDFIteration = 1000
+)
+
+// NewLUKS2ImageWithKeyslots returns an image whose keyslots all unlock the same random volume key,
+// each with its own passphrase. The keyslots and digests of opts.Metadata are replaced.
+func NewLUKS2ImageWithKeyslots(t testing.TB, opts LUKS2Options, keyslots ...LUKS2KeyslotOptions) []byte {
+	t.Helper()
+
+	md := DefaultLUKS2Metadata()
+	if opts.Metadata != nil {
+		m, ok := opts.Metadata.(map[string]any)
+		if !ok {
+			t.Fatalf("Setup: LUKS2 metadata must be a map[string]any, got %T", opts.Metadata)
+		}
+		md = m
+	}
+
+	volumeKey := randomBytes(t, luks2KeySize)
+
+	slots := make(map[string]any)
+	areas := make(map[int][]byte)
+	var ids []string
+	for i, ks := range keyslots {
+		id := strconv.Itoa(i)
+		ids = append(ids, id)
+
+		kdf := map[string]any{"type": ks.KDF, "salt": randomBytes(t, 32)}
+		switch ks.KDF {
+		case "pbkdf2":
+			kdf["hash"] = "sha256"
+			kdf["iterations"] = luks2KDFIteration
+		case "argon2i", "argon2id":
+			kdf["time"] = 1
+			kdf["memory"] = 32
+			kdf["cpus"] = 1
+		default:
+			t.Fatalf("Setup: unsupported KDF %q", ks.KDF)
+		}
+
+		offset := luks2AreaOffset + i*luks2AreaStride
+		slots[id] = luks2Keyslot(offset, kdf)
+		areas[offset] = encryptLUKS2Area(t, ks, kdf, volumeKey)
+	}
+	md["keyslots"] = slots
+
+	digestSalt := randomBytes(t, 32)
+	digest, err := pbkdf2.Key(sha256.New, string(volumeKey), digestSalt, luks2KDFIteration, 32)
+	if err != nil {
+		t.Fatalf("Setup: failed to compute digest: %v", err)
+	}
+	md["digests"] = map[string]any{
+		"0": map[string]any{
+			"type":       "pbkdf2",
+			"keyslots":   ids,
+			"segments":   []string{"0"},
+			"hash":       "sha256",
+			"iterations": luks2KDFIteration,
+			"salt":       digestSalt,
+			"digest":     digest,
+		},
+	}
+
+	opts.Metadata = md
+	opts.Size = max(opts.Size, luks2AreaOffset+len(keyslots)*luks2AreaStride)
+	image := NewLUKS2Image(t, opts)
+	for offset, area := range areas {
+		copy(image[offset:], area)
+	}
+
+	return image
+}
+
+// encryptLUKS2Area splits the volume key with the anti-forensic splitter and encrypts it
+// with the key derived from the keyslot passphrase.
+func encryptLUKS2Area(t testing.TB, ks LUKS2KeyslotOptions, kdf map[string]any, volumeKey []byte) []byte {
+	t.Helper()
+
+	salt := kdf["salt"].([]byte)
+	var areaKey []byte
+	switch ks.KDF {
+	case "pbkdf2":
+		k, err := pbkdf2.Key(sha256.New, string(ks.Passphrase), salt, luks2KDFIteration, luks2KeySize)
+		if err != nil {
+			t.Fatalf("Setup: failed to derive keyslot key: %v", err)
+		}
+		areaKey = k
+	case "argon2i":
+		areaKey = argon2.Key(ks.Passphrase, salt, 1, 32, 1, luks2KeySize)
+	case "argon2id":
+		areaKey = argon2.IDKey(ks.Passphrase, salt, 1, 32, 1, luks2KeySize)
+	}
+
+	// Split: random stripes, the last one being the key XORed with the diffused others.
+	splitSize := luks2KeySize * luks2Stripes
+	area := make([]byte, (splitSize+luks2SectorSize-1)/luks2SectorSize*luks2SectorSize)
+	copy(area, randomBytes(t, splitSize))
+	buf := make([]byte, luks2KeySize)
+	for i := range luks2Stripes - 1 {
+		subtle.XORBytes(buf, buf, area[i*luks2KeySize:(i+1)*luks2KeySize])
+		luks2Diffuse(buf)
+	}
+	subtle.XORBytes(area[(luks2Stripes-1)*luks2KeySize:splitSize], buf, volumeKey)
+
+	c, err := xts.NewCipher(aes.NewCipher, areaKey)
+	if err != nil {
+		t.Fatalf("Setup: failed to create keyslot cipher: %v", err)
+	}
+	for sector := range len(area) / luks2SectorSize {
+		block := area[sector*luks2SectorSize : (sector+1)*luks2SectorSize]
+		c.Encrypt(block, block, uint64(sector))
+	}
+
+	return area
+}
+
+// luks2Diffuse is the sha256 diffusion function of the LUKS anti-forensic splitter.
+func luks2Diffuse(buf []byte) {
+	for i := 0; i*sha256.Size < len(buf); i++ {
+		block := buf[i*sha256.Size : min((i+1)*sha256.Size, len(buf))]
+		var iv [4]byte
+		binary.BigEndian.PutUint32(iv[:], uint32(i))
+		sum := sha256.Sum256(append(iv[:], block...))
+		copy(block, sum[:])
+	}
+}
+
+func randomBytes(t testing.TB, n int) []byte {
+	t.Helper()
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("Setup: failed to generate random bytes: %v", err)
+	}
+	return b
+}
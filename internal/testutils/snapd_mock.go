@@ -5,6 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"sync"
 
 	"snap-tpmctl/internal/snapd"
 )
@@ -16,6 +19,8 @@ type MockConfig struct {
 	GenerateKeyError       bool
 	EnumerateError         bool
 	AddKeyError            bool
+	ReplaceKeyError        bool
+	RemoveKeySlotsError    bool
 	CheckPassphraseError   bool
 	CheckPINError          bool
 	ReplacePassphraseError bool
@@ -43,6 +48,15 @@ type MockConfig struct {
 	// AuthMode configuration for EnumerateKeySlots
 	// If not set, defaults to "passphrase"
 	AuthMode string
+
+	// Inconsistent keyslot state configuration for EnumerateKeySlots
+	// FallbackAuthMode is the auth mode of the default-fallback keyslots. If not set, defaults to AuthMode
+	FallbackAuthMode    string
+	MissingDataFallback bool
+	MissingSaveRecovery bool
+
+	// EnterpriseKeySlot adds an escrowed enterprise recovery keyslot to both volumes
+	EnterpriseKeySlot bool
 }
 
 // MockSnapdClient is a mock implementation of the snapdClienter interface for testing.
@@ -53,6 +67,29 @@ type MockSnapdClient struct {
 	generatedKey  *snapd.GenerateRecoveryKeyResult
 	systemVolumes *snapd.SystemVolumesResult
 	asyncResp     *snapd.AsyncResponse
+
+	// calls records the calls changing keyslots, shared by the copies of the mock.
+	calls *callLog
+}
+
+// callLog records the calls of a mock, safe for concurrent use.
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, fmt.Sprintf(format, args...))
+}
+
+// Calls returns the calls changing keyslots made so far, e.g. "ReplaceRecoveryKey test-key-id-12345"
+// or "RemoveSystemVolumeKeySlots ubuntu-data additional-recovery".
+func (m MockSnapdClient) Calls() []string {
+	m.calls.mu.Lock()
+	defer m.calls.mu.Unlock()
+	return slices.Clone(m.calls.calls)
 }
 
 // NewMockSnapdClient creates a new mock snapd client with the given configuration.
@@ -62,12 +99,17 @@ func NewMockSnapdClient(cfg MockConfig) *MockSnapdClient {
 	if authMode == "" {
 		authMode = "passphrase"
 	}
+	fallbackAuthMode := cfg.FallbackAuthMode
+	if fallbackAuthMode == "" {
+		fallbackAuthMode = authMode
+	}
 
-	return &MockSnapdClient{
+	m := &MockSnapdClient{
 		config: cfg,
+		calls:  &callLog{},
 		generatedKey: &snapd.GenerateRecoveryKeyResult{
 			KeyID:       "test-key-id-12345",
-			RecoveryKey: "12345-67890-12345-67890-12345-67890-12345-67890",
+			RecoveryKey: "12345-54321-12345-54321-12345-54321-12345-54321",
 		},
 		systemVolumes: &snapd.SystemVolumesResult{
 			ByContainerRole: map[string]snapd.VolumeInfo{
@@ -84,7 +126,7 @@ func NewMockSnapdClient(cfg MockConfig) *MockSnapdClient {
 						},
 						"default-fallback": {
 							Type:         "platform",
-							AuthMode:     authMode,
+							AuthMode:     fallbackAuthMode,
 							PlatformName: "tpm2",
 							Roles:        []string{"recover"},
 						},
@@ -108,7 +150,7 @@ func NewMockSnapdClient(cfg MockConfig) *MockSnapdClient {
 						},
 						"default-fallback": {
 							Type:         "platform",
-							AuthMode:     authMode,
+							AuthMode:     fallbackAuthMode,
 							PlatformName: "tpm2",
 							Roles:        []string{"recover"},
 						},
@@ -129,6 +171,20 @@ func NewMockSnapdClient(cfg MockConfig) *MockSnapdClient {
 			Summary: "Add recovery key",
 		},
 	}
+
+	if cfg.MissingDataFallback {
+		delete(m.systemVolumes.ByContainerRole["system-data"].KeySlots, "default-fallback")
+	}
+	if cfg.MissingSaveRecovery {
+		delete(m.systemVolumes.ByContainerRole["system-save"].KeySlots, "additional-recovery")
+	}
+	if cfg.EnterpriseKeySlot {
+		for _, volumeInfo := range m.systemVolumes.ByContainerRole {
+			volumeInfo.KeySlots["enterprise-recovery"] = snapd.KeySlotInfo{Type: "recovery"}
+		}
+	}
+
+	return m
 }
 
 // LoadAuthFromHome simulates loading authentication from the user's home directory.
@@ -163,6 +219,26 @@ func (m MockSnapdClient) AddRecoveryKey(ctx context.Context, keyID string, slots
 	return m.asyncResp, nil
 }
 
+// ReplaceRecoveryKey simulates replacing a recovery key in specified slots.
+func (m MockSnapdClient) ReplaceRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error) {
+	m.calls.add("ReplaceRecoveryKey %s", keyID)
+	if m.config.ReplaceKeyError {
+		return nil, errors.New("mocked error for ReplaceRecoveryKey: cannot replace recovery key: permission denied")
+	}
+	return m.asyncResp, nil
+}
+
+// RemoveSystemVolumeKeySlots simulates removing keyslots from a system volume.
+func (m MockSnapdClient) RemoveSystemVolumeKeySlots(ctx context.Context, volume string, keySlots []snapd.VolumeKeySlot) (*snapd.Response, error) {
+	if m.config.RemoveKeySlotsError {
+		return nil, errors.New("mocked error for RemoveSystemVolumeKeySlots: cannot remove keyslots: permission denied")
+	}
+	for _, ks := range keySlots {
+		m.calls.add("RemoveSystemVolumeKeySlots %s %s", volume, ks.Name)
+	}
+	return &snapd.Response{Status: "OK", StatusCode: 200}, nil
+}
+
 // Close closes the mock client connection.
 func (m MockSnapdClient) Close() error {
 	return nil
@@ -276,7 +352,7 @@ func (m MockSnapdClient) ReplacePIN(ctx context.Context, oldPin string, newPin s
 }
 
 // ReplacePlatformKey simulates replacing a platform key.
-func (m MockSnapdClient) ReplacePlatformKey(ctx context.Context, authMode snapd.AuthMode, pin, passphrase string) (*snapd.AsyncResponse, error) {
+func (m MockSnapdClient) ReplacePlatformKey(ctx context.Context, authMode snapd.AuthMode, pin, passphrase string, kdf snapd.KDFOptions) (*snapd.AsyncResponse, error) {
 	if m.config.ReplacePlatformKeyError {
 		return nil, errors.New("mocked error for ReplacePlatformKey: cannot replace platform key: permission denied")
 	}
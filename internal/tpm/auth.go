@@ -3,20 +3,27 @@ package tpm
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"snap-tpmctl/internal/snapd"
 )
 
+// platformKeyReplacer defines the interface for snapd operations needed for changing the auth mode.
+type platformKeyReplacer interface {
+	ReplacePlatformKey(ctx context.Context, authMode snapd.AuthMode, pin, passphrase string, kdf snapd.KDFOptions) (*snapd.AsyncResponse, error)
+}
+
 // authReplacer defines the interface for snapd operations needed for changing authentication.
 type authReplacer interface {
+	platformKeyReplacer
 	ReplacePassphrase(ctx context.Context, oldPassphrase string, newPassphrase string, keySlots []snapd.KeySlot) (*snapd.AsyncResponse, error)
 	ReplacePIN(ctx context.Context, oldPin string, newPin string, keySlots []snapd.KeySlot) (*snapd.AsyncResponse, error)
-	ReplacePlatformKey(ctx context.Context, authMode snapd.AuthMode, pin, passphrase string) (*snapd.AsyncResponse, error)
 }
 
 // ReplacePassphrase replaces the passphrase using the provided client.
-func ReplacePassphrase(ctx context.Context, client authReplacer, oldPassphrase, newPassphrase string) error {
-	ares, err := client.ReplacePassphrase(ctx, oldPassphrase, newPassphrase, nil)
+// If no keyslots are given, snapd replaces the passphrase of the default keyslots.
+func ReplacePassphrase(ctx context.Context, client authReplacer, oldPassphrase, newPassphrase string, keySlots []snapd.KeySlot) error {
+	ares, err := client.ReplacePassphrase(ctx, oldPassphrase, newPassphrase, keySlots)
 	if err != nil {
 		return fmt.Errorf("failed to change passphrase: %w", err)
 	}
@@ -29,8 +36,9 @@ func ReplacePassphrase(ctx context.Context, client authReplacer, oldPassphrase,
 }
 
 // ReplacePIN replaces the PIN using the provided client.
-func ReplacePIN(ctx context.Context, client authReplacer, oldPin, newPin string) error {
-	ares, err := client.ReplacePIN(ctx, oldPin, newPin, nil)
+// If no keyslots are given, snapd replaces the PIN of the default keyslots.
+func ReplacePIN(ctx context.Context, client authReplacer, oldPin, newPin string, keySlots []snapd.KeySlot) error {
+	ares, err := client.ReplacePIN(ctx, oldPin, newPin, keySlots)
 	if err != nil {
 		return fmt.Errorf("failed to change PIN: %w", err)
 	}
@@ -43,8 +51,8 @@ func ReplacePIN(ctx context.Context, client authReplacer, oldPin, newPin string)
 }
 
 // AddPassphrase adds passphrase authentication to the platform key.
-func AddPassphrase(ctx context.Context, client authReplacer, passphrase string) error {
-	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModePassphrase, "", passphrase)
+func AddPassphrase(ctx context.Context, client authReplacer, passphrase string, kdf snapd.KDFOptions) error {
+	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModePassphrase, "", passphrase, kdf)
 	if err != nil {
 		return fmt.Errorf("failed to add passphrase: %w", err)
 	}
@@ -57,8 +65,8 @@ func AddPassphrase(ctx context.Context, client authReplacer, passphrase string)
 }
 
 // AddPIN adds PIN authentication to the platform key.
-func AddPIN(ctx context.Context, client authReplacer, pin string) error {
-	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModePin, pin, "")
+func AddPIN(ctx context.Context, client authReplacer, pin string, kdf snapd.KDFOptions) error {
+	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModePin, pin, "", kdf)
 	if err != nil {
 		return fmt.Errorf("failed to add PIN: %w", err)
 	}
@@ -72,7 +80,7 @@ func AddPIN(ctx context.Context, client authReplacer, pin string) error {
 
 // RemovePassphrase removes passphrase authentication from the platform key.
 func RemovePassphrase(ctx context.Context, client authReplacer) error {
-	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModeNone, "", "")
+	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModeNone, "", "", snapd.KDFOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to remove passphrase: %w", err)
 	}
@@ -86,7 +94,7 @@ func RemovePassphrase(ctx context.Context, client authReplacer) error {
 
 // RemovePIN removes PIN authentication from the platform key.
 func RemovePIN(ctx context.Context, client authReplacer) error {
-	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModeNone, "", "")
+	ares, err := client.ReplacePlatformKey(ctx, snapd.AuthModeNone, "", "", snapd.KDFOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to remove PIN: %w", err)
 	}
@@ -97,3 +105,53 @@ func RemovePIN(ctx context.Context, client authReplacer) error {
 
 	return nil
 }
+
+// SetAuthMode replaces the platform key with one protected by the given auth mode.
+// The secret is the PIN or passphrase for the pin and passphrase auth modes, and is ignored otherwise.
+func SetAuthMode(ctx context.Context, client platformKeyReplacer, authMode snapd.AuthMode, secret string, kdf snapd.KDFOptions) error {
+	var pin, passphrase string
+	switch authMode {
+	case snapd.AuthModePin:
+		pin = secret
+	case snapd.AuthModePassphrase:
+		passphrase = secret
+	case snapd.AuthModeNone:
+	default:
+		return fmt.Errorf("unsupported auth mode %q", authMode)
+	}
+
+	ares, err := client.ReplacePlatformKey(ctx, authMode, pin, passphrase, kdf)
+	if err != nil {
+		return fmt.Errorf("failed to set auth mode: %w", err)
+	}
+
+	if !ares.IsOK() {
+		return fmt.Errorf("unable to set auth mode: %s", ares.Err)
+	}
+
+	return nil
+}
+
+// ParseAuthMode parses a user provided auth mode.
+func ParseAuthMode(s string) (snapd.AuthMode, error) {
+	switch mode := snapd.AuthMode(s); mode {
+	case snapd.AuthModeNone, snapd.AuthModePin, snapd.AuthModePassphrase:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid auth mode %q: must be one of none, pin or passphrase", s)
+	}
+}
+
+// ParseKDFOptions parses user provided KDF tuning options. Empty values keep snapd defaults.
+func ParseKDFOptions(kdfType string, kdfTime time.Duration) (snapd.KDFOptions, error) {
+	if kdfTime < 0 {
+		return snapd.KDFOptions{}, fmt.Errorf("invalid KDF time %s: must be positive", kdfTime)
+	}
+
+	switch t := snapd.KDFType(kdfType); t {
+	case "", snapd.KDFTypeArgon2id, snapd.KDFTypeArgon2i, snapd.KDFTypePBKDF2:
+		return snapd.KDFOptions{Type: t, Time: kdfTime}, nil
+	default:
+		return snapd.KDFOptions{}, fmt.Errorf("invalid KDF type %q: must be one of argon2id, argon2i or pbkdf2", kdfType)
+	}
+}
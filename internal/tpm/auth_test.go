@@ -3,8 +3,10 @@ package tpm_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/snapd"
 	"snap-tpmctl/internal/testutils"
 	"snap-tpmctl/internal/tpm"
 )
@@ -38,7 +40,7 @@ func TestReplacePassphrase(t *testing.T) {
 				ReplacePassphraseNotOK: tc.replacePassphraseNotOK,
 			})
 
-			err := tpm.ReplacePassphrase(ctx, mockClient, tc.oldPassphrase, tc.newPassphrase)
+			err := tpm.ReplacePassphrase(ctx, mockClient, tc.oldPassphrase, tc.newPassphrase, nil)
 
 			if tc.wantErr {
 				be.Err(t, err)
@@ -78,7 +80,7 @@ func TestReplacePIN(t *testing.T) {
 				ReplacePINNotOK: tc.replacePINNotOK,
 			})
 
-			err := tpm.ReplacePIN(ctx, mockClient, tc.oldPin, tc.newPin)
+			err := tpm.ReplacePIN(ctx, mockClient, tc.oldPin, tc.newPin, nil)
 
 			if tc.wantErr {
 				be.Err(t, err)
@@ -114,7 +116,7 @@ func TestAddPIN(t *testing.T) {
 				ReplacePlatformKeyNotOK: tc.replacePlatformKeyNotOK,
 			})
 
-			err := tpm.AddPIN(ctx, mockClient, "123456")
+			err := tpm.AddPIN(ctx, mockClient, "123456", snapd.KDFOptions{})
 
 			if tc.wantErr {
 				be.Err(t, err)
@@ -186,7 +188,7 @@ func TestAddPassphrase(t *testing.T) {
 				ReplacePlatformKeyNotOK: tc.replacePlatformKeyNotOK,
 			})
 
-			err := tpm.AddPassphrase(ctx, mockClient, "my-secure-passphrase")
+			err := tpm.AddPassphrase(ctx, mockClient, "my-secure-passphrase", snapd.KDFOptions{Type: snapd.KDFTypeArgon2id, Time: time.Second})
 
 			if tc.wantErr {
 				be.Err(t, err)
@@ -232,3 +234,112 @@ func TestRemovePassphrase(t *testing.T) {
 		})
 	}
 }
+
+func TestSetAuthMode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		authMode snapd.AuthMode
+
+		replacePlatformKeyError bool
+		replacePlatformKeyNotOK bool
+
+		wantErr bool
+	}{
+		"Sets PIN authentication":        {authMode: snapd.AuthModePin},
+		"Sets passphrase authentication": {authMode: snapd.AuthModePassphrase},
+		"Sets no authentication":         {authMode: snapd.AuthModeNone},
+
+		"Error on unsupported auth mode": {authMode: "fingerprint", wantErr: true},
+		"Error when snapd down":          {authMode: snapd.AuthModePin, replacePlatformKeyError: true, wantErr: true},
+		"Error when response not ok":     {authMode: snapd.AuthModePin, replacePlatformKeyNotOK: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				ReplacePlatformKeyError: tc.replacePlatformKeyError,
+				ReplacePlatformKeyNotOK: tc.replacePlatformKeyNotOK,
+			})
+
+			err := tpm.SetAuthMode(ctx, mockClient, tc.authMode, "123456", snapd.KDFOptions{})
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
+
+func TestParseAuthMode(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		mode string
+
+		want    snapd.AuthMode
+		wantErr bool
+	}{
+		"Parses none":       {mode: "none", want: snapd.AuthModeNone},
+		"Parses pin":        {mode: "pin", want: snapd.AuthModePin},
+		"Parses passphrase": {mode: "passphrase", want: snapd.AuthModePassphrase},
+
+		"Error on empty mode":   {wantErr: true},
+		"Error on unknown mode": {mode: "PIN", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tpm.ParseAuthMode(tc.mode)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseKDFOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		kdfType string
+		kdfTime time.Duration
+
+		want    snapd.KDFOptions
+		wantErr bool
+	}{
+		"Keeps snapd defaults": {},
+		"Parses argon2id":      {kdfType: "argon2id", kdfTime: 2 * time.Second, want: snapd.KDFOptions{Type: snapd.KDFTypeArgon2id, Time: 2 * time.Second}},
+		"Parses argon2i":       {kdfType: "argon2i", want: snapd.KDFOptions{Type: snapd.KDFTypeArgon2i}},
+		"Parses pbkdf2":        {kdfType: "pbkdf2", want: snapd.KDFOptions{Type: snapd.KDFTypePBKDF2}},
+
+		"Error on unknown type":  {kdfType: "scrypt", wantErr: true},
+		"Error on negative time": {kdfTime: -time.Second, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tpm.ParseKDFOptions(tc.kdfType, tc.kdfTime)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
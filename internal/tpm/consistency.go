@@ -0,0 +1,206 @@
+package tpm
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"snap-tpmctl/internal/snapd"
+)
+
+// keySlotEnumerator defines the interface for snapd operations needed for consistency checks.
+type keySlotEnumerator interface {
+	EnumerateKeySlots(ctx context.Context) (*snapd.SystemVolumesResult, error)
+}
+
+// IssueKind identifies the type of inconsistency found between keyslots.
+type IssueKind string
+
+// Inconsistencies reported by CheckConsistency.
+const (
+	IssueAuthModeMismatch   IssueKind = "auth-mode-mismatch"
+	IssueMissingFallback    IssueKind = "missing-fallback"
+	IssueMissingRecoveryKey IssueKind = "missing-recovery-key"
+)
+
+const (
+	containerRoleSystemData = "system-data"
+	containerRoleSystemSave = "system-save"
+
+	keySlotDefault         = "default"
+	keySlotDefaultFallback = "default-fallback"
+
+	keySlotTypePlatform = "platform"
+	keySlotTypeRecovery = "recovery"
+
+	// platformNamePlainKey is the platform used by system-save's default keyslot,
+	// which is unlocked by a key stored in system-data and has no auth mode of its own.
+	platformNamePlainKey = "plainkey"
+)
+
+// Issue describes a single inconsistency between keyslots.
+type Issue struct {
+	Kind          IssueKind
+	ContainerRole string
+	KeySlot       string
+	Message       string
+}
+
+// ConsistencyReport contains the result of checking keyslots across all volumes.
+type ConsistencyReport struct {
+	// AuthMode is the auth mode the platform keyslots are expected to share.
+	AuthMode snapd.AuthMode
+	Issues   []Issue
+}
+
+// IsConsistent returns true if no issue was found.
+func (r ConsistencyReport) IsConsistent() bool {
+	return len(r.Issues) == 0
+}
+
+// Error returns all issues as a single error, or nil if the state is consistent.
+func (r ConsistencyReport) Error() error {
+	if r.IsConsistent() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		msgs = append(msgs, issue.Message)
+	}
+
+	return fmt.Errorf("inconsistent keyslot state:\n  - %s", strings.Join(msgs, "\n  - "))
+}
+
+// CheckConsistency walks every encrypted volume and reports keyslots whose state diverges.
+//
+// It reports platform keyslots with different auth modes, encrypted volumes missing a
+// default-fallback keyslot, and recovery keys that are present on only one of system-data
+// and system-save.
+func CheckConsistency(ctx context.Context, client keySlotEnumerator) (*ConsistencyReport, error) {
+	result, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	return CheckVolumes(result), nil
+}
+
+// ValidateConsistency returns an error listing all issues if the keyslot state is inconsistent.
+func ValidateConsistency(ctx context.Context, client keySlotEnumerator) error {
+	report, err := CheckConsistency(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	return report.Error()
+}
+
+// CheckVolumes reports the inconsistencies of already enumerated volumes, see CheckConsistency.
+func CheckVolumes(result *snapd.SystemVolumesResult) *ConsistencyReport {
+	report := &ConsistencyReport{
+		AuthMode: referenceAuthMode(result),
+	}
+
+	for _, role := range slices.Sorted(maps.Keys(result.ByContainerRole)) {
+		volume := result.ByContainerRole[role]
+		if !volume.Encrypted {
+			continue
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(volume.KeySlots)) {
+			slot := volume.KeySlots[name]
+			if !isSealedPlatformKeySlot(slot) {
+				continue
+			}
+			if slot.AuthMode != string(report.AuthMode) {
+				report.Issues = append(report.Issues, Issue{
+					Kind:          IssueAuthModeMismatch,
+					ContainerRole: role,
+					KeySlot:       name,
+					Message: fmt.Sprintf("keyslot %q in %s uses auth mode %s, expected %s",
+						name, role, slot.AuthMode, report.AuthMode),
+				})
+			}
+		}
+
+		if _, ok := volume.KeySlots[keySlotDefault]; !ok {
+			continue
+		}
+		if _, ok := volume.KeySlots[keySlotDefaultFallback]; !ok {
+			report.Issues = append(report.Issues, Issue{
+				Kind:          IssueMissingFallback,
+				ContainerRole: role,
+				KeySlot:       keySlotDefaultFallback,
+				Message:       fmt.Sprintf("%s has a %s keyslot but no %s keyslot", role, keySlotDefault, keySlotDefaultFallback),
+			})
+		}
+	}
+
+	report.Issues = append(report.Issues, checkRecoveryKeys(result)...)
+
+	return report
+}
+
+// checkRecoveryKeys reports recovery keyslots present on only one of system-data and system-save.
+func checkRecoveryKeys(result *snapd.SystemVolumesResult) []Issue {
+	data, ok := result.ByContainerRole[containerRoleSystemData]
+	if !ok || !data.Encrypted {
+		return nil
+	}
+	save, ok := result.ByContainerRole[containerRoleSystemSave]
+	if !ok || !save.Encrypted {
+		return nil
+	}
+
+	var issues []Issue
+	missingIn := func(from, to snapd.VolumeInfo, fromRole, toRole string) {
+		for _, name := range slices.Sorted(maps.Keys(from.KeySlots)) {
+			if from.KeySlots[name].Type != keySlotTypeRecovery {
+				continue
+			}
+			if _, ok := to.KeySlots[name]; ok {
+				continue
+			}
+			issues = append(issues, Issue{
+				Kind:          IssueMissingRecoveryKey,
+				ContainerRole: toRole,
+				KeySlot:       name,
+				Message:       fmt.Sprintf("recovery keyslot %q exists in %s but not in %s", name, fromRole, toRole),
+			})
+		}
+	}
+
+	missingIn(data, save, containerRoleSystemData, containerRoleSystemSave)
+	missingIn(save, data, containerRoleSystemSave, containerRoleSystemData)
+
+	return issues
+}
+
+// referenceAuthMode returns the auth mode other platform keyslots are compared against.
+// It is the one of system-data's default keyslot if any, or the first sealed platform keyslot found.
+func referenceAuthMode(result *snapd.SystemVolumesResult) snapd.AuthMode {
+	if data, ok := result.ByContainerRole[containerRoleSystemData]; ok {
+		if slot, ok := data.KeySlots[keySlotDefault]; ok && isSealedPlatformKeySlot(slot) {
+			return snapd.AuthMode(slot.AuthMode)
+		}
+	}
+
+	for _, role := range slices.Sorted(maps.Keys(result.ByContainerRole)) {
+		volume := result.ByContainerRole[role]
+		for _, name := range slices.Sorted(maps.Keys(volume.KeySlots)) {
+			if slot := volume.KeySlots[name]; isSealedPlatformKeySlot(slot) {
+				return snapd.AuthMode(slot.AuthMode)
+			}
+		}
+	}
+
+	return snapd.AuthModeNone
+}
+
+// isSealedPlatformKeySlot returns true for platform keyslots that carry their own auth mode.
+func isSealedPlatformKeySlot(slot snapd.KeySlotInfo) bool {
+	return slot.Type == keySlotTypePlatform && slot.PlatformName != platformNamePlainKey
+}
@@ -0,0 +1,116 @@
+package tpm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/testutils"
+	"snap-tpmctl/internal/tpm"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		authMode            string
+		fallbackAuthMode    string
+		missingDataFallback bool
+		missingSaveRecovery bool
+		enumerateFails      bool
+
+		wantAuthMode snapd.AuthMode
+		wantIssues   []tpm.IssueKind
+		wantErr      bool
+	}{
+		"Consistent state":                  {wantAuthMode: snapd.AuthModePassphrase},
+		"Consistent state without auth":     {authMode: "none", wantAuthMode: snapd.AuthModeNone},
+		"Ignores plainkey platform keyslot": {authMode: "pin", wantAuthMode: snapd.AuthModePin},
+
+		"Reports divergent auth modes": {
+			fallbackAuthMode: "none",
+			wantAuthMode:     snapd.AuthModePassphrase,
+			wantIssues:       []tpm.IssueKind{tpm.IssueAuthModeMismatch, tpm.IssueAuthModeMismatch},
+		},
+		"Reports missing fallback keyslot": {
+			missingDataFallback: true,
+			wantAuthMode:        snapd.AuthModePassphrase,
+			wantIssues:          []tpm.IssueKind{tpm.IssueMissingFallback},
+		},
+		"Reports recovery key missing on save": {
+			missingSaveRecovery: true,
+			wantAuthMode:        snapd.AuthModePassphrase,
+			wantIssues:          []tpm.IssueKind{tpm.IssueMissingRecoveryKey},
+		},
+
+		"Error when enumerate fails": {enumerateFails: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnumerateError:      tc.enumerateFails,
+				AuthMode:            tc.authMode,
+				FallbackAuthMode:    tc.fallbackAuthMode,
+				MissingDataFallback: tc.missingDataFallback,
+				MissingSaveRecovery: tc.missingSaveRecovery,
+			})
+
+			report, err := tpm.CheckConsistency(ctx, mockClient)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.wantAuthMode, report.AuthMode)
+
+			var gotIssues []tpm.IssueKind
+			for _, issue := range report.Issues {
+				gotIssues = append(gotIssues, issue.Kind)
+			}
+			be.Equal(t, tc.wantIssues, gotIssues)
+			be.Equal(t, len(tc.wantIssues) == 0, report.IsConsistent())
+		})
+	}
+}
+
+func TestValidateConsistency(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		missingSaveRecovery bool
+		enumerateFails      bool
+
+		wantErr bool
+	}{
+		"Success": {},
+
+		"Error when state is inconsistent": {missingSaveRecovery: true, wantErr: true},
+		"Error when enumerate fails":       {enumerateFails: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnumerateError:      tc.enumerateFails,
+				MissingSaveRecovery: tc.missingSaveRecovery,
+			})
+
+			err := tpm.ValidateConsistency(ctx, mockClient)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
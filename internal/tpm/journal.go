@@ -0,0 +1,328 @@
+package tpm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"snap-tpmctl/internal/fileutils"
+	"snap-tpmctl/internal/snapd"
+)
+
+// keySlotRemover defines the interface for snapd operations needed to roll back operations.
+type keySlotRemover interface {
+	EnumerateKeySlots(ctx context.Context) (*snapd.SystemVolumesResult, error)
+	RemoveSystemVolumeKeySlots(ctx context.Context, volume string, keySlots []snapd.VolumeKeySlot) (*snapd.Response, error)
+}
+
+// OperationKind identifies a journaled multi-step workflow.
+type OperationKind string
+
+// Journaled workflows.
+const (
+	OperationCreateKey     OperationKind = "create-key"
+	OperationRegenerateKey OperationKind = "regenerate-key"
+)
+
+// Steps of the journaled workflows.
+const (
+	StepGenerateRecoveryKey = "generate-recovery-key"
+	StepAddRecoveryKey      = "add-recovery-key"
+	StepReplaceRecoveryKey  = "replace-recovery-key"
+)
+
+// StepStatus is the state of a single step of an operation.
+type StepStatus string
+
+// Step states recorded in the journal.
+const (
+	StepPending StepStatus = "pending"
+	StepDone    StepStatus = "done"
+	StepFailed  StepStatus = "failed"
+)
+
+// Step is a single step of a journaled operation.
+type Step struct {
+	Name   string     `json:"name"`
+	Status StepStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Operation is a multi-step workflow recorded in the journal.
+// Recovery keys are never recorded, only their snapd key ID.
+type Operation struct {
+	ID      string        `json:"id"`
+	Kind    OperationKind `json:"kind"`
+	Started time.Time     `json:"started"`
+	KeySlot string        `json:"keyslot,omitempty"`
+	KeyID   string        `json:"key-id,omitempty"`
+	Steps   []Step        `json:"steps"`
+	// AddTargets are the container roles which did not have the keyslot when the key was
+	// added, so that a rollback never removes a keyslot the operation did not create.
+	AddTargets []string `json:"add-targets,omitempty"`
+	// Metadata is recorded in the registry once the key is in use, including when resumed.
+	Metadata KeyMetadata `json:"metadata,omitzero"`
+
+	journal *Journal
+}
+
+// Journal records the steps of multi-step operations so that a partial failure or a crash
+// can be reported precisely, then resumed or rolled back.
+type Journal struct {
+	dir string
+}
+
+// NewJournal returns a journal stored under the given state directory.
+func NewJournal(stateDir string) *Journal {
+	return &Journal{dir: filepath.Join(stateDir, "journal")}
+}
+
+// Begin records a new operation with all its steps pending.
+func (j *Journal) Begin(kind OperationKind, keySlot string, steps ...string) (*Operation, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate operation ID: %w", err)
+	}
+
+	op := &Operation{
+		ID:      hex.EncodeToString(id),
+		Kind:    kind,
+		Started: time.Now().UTC(),
+		KeySlot: keySlot,
+		journal: j,
+	}
+	for _, name := range steps {
+		op.Steps = append(op.Steps, Step{Name: name, Status: StepPending})
+	}
+
+	if err := op.save(); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// Pending returns all unfinished operations, oldest first.
+func (j *Journal) Pending() ([]*Operation, error) {
+	entries, err := os.ReadDir(j.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var ops []*Operation
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		op, err := j.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	slices.SortFunc(ops, func(a, b *Operation) int {
+		return a.Started.Compare(b.Started)
+	})
+
+	return ops, nil
+}
+
+// Get returns the unfinished operation with the given ID.
+func (j *Journal) Get(id string) (*Operation, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, fmt.Errorf("invalid operation ID %q", id)
+	}
+
+	data, err := os.ReadFile(filepath.Join(j.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no pending operation with ID %q", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal entry: %w", err)
+	}
+
+	var op Operation
+	if err := json.Unmarshal(data, &op); err != nil {
+		return nil, fmt.Errorf("failed to parse journal entry %q: %w", id, err)
+	}
+	op.journal = j
+
+	return &op, nil
+}
+
+// Complete marks a step as done.
+func (op *Operation) Complete(step string) error {
+	return op.setStatus(step, StepDone, nil)
+}
+
+// Fail marks a step as failed and returns an OperationError describing the partial failure.
+// If the failure cannot be recorded, both errors are returned.
+func (op *Operation) Fail(step string, err error) error {
+	opErr := &OperationError{Operation: op, Step: step, Err: err}
+	if jErr := op.setStatus(step, StepFailed, err); jErr != nil {
+		return errors.Join(opErr, jErr)
+	}
+	return opErr
+}
+
+// Finish removes a successfully completed operation from the journal.
+func (op *Operation) Finish() error {
+	if err := os.Remove(op.path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal entry: %w", err)
+	}
+	return nil
+}
+
+// StepStatus returns the status of the given step.
+func (op *Operation) StepStatus(step string) StepStatus {
+	for _, s := range op.Steps {
+		if s.Name == step {
+			return s.Status
+		}
+	}
+	return StepPending
+}
+
+// String returns a one line summary of the operation and its steps.
+func (op *Operation) String() string {
+	steps := make([]string, 0, len(op.Steps))
+	for _, s := range op.Steps {
+		steps = append(steps, fmt.Sprintf("%s: %s", s.Name, s.Status))
+	}
+
+	return fmt.Sprintf("%s %s %q started %s (%s)",
+		op.ID, op.Kind, op.KeySlot, op.Started.Local().Format(time.DateTime), strings.Join(steps, ", "))
+}
+
+func (op *Operation) setStatus(step string, status StepStatus, err error) error {
+	for i := range op.Steps {
+		if op.Steps[i].Name != step {
+			continue
+		}
+		op.Steps[i].Status = status
+		if err != nil {
+			op.Steps[i].Error = err.Error()
+		}
+		return op.save()
+	}
+
+	return fmt.Errorf("unknown step %q for operation %s", step, op.Kind)
+}
+
+func (op *Operation) path() string {
+	return filepath.Join(op.journal.dir, op.ID+".json")
+}
+
+// save atomically writes the operation to the journal.
+func (op *Operation) save() error {
+	data, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	if err := fileutils.WriteFileAtomic(op.path(), data); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+
+	return nil
+}
+
+// OperationError reports which step of a journaled operation failed.
+type OperationError struct {
+	Operation *Operation
+	Step      string
+	Err       error
+}
+
+func (e *OperationError) Error() string {
+	var done []string
+	for _, s := range e.Operation.Steps {
+		if s.Status == StepDone {
+			done = append(done, s.Name)
+		}
+	}
+
+	completed := "none"
+	if len(done) > 0 {
+		completed = strings.Join(done, ", ")
+	}
+
+	return fmt.Sprintf("%s operation %s failed at step %s (completed steps: %s): %v",
+		e.Operation.Kind, e.Operation.ID, e.Step, completed, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// Rollback applies the compensating actions of an unfinished operation and removes it from the journal.
+//
+// A half-added recovery key is removed from the volumes it may have been added to, never from
+// the ones which already had the keyslot. A regenerated key cannot be restored, so
+// regenerate-key operations are only discarded.
+func Rollback(ctx context.Context, client keySlotRemover, op *Operation) error {
+	if op.Kind == OperationCreateKey && op.StepStatus(StepAddRecoveryKey) != StepDone && len(op.AddTargets) > 0 {
+		if err := removeKeySlot(ctx, client, op.KeySlot, op.AddTargets); err != nil {
+			return err
+		}
+	}
+
+	return op.Finish()
+}
+
+// removeKeySlot removes the named keyslot from the volumes of the given container roles it exists in.
+func removeKeySlot(ctx context.Context, client keySlotRemover, name string, roles []string) error {
+	result, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	for _, role := range slices.Sorted(maps.Keys(result.ByContainerRole)) {
+		volume := result.ByContainerRole[role]
+		if _, ok := volume.KeySlots[name]; !ok || !slices.Contains(roles, role) {
+			continue
+		}
+
+		res, err := client.RemoveSystemVolumeKeySlots(ctx, volume.Name, []snapd.VolumeKeySlot{{Name: name}})
+		if err != nil {
+			return fmt.Errorf("failed to remove keyslot %q from %s: %w", name, role, err)
+		}
+		if !res.IsOK() {
+			return fmt.Errorf("unable to remove keyslot %q from %s: %s", name, role, res.Status)
+		}
+	}
+
+	return nil
+}
+
+// missingKeySlot returns the container roles of the volumes without the named keyslot.
+func missingKeySlot(ctx context.Context, client keySlotRemover, name string) ([]string, error) {
+	result, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	var roles []string
+	for _, role := range slices.Sorted(maps.Keys(result.ByContainerRole)) {
+		if _, ok := result.ByContainerRole[role].KeySlots[name]; !ok {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
@@ -0,0 +1,184 @@
+package tpm_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/testutils"
+	"snap-tpmctl/internal/tpm"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	journal := tpm.NewJournal(stateDir)
+
+	pending, err := journal.Pending()
+	be.Err(t, err, nil)
+	be.Equal(t, 0, len(pending))
+
+	op, err := journal.Begin(tpm.OperationCreateKey, "my-key", tpm.StepGenerateRecoveryKey, tpm.StepAddRecoveryKey)
+	be.Err(t, err, nil)
+
+	fi, err := os.Stat(filepath.Join(stateDir, "journal", op.ID+".json"))
+	be.Err(t, err, nil)
+	be.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	be.Err(t, op.Complete(tpm.StepGenerateRecoveryKey), nil)
+	err = op.Fail(tpm.StepAddRecoveryKey, errors.New("snapd went away"))
+	var opErr *tpm.OperationError
+	be.True(t, errors.As(err, &opErr))
+	be.Equal(t, tpm.StepAddRecoveryKey, opErr.Step)
+
+	got, err := journal.Get(op.ID)
+	be.Err(t, err, nil)
+	be.Equal(t, tpm.OperationCreateKey, got.Kind)
+	be.Equal(t, "my-key", got.KeySlot)
+	be.Equal(t, tpm.StepDone, got.StepStatus(tpm.StepGenerateRecoveryKey))
+	be.Equal(t, tpm.StepFailed, got.StepStatus(tpm.StepAddRecoveryKey))
+	be.Equal(t, "snapd went away", got.Steps[1].Error)
+
+	be.Err(t, op.Complete("unknown-step"))
+
+	pending, err = journal.Pending()
+	be.Err(t, err, nil)
+	be.Equal(t, 1, len(pending))
+
+	be.Err(t, op.Finish(), nil)
+	pending, err = journal.Pending()
+	be.Err(t, err, nil)
+	be.Equal(t, 0, len(pending))
+
+	_, err = journal.Get(op.ID)
+	be.Err(t, err)
+	_, err = journal.Get("../keys")
+	be.Err(t, err)
+}
+
+func TestRollback(t *testing.T) {
+	t.Parallel()
+
+	bothRoles := []string{"system-data", "system-save"}
+
+	tests := map[string]struct {
+		kind         tpm.OperationKind
+		addTargets   []string
+		addCompleted bool
+
+		enumerateFails   bool
+		removeSlotsFails bool
+
+		wantRemoved []string
+		wantErr     bool
+	}{
+		"Removes half-added recovery key": {
+			kind:        tpm.OperationCreateKey,
+			addTargets:  bothRoles,
+			wantRemoved: []string{"RemoveSystemVolumeKeySlots ubuntu-data additional-recovery", "RemoveSystemVolumeKeySlots ubuntu-save additional-recovery"},
+		},
+		"Keeps keyslots existing before the operation": {
+			kind:        tpm.OperationCreateKey,
+			addTargets:  []string{"system-save"},
+			wantRemoved: []string{"RemoveSystemVolumeKeySlots ubuntu-save additional-recovery"},
+		},
+		"Keeps keyslots when the key was not added anywhere": {kind: tpm.OperationCreateKey, enumerateFails: true},
+		"Discards completed create-key operation":            {kind: tpm.OperationCreateKey, addTargets: bothRoles, addCompleted: true, removeSlotsFails: true},
+		"Discards unfinished regenerate operation":           {kind: tpm.OperationRegenerateKey, enumerateFails: true},
+
+		"Error when enumerate fails":    {kind: tpm.OperationCreateKey, addTargets: bothRoles, enumerateFails: true, wantErr: true},
+		"Error when remove slots fails": {kind: tpm.OperationCreateKey, addTargets: bothRoles, removeSlotsFails: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnumerateError:      tc.enumerateFails,
+				RemoveKeySlotsError: tc.removeSlotsFails,
+			})
+			journal := tpm.NewJournal(t.TempDir())
+
+			op, err := journal.Begin(tc.kind, "additional-recovery", tpm.StepGenerateRecoveryKey, tpm.StepAddRecoveryKey)
+			be.Err(t, err, nil)
+			op.AddTargets = tc.addTargets
+			if tc.addCompleted {
+				be.Err(t, op.Complete(tpm.StepAddRecoveryKey), nil)
+			}
+
+			err = tpm.Rollback(ctx, mockClient, op)
+
+			pending, jErr := journal.Pending()
+			be.Err(t, jErr, nil)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				be.Equal(t, 1, len(pending))
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, 0, len(pending))
+			be.Equal(t, mockClient.Calls(), tc.wantRemoved)
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		kind tpm.OperationKind
+
+		removeSlotsFails bool
+
+		wantErr bool
+	}{
+		"Resumes create-key operation":     {kind: tpm.OperationCreateKey},
+		"Resumes regenerate-key operation": {kind: tpm.OperationRegenerateKey},
+
+		"Error when rollback fails":  {kind: tpm.OperationCreateKey, removeSlotsFails: true, wantErr: true},
+		"Error on unknown operation": {kind: "unknown", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				RemoveKeySlotsError: tc.removeSlotsFails,
+			})
+			stateDir := t.TempDir()
+			journal := tpm.NewJournal(stateDir)
+			registry := tpm.NewRegistry(stateDir)
+
+			op, err := journal.Begin(tc.kind, "additional-recovery", tpm.StepGenerateRecoveryKey)
+			be.Err(t, err, nil)
+			op.Metadata = tpm.KeyMetadata{Operator: "alice", Purpose: "helpdesk"}
+			op.AddTargets = []string{"system-data"}
+
+			res, err := tpm.Resume(ctx, mockClient, journal, registry, op)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, "12345-54321-12345-54321-12345-54321-12345-54321", res.RecoveryKey)
+
+			pending, err := journal.Pending()
+			be.Err(t, err, nil)
+			be.Equal(t, 0, len(pending))
+
+			keys, err := registry.Keys()
+			be.Err(t, err, nil)
+			be.Equal(t, op.Metadata, keys["additional-recovery"].KeyMetadata)
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package tpm
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 
+	"snap-tpmctl/internal/log"
 	"snap-tpmctl/internal/snapd"
 )
 
@@ -11,6 +14,20 @@ import (
 type keyCreator interface {
 	GenerateRecoveryKey(ctx context.Context) (*snapd.GenerateRecoveryKeyResult, error)
 	AddRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+	keySlotRemover
+}
+
+// keyRegenerator defines the interface for snapd operations needed for key regeneration.
+type keyRegenerator interface {
+	GenerateRecoveryKey(ctx context.Context) (*snapd.GenerateRecoveryKeyResult, error)
+	ReplaceRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+}
+
+// keyResumer defines the interface for snapd operations needed to resume unfinished operations.
+type keyResumer interface {
+	keyCreator
+	keyRegenerator
+	keySlotRemover
 }
 
 // CreateKeyResult contains the result of creating a recovery key.
@@ -21,18 +38,48 @@ type CreateKeyResult struct {
 }
 
 // CreateKey creates a new recovery key with the given name. Input should be validated using ValidateRecoveryKeyName first.
-func CreateKey(ctx context.Context, client keyCreator, recoveryKeyName string) (result *CreateKeyResult, err error) {
+// Each step is recorded in the journal, so that a half-added key can be rolled back.
+// Once added, the key is recorded in the registry with its metadata.
+func CreateKey(ctx context.Context, client keyCreator, journal *Journal, registry *Registry, recoveryKeyName string, meta KeyMetadata) (result *CreateKeyResult, err error) {
+	op, err := journal.Begin(OperationCreateKey, recoveryKeyName, StepGenerateRecoveryKey, StepAddRecoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	op.Metadata = meta
+
 	key, err := client.GenerateRecoveryKey(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+		return nil, op.Fail(StepGenerateRecoveryKey, fmt.Errorf("failed to generate recovery key: %w", err))
+	}
+
+	op.KeyID = key.KeyID
+	if err := op.Complete(StepGenerateRecoveryKey); err != nil {
+		return nil, err
+	}
+
+	// Record where the key may be added before adding it, so that a rollback only removes
+	// the keyslots created by this operation.
+	op.AddTargets, err = missingKeySlot(ctx, client, recoveryKeyName)
+	if err != nil {
+		return nil, op.Fail(StepAddRecoveryKey, err)
+	}
+	if err := op.save(); err != nil {
+		return nil, err
 	}
 
 	keySlots := []snapd.KeySlot{{Name: recoveryKeyName}}
 
 	resp, err := client.AddRecoveryKey(ctx, key.KeyID, keySlots)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add recovery key: %w", err)
+		return nil, op.Fail(StepAddRecoveryKey, fmt.Errorf("failed to add recovery key: %w", err))
 	}
+	if !resp.IsOK() {
+		return nil, op.Fail(StepAddRecoveryKey, fmt.Errorf("unable to add recovery key: %s", resp.Err))
+	}
+
+	finishOperation(ctx, op, StepAddRecoveryKey)
+
+	recordKey(ctx, registry, recoveryKeyName, key, meta)
 
 	return &CreateKeyResult{
 		RecoveryKey: key.RecoveryKey,
@@ -40,3 +87,91 @@ func CreateKey(ctx context.Context, client keyCreator, recoveryKeyName string) (
 		Status:      resp.Status,
 	}, nil
 }
+
+// RegenerateKey replaces the recovery key of the given keyslot with a newly generated one.
+// If no keyslot name is given, snapd replaces the default recovery keyslots.
+//
+// The key is only returned once snapd replaced it. If the replacement failed, the key is
+// still returned along with the error, as it may already be in use on some keyslots.
+func RegenerateKey(ctx context.Context, client keyRegenerator, journal *Journal, registry *Registry, recoveryKeyName string, meta KeyMetadata) (*CreateKeyResult, error) {
+	op, err := journal.Begin(OperationRegenerateKey, recoveryKeyName, StepGenerateRecoveryKey, StepReplaceRecoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	op.Metadata = meta
+
+	key, err := client.GenerateRecoveryKey(ctx)
+	if err != nil {
+		return nil, op.Fail(StepGenerateRecoveryKey, fmt.Errorf("failed to generate recovery key: %w", err))
+	}
+
+	op.KeyID = key.KeyID
+	if err := op.Complete(StepGenerateRecoveryKey); err != nil {
+		return nil, err
+	}
+
+	result := &CreateKeyResult{
+		RecoveryKey: key.RecoveryKey,
+		KeyID:       key.KeyID,
+	}
+
+	var keySlots []snapd.KeySlot
+	if recoveryKeyName != "" {
+		keySlots = []snapd.KeySlot{{Name: recoveryKeyName}}
+	}
+
+	resp, err := client.ReplaceRecoveryKey(ctx, key.KeyID, keySlots)
+	if err != nil {
+		return result, op.Fail(StepReplaceRecoveryKey, fmt.Errorf("failed to replace recovery key: %w", err))
+	}
+	if !resp.IsOK() {
+		return result, op.Fail(StepReplaceRecoveryKey, fmt.Errorf("unable to replace recovery key: %s", resp.Err))
+	}
+
+	finishOperation(ctx, op, StepReplaceRecoveryKey)
+
+	recordKey(ctx, registry, cmp.Or(recoveryKeyName, DefaultRecoveryKeySlot), key, meta)
+
+	result.Status = resp.Status
+
+	return result, nil
+}
+
+// Resume rolls back an unfinished operation and runs it again from the start.
+// Generated keys are never stored in the journal, so an interrupted operation cannot
+// simply continue with the key it generated.
+func Resume(ctx context.Context, client keyResumer, journal *Journal, registry *Registry, op *Operation) (*CreateKeyResult, error) {
+	if err := Rollback(ctx, client, op); err != nil {
+		return nil, err
+	}
+
+	switch op.Kind {
+	case OperationCreateKey:
+		return CreateKey(ctx, client, journal, registry, op.KeySlot, op.Metadata)
+	case OperationRegenerateKey:
+		return RegenerateKey(ctx, client, journal, registry, op.KeySlot, op.Metadata)
+	default:
+		return nil, fmt.Errorf("cannot resume unknown operation %q", op.Kind)
+	}
+}
+
+// finishOperation marks the last step of an operation as done and removes it from the journal.
+// The key is already in use and must be returned, so failing to do so only warns. The step is
+// marked as done first, so that a leftover journal entry is never rolled back.
+func finishOperation(ctx context.Context, op *Operation, step string) {
+	if err := errors.Join(op.Complete(step), op.Finish()); err != nil {
+		log.Warningf(ctx, "Recovery key %s is in use but operation %s could not be removed from the journal: %v", op.KeyID, op.ID, err)
+	}
+}
+
+// recordKey records a key in use in the registry. The key is already in use, so failing to
+// record it only warns.
+func recordKey(ctx context.Context, registry *Registry, keySlot string, key *snapd.GenerateRecoveryKeyResult, meta KeyMetadata) {
+	rec, err := NewKeyRecord(keySlot, key.KeyID, key.RecoveryKey, nil, meta)
+	if err == nil {
+		err = registry.Record(rec)
+	}
+	if err != nil {
+		log.Warningf(ctx, "Recovery key %s is in use but could not be recorded in the key registry: %v", key.KeyID, err)
+	}
+}
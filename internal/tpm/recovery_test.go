@@ -2,9 +2,13 @@ package tpm_test
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/snapd"
 	"snap-tpmctl/internal/testutils"
 	"snap-tpmctl/internal/tpm"
 )
@@ -15,10 +19,14 @@ func TestCreateKey(t *testing.T) {
 	tests := map[string]struct {
 		recoveryKeyName string
 
-		generateKeyFails bool
-		addKeyFails      bool
+		generateKeyFails    bool
+		enumerateFails      bool
+		addKeyFails         bool
+		missingSaveRecovery bool
 
-		wantErr bool
+		wantFailedStep string
+		wantAddTargets []string
+		wantErr        bool
 	}{
 		"Success": {
 			recoveryKeyName: "my-key",
@@ -26,13 +34,30 @@ func TestCreateKey(t *testing.T) {
 		"Error when generate key fails": {
 			recoveryKeyName:  "my-key",
 			generateKeyFails: true,
+			wantFailedStep:   tpm.StepGenerateRecoveryKey,
 			wantErr:          true,
 		},
+		"Error when enumerate fails": {
+			recoveryKeyName: "my-key",
+			enumerateFails:  true,
+			wantFailedStep:  tpm.StepAddRecoveryKey,
+			wantErr:         true,
+		},
 		"Error when add key fails": {
 			recoveryKeyName: "my-key",
 			addKeyFails:     true,
+			wantFailedStep:  tpm.StepAddRecoveryKey,
+			wantAddTargets:  []string{"system-data", "system-save"},
 			wantErr:         true,
 		},
+		"Error when add key fails on a keyslot of some volumes": {
+			recoveryKeyName:     "additional-recovery",
+			addKeyFails:         true,
+			missingSaveRecovery: true,
+			wantFailedStep:      tpm.StepAddRecoveryKey,
+			wantAddTargets:      []string{"system-save"},
+			wantErr:             true,
+		},
 	}
 
 	for name, tc := range tests {
@@ -41,20 +66,177 @@ func TestCreateKey(t *testing.T) {
 
 			ctx := context.Background()
 			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
-				GenerateKeyError: tc.generateKeyFails,
-				AddKeyError:      tc.addKeyFails,
+				GenerateKeyError:    tc.generateKeyFails,
+				EnumerateError:      tc.enumerateFails,
+				AddKeyError:         tc.addKeyFails,
+				MissingSaveRecovery: tc.missingSaveRecovery,
 			})
+			stateDir := t.TempDir()
+			journal := tpm.NewJournal(stateDir)
+			registry := tpm.NewRegistry(stateDir)
+			meta := tpm.KeyMetadata{Operator: "alice", Purpose: "helpdesk"}
+
+			res, err := tpm.CreateKey(ctx, mockClient, journal, registry, tc.recoveryKeyName, meta)
 
-			res, err := tpm.CreateKey(ctx, mockClient, tc.recoveryKeyName)
+			pending, jErr := journal.Pending()
+			be.Err(t, jErr, nil)
 
 			if tc.wantErr {
 				be.Err(t, err)
+
+				var opErr *tpm.OperationError
+				be.True(t, errors.As(err, &opErr))
+				be.Equal(t, tc.wantFailedStep, opErr.Step)
+				be.Equal(t, 1, len(pending))
+				be.Equal(t, tpm.StepFailed, pending[0].StepStatus(tc.wantFailedStep))
+				be.Equal(t, meta, pending[0].Metadata)
+				be.Equal(t, tc.wantAddTargets, pending[0].AddTargets)
+
+				keys, err := registry.Keys()
+				be.Err(t, err, nil)
+				be.Equal(t, 0, len(keys))
 				return
 			}
 			be.Err(t, err, nil)
 			be.Equal(t, "test-key-id-12345", res.KeyID)
-			be.Equal(t, "12345-67890-12345-67890-12345-67890-12345-67890", res.RecoveryKey)
+			be.Equal(t, "12345-54321-12345-54321-12345-54321-12345-54321", res.RecoveryKey)
+			be.Equal(t, "Done", res.Status)
+			be.Equal(t, 0, len(pending))
+
+			keys, err := registry.Keys()
+			be.Err(t, err, nil)
+			rec := keys[tc.recoveryKeyName]
+			be.Equal(t, "test-key-id-12345", rec.KeyID)
+			be.Equal(t, meta, rec.KeyMetadata)
+			be.True(t, rec.Matches(res.RecoveryKey))
+		})
+	}
+}
+
+func TestRegenerateKey(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		recoveryKeyName string
+
+		generateKeyFails bool
+		replaceKeyFails  bool
+
+		wantKeySlot     string
+		wantRecoveryKey bool
+		wantErr         bool
+	}{
+		"Success":                      {recoveryKeyName: "my-key", wantKeySlot: "my-key", wantRecoveryKey: true},
+		"Success with default keyslot": {wantKeySlot: tpm.DefaultRecoveryKeySlot, wantRecoveryKey: true},
+
+		"Error when generate key fails":              {recoveryKeyName: "my-key", generateKeyFails: true, wantErr: true},
+		"Error with recovery key when replace fails": {recoveryKeyName: "my-key", replaceKeyFails: true, wantRecoveryKey: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				GenerateKeyError: tc.generateKeyFails,
+				ReplaceKeyError:  tc.replaceKeyFails,
+			})
+			stateDir := t.TempDir()
+			journal := tpm.NewJournal(stateDir)
+			registry := tpm.NewRegistry(stateDir)
+
+			// The purpose of the previous key is kept.
+			prev, err := tpm.NewKeyRecord(tc.wantKeySlot, "old-key-id", "11111-11111-11111-11111-11111-11111-11111-11111", []string{"system-data"}, tpm.KeyMetadata{Operator: "bob", Purpose: "install"})
+			be.Err(t, err, nil)
+			be.Err(t, registry.Record(prev), nil)
+
+			res, err := tpm.RegenerateKey(ctx, mockClient, journal, registry, tc.recoveryKeyName, tpm.KeyMetadata{Operator: "alice"})
+
+			if tc.wantRecoveryKey {
+				be.Equal(t, "12345-54321-12345-54321-12345-54321-12345-54321", res.RecoveryKey)
+			}
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
 			be.Equal(t, "Done", res.Status)
+
+			keys, err := registry.Keys()
+			be.Err(t, err, nil)
+			rec := keys[tc.wantKeySlot]
+			be.Equal(t, "test-key-id-12345", rec.KeyID)
+			be.Equal(t, tpm.KeyMetadata{Operator: "alice", Purpose: "install"}, rec.KeyMetadata)
+			be.Equal(t, []string{"system-data"}, rec.ContainerRoles)
+			be.True(t, rec.Matches(res.RecoveryKey))
+			be.True(t, rec.Created.After(prev.Created))
 		})
 	}
 }
+
+func TestKeyIsReturnedWhenJournalCannotBeFinished(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		regenerate bool
+	}{
+		"Create key":     {},
+		"Regenerate key": {regenerate: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			stateDir := t.TempDir()
+			client := journalBreaker{
+				MockSnapdClient: testutils.NewMockSnapdClient(testutils.MockConfig{}),
+				journalDir:      filepath.Join(stateDir, "journal"),
+			}
+			journal := tpm.NewJournal(stateDir)
+			registry := tpm.NewRegistry(stateDir)
+
+			var res *tpm.CreateKeyResult
+			var err error
+			if tc.regenerate {
+				res, err = tpm.RegenerateKey(ctx, client, journal, registry, "my-key", tpm.KeyMetadata{})
+			} else {
+				res, err = tpm.CreateKey(ctx, client, journal, registry, "my-key", tpm.KeyMetadata{})
+			}
+
+			be.Err(t, err, nil)
+			be.Equal(t, "12345-54321-12345-54321-12345-54321-12345-54321", res.RecoveryKey)
+			be.Equal(t, "Done", res.Status)
+
+			keys, err := registry.Keys()
+			be.Err(t, err, nil)
+			be.True(t, keys["my-key"].Matches(res.RecoveryKey))
+		})
+	}
+}
+
+// journalBreaker replaces the journal directory with a file once snapd changed the keyslots,
+// so that the operation can neither be updated nor removed from the journal.
+type journalBreaker struct {
+	*testutils.MockSnapdClient
+	journalDir string
+}
+
+func (c journalBreaker) AddRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error) {
+	resp, err := c.MockSnapdClient.AddRecoveryKey(ctx, keyID, slots)
+	c.breakJournal()
+	return resp, err
+}
+
+func (c journalBreaker) ReplaceRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error) {
+	resp, err := c.MockSnapdClient.ReplaceRecoveryKey(ctx, keyID, slots)
+	c.breakJournal()
+	return resp, err
+}
+
+func (c journalBreaker) breakJournal() {
+	_ = os.RemoveAll(c.journalDir)
+	_ = os.WriteFile(c.journalDir, nil, 0o600)
+}
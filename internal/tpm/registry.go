@@ -0,0 +1,176 @@
+package tpm
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/fileutils"
+)
+
+// DefaultRecoveryKeySlot is the recovery keyslot snapd creates at install time, and
+// regenerates when no keyslot is given.
+const DefaultRecoveryKeySlot = "default-recovery"
+
+// registryVersion is the version of the registry file format.
+const registryVersion = 1
+
+// KeyMetadata describes by whom and why a recovery key was created.
+type KeyMetadata struct {
+	// Operator is the user who created the key, e.g. from SUDO_USER.
+	Operator string `json:"operator,omitempty"`
+	Purpose  string `json:"purpose,omitempty"`
+}
+
+// KeyRecord is the metadata of a recovery key, which snapd does not keep.
+// The recovery key itself is never recorded, only a salted fingerprint of it.
+type KeyRecord struct {
+	KeySlot string `json:"keyslot"`
+	// ContainerRoles are the volumes the key was added to, all of them if empty.
+	ContainerRoles []string  `json:"container-roles,omitempty"`
+	KeyID          string    `json:"key-id"`
+	Created        time.Time `json:"created"`
+	KeyMetadata
+
+	// Fingerprint is the fde.Fingerprint of the key, salted with FingerprintSalt, for
+	// helpdesk to check a key read out by a user without the key being recorded.
+	Fingerprint     string `json:"fingerprint,omitempty"`
+	FingerprintSalt string `json:"fingerprint-salt,omitempty"`
+}
+
+// NewKeyRecord returns the record of a recovery key created now, with a fingerprint salted
+// for this record only.
+func NewKeyRecord(keySlot, keyID, recoveryKey string, containerRoles []string, meta KeyMetadata) (KeyRecord, error) {
+	key, err := fde.ParseRecoveryKey(recoveryKey)
+	if err != nil {
+		return KeyRecord{}, fmt.Errorf("invalid recovery key: %w", err)
+	}
+	salt, err := fde.NewFingerprintSalt()
+	if err != nil {
+		return KeyRecord{}, err
+	}
+
+	return KeyRecord{
+		KeySlot:         keySlot,
+		ContainerRoles:  containerRoles,
+		KeyID:           keyID,
+		Created:         time.Now().UTC(),
+		KeyMetadata:     meta,
+		Fingerprint:     fde.Fingerprint(key, salt),
+		FingerprintSalt: hex.EncodeToString(salt),
+	}, nil
+}
+
+// KeyFingerprint returns the fingerprint of a recovery key with the salt of the record, to be
+// compared with the recorded one.
+func (r KeyRecord) KeyFingerprint(key fde.RecoveryKey) (string, error) {
+	if r.Fingerprint == "" {
+		return "", fmt.Errorf("no fingerprint recorded for keyslot %q", r.KeySlot)
+	}
+	salt, err := hex.DecodeString(r.FingerprintSalt)
+	if err != nil {
+		return "", fmt.Errorf("invalid fingerprint salt for keyslot %q: %w", r.KeySlot, err)
+	}
+
+	return fde.Fingerprint(key, salt), nil
+}
+
+// Matches reports whether the recovery key is the one of the record.
+func (r KeyRecord) Matches(recoveryKey string) bool {
+	key, err := fde.ParseRecoveryKey(recoveryKey)
+	if err != nil {
+		return false
+	}
+	fingerprint, err := r.KeyFingerprint(key)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(fingerprint), []byte(r.Fingerprint)) == 1
+}
+
+// registryFile is the format of the registry file.
+type registryFile struct {
+	Version int         `json:"version"`
+	Keys    []KeyRecord `json:"keys"`
+}
+
+// Registry records the metadata of the recovery keys created by snap-tpmctl, by keyslot.
+type Registry struct {
+	path string
+}
+
+// NewRegistry returns the registry stored under the given state directory.
+func NewRegistry(stateDir string) *Registry {
+	return &Registry{path: filepath.Join(stateDir, "keys.json")}
+}
+
+// Keys returns the records of the registry by keyslot.
+func (r *Registry) Keys() (map[string]KeyRecord, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]KeyRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key registry: %w", err)
+	}
+
+	var f registryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse key registry %s: %w", r.path, err)
+	}
+	if f.Version != registryVersion {
+		return nil, fmt.Errorf("unsupported key registry version %d in %s", f.Version, r.path)
+	}
+
+	keys := make(map[string]KeyRecord, len(f.Keys))
+	for _, k := range f.Keys {
+		keys[k.KeySlot] = k
+	}
+
+	return keys, nil
+}
+
+// Record adds the record of a key, replacing the previous record of its keyslot.
+// The purpose and container roles of the previous record are kept if the new one has none.
+func (r *Registry) Record(rec KeyRecord) error {
+	keys, err := r.Keys()
+	if err != nil {
+		return err
+	}
+
+	if prev, ok := keys[rec.KeySlot]; ok {
+		if rec.Purpose == "" {
+			rec.Purpose = prev.Purpose
+		}
+		if len(rec.ContainerRoles) == 0 {
+			rec.ContainerRoles = prev.ContainerRoles
+		}
+	}
+	keys[rec.KeySlot] = rec
+
+	f := registryFile{Version: registryVersion}
+	for _, k := range keys {
+		f.Keys = append(f.Keys, k)
+	}
+	slices.SortFunc(f.Keys, func(a, b KeyRecord) int {
+		return strings.Compare(a.KeySlot, b.KeySlot)
+	})
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key registry: %w", err)
+	}
+	if err := fileutils.WriteFileAtomic(r.path, data); err != nil {
+		return fmt.Errorf("failed to write key registry: %w", err)
+	}
+
+	return nil
+}
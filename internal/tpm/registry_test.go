@@ -0,0 +1,135 @@
+package tpm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/fde"
+	"snap-tpmctl/internal/tpm"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+
+		wantKeys []string
+		wantErr  string
+	}{
+		"Empty when missing": {},
+		"Reads records by keyslot": {
+			content:  `{"version": 1, "keys": [{"keyslot": "b", "key-id": "2"}, {"keyslot": "a", "key-id": "1"}]}`,
+			wantKeys: []string{"a", "b"},
+		},
+
+		"Error when file is corrupted":      {content: `{"version": 1, "keys": [`, wantErr: "failed to parse key registry"},
+		"Error when version is unsupported": {content: `{"version": 2, "keys": []}`, wantErr: "unsupported key registry version 2"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			if tc.content != "" {
+				be.Err(t, os.WriteFile(filepath.Join(dir, "keys.json"), []byte(tc.content), 0o600), nil)
+			}
+
+			keys, err := tpm.NewRegistry(dir).Keys()
+			if tc.wantErr != "" {
+				be.Err(t, err, tc.wantErr)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, len(keys), len(tc.wantKeys))
+			for _, k := range tc.wantKeys {
+				be.Equal(t, keys[k].KeySlot, k)
+			}
+		})
+	}
+}
+
+func TestRegistryRecord(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	registry := tpm.NewRegistry(dir)
+
+	const (
+		key1 = "11111-11111-11111-11111-11111-11111-11111-11111"
+		key2 = "22222-22222-22222-22222-22222-22222-22222-22222"
+		key3 = "33333-33333-33333-33333-33333-33333-33333-33333"
+	)
+
+	first, err := tpm.NewKeyRecord("my-key", "id-1", key1, []string{"system-data"}, tpm.KeyMetadata{Operator: "alice", Purpose: "helpdesk"})
+	be.Err(t, err, nil)
+	be.Err(t, registry.Record(first), nil)
+
+	other, err := tpm.NewKeyRecord("other-key", "id-2", key2, nil, tpm.KeyMetadata{})
+	be.Err(t, err, nil)
+	be.Err(t, registry.Record(other), nil)
+
+	// A new key for the same keyslot replaces the record, keeping its purpose and roles.
+	second, err := tpm.NewKeyRecord("my-key", "id-3", key3, nil, tpm.KeyMetadata{Operator: "bob"})
+	be.Err(t, err, nil)
+	be.Err(t, registry.Record(second), nil)
+
+	keys, err := registry.Keys()
+	be.Err(t, err, nil)
+	be.Equal(t, len(keys), 2)
+
+	got := keys["my-key"]
+	be.Equal(t, got.KeyID, "id-3")
+	be.Equal(t, got.KeyMetadata, tpm.KeyMetadata{Operator: "bob", Purpose: "helpdesk"})
+	be.Equal(t, got.ContainerRoles, []string{"system-data"})
+	be.True(t, got.Matches(key3))
+	be.True(t, !got.Matches(key1))
+	be.Equal(t, keys["other-key"].KeyID, "id-2")
+
+	// The registry is root-only and never holds the keys.
+	fi, err := os.Stat(filepath.Join(dir, "keys.json"))
+	be.Err(t, err, nil)
+	be.Equal(t, fi.Mode().Perm(), os.FileMode(0o600))
+	data, err := os.ReadFile(filepath.Join(dir, "keys.json"))
+	be.Err(t, err, nil)
+	for _, key := range []string{key1, key2, key3} {
+		be.True(t, !strings.Contains(string(data), key))
+	}
+}
+
+func TestKeyRecordFingerprint(t *testing.T) {
+	t.Parallel()
+
+	const key = "12345-54321-12345-54321-12345-54321-12345-54321"
+
+	a, err := tpm.NewKeyRecord("my-key", "id", key, nil, tpm.KeyMetadata{})
+	be.Err(t, err, nil)
+	b, err := tpm.NewKeyRecord("my-key", "id", key, nil, tpm.KeyMetadata{})
+	be.Err(t, err, nil)
+
+	// Each record is salted, so that the same key has a different fingerprint.
+	be.True(t, a.Fingerprint != b.Fingerprint)
+	be.True(t, a.Matches(key))
+	be.True(t, b.Matches(key))
+	be.True(t, !a.Matches("12345-54321-12345-54321-12345-54321-12345-54322"))
+	be.True(t, !a.Matches("not-a-key"))
+	be.True(t, !(tpm.KeyRecord{}).Matches(key))
+
+	parsed, err := fde.ParseRecoveryKey(key)
+	be.Err(t, err, nil)
+	got, err := a.KeyFingerprint(parsed)
+	be.Err(t, err, nil)
+	be.Equal(t, got, a.Fingerprint)
+
+	_, err = (tpm.KeyRecord{KeySlot: "my-key"}).KeyFingerprint(parsed)
+	be.Err(t, err, `no fingerprint recorded for keyslot "my-key"`)
+	_, err = (tpm.KeyRecord{Fingerprint: a.Fingerprint, FingerprintSalt: "zz"}).KeyFingerprint(parsed)
+	be.Err(t, err, "invalid fingerprint salt")
+
+	_, err = tpm.NewKeyRecord("my-key", "id", "not-a-key", nil, tpm.KeyMetadata{})
+	be.Err(t, err, "invalid recovery key")
+}
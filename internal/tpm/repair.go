@@ -0,0 +1,152 @@
+package tpm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"snap-tpmctl/internal/snapd"
+)
+
+// repairer defines the interface for snapd operations needed to repair keyslots.
+type repairer interface {
+	GenerateRecoveryKey(ctx context.Context) (*snapd.GenerateRecoveryKeyResult, error)
+	AddRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+	ReplaceRecoveryKey(ctx context.Context, keyID string, slots []snapd.KeySlot) (*snapd.AsyncResponse, error)
+	ReplacePlatformKey(ctx context.Context, authMode snapd.AuthMode, pin, passphrase string, kdf snapd.KDFOptions) (*snapd.AsyncResponse, error)
+}
+
+// RepairActionKind identifies the snapd operation used to repair keyslots.
+type RepairActionKind string
+
+// Actions computed by PlanRepair.
+const (
+	RepairReplacePlatformKey RepairActionKind = "replace-platform-key"
+	RepairRecoveryKey        RepairActionKind = "restore-recovery-key"
+)
+
+// RepairAction describes a single step restoring symmetry between keyslots.
+type RepairAction struct {
+	Kind RepairActionKind
+
+	// AuthMode is the auth mode the platform keys are resealed with.
+	AuthMode snapd.AuthMode
+
+	// KeySlot is the recovery keyslot to restore.
+	KeySlot string
+	// ContainerRoles are the roles the recovery keyslot currently exists in.
+	ContainerRoles []string
+	// MissingContainerRoles are the roles the recovery keyslot must be added to.
+	MissingContainerRoles []string
+}
+
+// String returns a human readable description of the action.
+func (a RepairAction) String() string {
+	switch a.Kind {
+	case RepairReplacePlatformKey:
+		return fmt.Sprintf("replace the platform keys of all volumes with auth mode %s", a.AuthMode)
+	case RepairRecoveryKey:
+		return fmt.Sprintf("generate a new recovery key for keyslot %q, add it to %s and replace it in %s",
+			a.KeySlot, strings.Join(a.MissingContainerRoles, ", "), strings.Join(a.ContainerRoles, ", "))
+	default:
+		return string(a.Kind)
+	}
+}
+
+// RepairResult contains the result of applying a repair action.
+type RepairResult struct {
+	// RecoveryKey is set when a new recovery key was generated and must be shown to the user.
+	RecoveryKey string
+	KeyID       string
+}
+
+// PlanRepair computes the snapd actions needed to fix the issues of the report.
+func PlanRepair(report *ConsistencyReport) []RepairAction {
+	var actions []RepairAction
+
+	var platformKeyRepaired bool
+	for _, issue := range report.Issues {
+		switch issue.Kind {
+		case IssueAuthModeMismatch, IssueMissingFallback:
+			// Replacing the platform key reseals default and default-fallback on all volumes at once.
+			if platformKeyRepaired {
+				continue
+			}
+			platformKeyRepaired = true
+			actions = append(actions, RepairAction{
+				Kind:     RepairReplacePlatformKey,
+				AuthMode: report.AuthMode,
+			})
+		case IssueMissingRecoveryKey:
+			existingRole := containerRoleSystemData
+			if issue.ContainerRole == containerRoleSystemData {
+				existingRole = containerRoleSystemSave
+			}
+			actions = append(actions, RepairAction{
+				Kind:                  RepairRecoveryKey,
+				KeySlot:               issue.KeySlot,
+				ContainerRoles:        []string{existingRole},
+				MissingContainerRoles: []string{issue.ContainerRole},
+			})
+		}
+	}
+
+	return actions
+}
+
+// ApplyRepair applies a single repair action.
+// The secret is the PIN or passphrase to reseal the platform keys with, depending on the action auth mode.
+//
+// When a new recovery key was already added to some keyslots before a failure, the result
+// is returned along with the error so that the key can still be shown to the user.
+func ApplyRepair(ctx context.Context, client repairer, action RepairAction, secret string) (*RepairResult, error) {
+	switch action.Kind {
+	case RepairReplacePlatformKey:
+		return &RepairResult{}, SetAuthMode(ctx, client, action.AuthMode, secret, snapd.KDFOptions{})
+	case RepairRecoveryKey:
+		return repairRecoveryKey(ctx, client, action)
+	default:
+		return nil, fmt.Errorf("unknown repair action %q", action.Kind)
+	}
+}
+
+func repairRecoveryKey(ctx context.Context, client repairer, action RepairAction) (*RepairResult, error) {
+	key, err := client.GenerateRecoveryKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+
+	// Add the key where it is missing first, so that a failure leaves the existing keyslots untouched.
+	ares, err := client.AddRecoveryKey(ctx, key.KeyID, keySlotsForRoles(action.KeySlot, action.MissingContainerRoles))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add recovery key: %w", err)
+	}
+	if !ares.IsOK() {
+		return nil, fmt.Errorf("unable to add recovery key: %s", ares.Err)
+	}
+
+	result := &RepairResult{
+		RecoveryKey: key.RecoveryKey,
+		KeyID:       key.KeyID,
+	}
+
+	ares, err = client.ReplaceRecoveryKey(ctx, key.KeyID, keySlotsForRoles(action.KeySlot, action.ContainerRoles))
+	if err != nil {
+		return result, fmt.Errorf("recovery key added to %s but failed to replace it in %s: %w",
+			strings.Join(action.MissingContainerRoles, ", "), strings.Join(action.ContainerRoles, ", "), err)
+	}
+	if !ares.IsOK() {
+		return result, fmt.Errorf("recovery key added to %s but unable to replace it in %s: %s",
+			strings.Join(action.MissingContainerRoles, ", "), strings.Join(action.ContainerRoles, ", "), ares.Err)
+	}
+
+	return result, nil
+}
+
+func keySlotsForRoles(name string, roles []string) []snapd.KeySlot {
+	keySlots := make([]snapd.KeySlot, 0, len(roles))
+	for _, role := range roles {
+		keySlots = append(keySlots, snapd.KeySlot{Name: name, ContainerRole: role})
+	}
+	return keySlots
+}
@@ -0,0 +1,133 @@
+package tpm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/snapd"
+	"snap-tpmctl/internal/testutils"
+	"snap-tpmctl/internal/tpm"
+)
+
+func TestPlanRepair(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		fallbackAuthMode    string
+		missingDataFallback bool
+		missingSaveRecovery bool
+
+		want []tpm.RepairAction
+	}{
+		"Nothing to do on consistent state": {},
+
+		"Replaces platform key once on divergent auth modes": {
+			fallbackAuthMode: "none",
+			want:             []tpm.RepairAction{{Kind: tpm.RepairReplacePlatformKey, AuthMode: snapd.AuthModePassphrase}},
+		},
+		"Replaces platform key on missing fallback": {
+			missingDataFallback: true,
+			want:                []tpm.RepairAction{{Kind: tpm.RepairReplacePlatformKey, AuthMode: snapd.AuthModePassphrase}},
+		},
+		"Restores recovery key missing on save": {
+			missingSaveRecovery: true,
+			want: []tpm.RepairAction{{
+				Kind:                  tpm.RepairRecoveryKey,
+				KeySlot:               "additional-recovery",
+				ContainerRoles:        []string{"system-data"},
+				MissingContainerRoles: []string{"system-save"},
+			}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				FallbackAuthMode:    tc.fallbackAuthMode,
+				MissingDataFallback: tc.missingDataFallback,
+				MissingSaveRecovery: tc.missingSaveRecovery,
+			})
+
+			report, err := tpm.CheckConsistency(ctx, mockClient)
+			be.Err(t, err, nil)
+
+			got := tpm.PlanRepair(report)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestApplyRepair(t *testing.T) {
+	t.Parallel()
+
+	recoveryAction := tpm.RepairAction{
+		Kind:                  tpm.RepairRecoveryKey,
+		KeySlot:               "additional-recovery",
+		ContainerRoles:        []string{"system-data"},
+		MissingContainerRoles: []string{"system-save"},
+	}
+
+	tests := map[string]struct {
+		action tpm.RepairAction
+
+		generateKeyFails        bool
+		addKeyFails             bool
+		replaceKeyFails         bool
+		replacePlatformKeyFails bool
+
+		wantRecoveryKey string
+		wantErr         bool
+	}{
+		"Replaces platform key": {
+			action: tpm.RepairAction{Kind: tpm.RepairReplacePlatformKey, AuthMode: snapd.AuthModePin},
+		},
+		"Restores recovery key": {
+			action:          recoveryAction,
+			wantRecoveryKey: "12345-54321-12345-54321-12345-54321-12345-54321",
+		},
+
+		"Error when replace platform key fails": {
+			action:                  tpm.RepairAction{Kind: tpm.RepairReplacePlatformKey, AuthMode: snapd.AuthModeNone},
+			replacePlatformKeyFails: true,
+			wantErr:                 true,
+		},
+		"Error when generate key fails": {action: recoveryAction, generateKeyFails: true, wantErr: true},
+		"Error when add key fails":      {action: recoveryAction, addKeyFails: true, wantErr: true},
+		"Error with recovery key when replace key fails": {
+			action:          recoveryAction,
+			replaceKeyFails: true,
+			wantRecoveryKey: "12345-54321-12345-54321-12345-54321-12345-54321",
+			wantErr:         true,
+		},
+		"Error on unknown action": {action: tpm.RepairAction{Kind: "unknown"}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				GenerateKeyError:        tc.generateKeyFails,
+				AddKeyError:             tc.addKeyFails,
+				ReplaceKeyError:         tc.replaceKeyFails,
+				ReplacePlatformKeyError: tc.replacePlatformKeyFails,
+			})
+
+			res, err := tpm.ApplyRepair(ctx, mockClient, tc.action, "123456")
+
+			if tc.wantRecoveryKey != "" {
+				be.Equal(t, tc.wantRecoveryKey, res.RecoveryKey)
+			}
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package tpm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsEnterpriseKeySlot reports whether the keyslot is reserved for escrowed enterprise keys.
+func IsEnterpriseKeySlot(name string) bool {
+	return strings.HasPrefix(name, "enterprise")
+}
+
+// ParseKeyAge parses a key age as a number of days like 180d, of weeks like 26w, or a
+// duration like 4320h.
+func ParseKeyAge(s string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+
+	var age time.Duration
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid key age %q: expected a number of days like 180d, of weeks like 26w, or a duration like 4320h", s)
+		}
+		age = time.Duration(n) * unit
+	} else {
+		var err error
+		if age, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid key age %q: expected a number of days like 180d, of weeks like 26w, or a duration like 4320h", s)
+		}
+	}
+
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid key age %q: must be positive", s)
+	}
+
+	return age, nil
+}
+
+// KeyAge is a recovery keyslot with the creation time of its key, from the registry.
+type KeyAge struct {
+	KeySlot string
+	// ContainerRoles are the volumes having the keyslot, sorted.
+	ContainerRoles []string
+	// KeyID and Created are empty when the registry has no record of the keyslot.
+	KeyID   string
+	Created time.Time
+}
+
+// Known reports whether the creation time of the key is known.
+func (k KeyAge) Known() bool {
+	return !k.Created.IsZero()
+}
+
+// IsStale reports whether the key is older than maxAge at now. Keys of unknown age are not.
+func (k KeyAge) IsStale(maxAge time.Duration, now time.Time) bool {
+	return k.Known() && now.Sub(k.Created) > maxAge
+}
+
+// RecoveryKeyAges returns the recovery keyslots of all volumes, sorted by name, with the
+// creation time of their key from the registry.
+func RecoveryKeyAges(ctx context.Context, client keySlotEnumerator, registry *Registry) ([]KeyAge, error) {
+	result, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	records, err := registry.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*KeyAge)
+	for role, volume := range result.ByContainerRole {
+		for name, slot := range volume.KeySlots {
+			if slot.Type != keySlotTypeRecovery {
+				continue
+			}
+			k, ok := byName[name]
+			if !ok {
+				rec := records[name]
+				k = &KeyAge{KeySlot: name, KeyID: rec.KeyID, Created: rec.Created}
+				byName[name] = k
+			}
+			k.ContainerRoles = append(k.ContainerRoles, role)
+		}
+	}
+
+	ages := make([]KeyAge, 0, len(byName))
+	for _, k := range byName {
+		slices.Sort(k.ContainerRoles)
+		ages = append(ages, *k)
+	}
+	slices.SortFunc(ages, func(a, b KeyAge) int {
+		return strings.Compare(a.KeySlot, b.KeySlot)
+	})
+
+	return ages, nil
+}
@@ -0,0 +1,133 @@
+package tpm_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nalgeon/be"
+	"snap-tpmctl/internal/testutils"
+	"snap-tpmctl/internal/tpm"
+)
+
+func TestParseKeyAge(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		age string
+
+		want    time.Duration
+		wantErr bool
+	}{
+		"Days":     {age: "180d", want: 180 * 24 * time.Hour},
+		"Weeks":    {age: "26w", want: 26 * 7 * 24 * time.Hour},
+		"Duration": {age: "4320h", want: 4320 * time.Hour},
+
+		"Error when empty":         {age: "", wantErr: true},
+		"Error when unit only":     {age: "d", wantErr: true},
+		"Error when days invalid":  {age: "1.5d", wantErr: true},
+		"Error when unit unknown":  {age: "6mo", wantErr: true},
+		"Error when zero":          {age: "0d", wantErr: true},
+		"Error when negative":      {age: "-1d", wantErr: true},
+		"Error when not a number":  {age: "often", wantErr: true},
+		"Error when duration is 0": {age: "0s", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tpm.ParseKeyAge(tc.age)
+			if tc.wantErr {
+				be.Err(t, err, "invalid key age")
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, got, tc.want)
+		})
+	}
+}
+
+func TestRecoveryKeyAges(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		enumerateFails      bool
+		missingSaveRecovery bool
+
+		wantErr bool
+	}{
+		"Recovery keyslots with their age": {},
+		"Keyslot of some volumes only":     {missingSaveRecovery: true},
+
+		"Error when enumerate fails": {enumerateFails: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnumerateError:      tc.enumerateFails,
+				MissingSaveRecovery: tc.missingSaveRecovery,
+				EnterpriseKeySlot:   true,
+			})
+			registry := tpm.NewRegistry(t.TempDir())
+			rec, err := tpm.NewKeyRecord("additional-recovery", "key-id", "11111-11111-11111-11111-11111-11111-11111-11111", nil, tpm.KeyMetadata{})
+			be.Err(t, err, nil)
+			be.Err(t, registry.Record(rec), nil)
+
+			got, err := tpm.RecoveryKeyAges(context.Background(), mockClient, registry)
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+
+			wantRoles := []string{"system-data", "system-save"}
+			if tc.missingSaveRecovery {
+				wantRoles = []string{"system-data"}
+			}
+			be.Equal(t, got, []tpm.KeyAge{
+				{KeySlot: "additional-recovery", ContainerRoles: wantRoles, KeyID: "key-id", Created: rec.Created},
+				{KeySlot: "default-recovery", ContainerRoles: []string{"system-data", "system-save"}},
+				{KeySlot: "enterprise-recovery", ContainerRoles: []string{"system-data", "system-save"}},
+			})
+		})
+	}
+}
+
+func TestKeyAgeIsStale(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	maxAge := 180 * 24 * time.Hour
+
+	tests := map[string]struct {
+		created time.Time
+
+		want bool
+	}{
+		"Older than the maximum age":   {created: now.Add(-maxAge - time.Hour), want: true},
+		"Exactly the maximum age":      {created: now.Add(-maxAge)},
+		"Younger than the maximum age": {created: now.Add(-time.Hour)},
+		"Unknown age":                  {},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			k := tpm.KeyAge{KeySlot: "my-key", Created: tc.created}
+			be.Equal(t, k.IsStale(maxAge, now), tc.want)
+			be.Equal(t, k.Known(), !tc.created.IsZero())
+		})
+	}
+}
+
+func TestIsEnterpriseKeySlot(t *testing.T) {
+	t.Parallel()
+
+	be.True(t, tpm.IsEnterpriseKeySlot("enterprise-recovery"))
+	be.True(t, !tpm.IsEnterpriseKeySlot("default-recovery"))
+}
@@ -1,2 +1,5 @@
 // Package tpm manages TPM/FDE features
 package tpm
+
+// DefaultStateDir is the root-owned directory where snap-tpmctl keeps its local state.
+const DefaultStateDir = "/var/lib/snap-tpmctl"
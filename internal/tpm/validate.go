@@ -162,6 +162,11 @@ func ValidateRecoveryKeyName(ctx context.Context, client authValidator, recovery
 		return fmt.Errorf("recovery key name cannot start with 'snap' or 'default'")
 	}
 
+	// Enterprise keyslots are reserved for escrowed keys.
+	if IsEnterpriseKeySlot(recoveryKeyName) {
+		return fmt.Errorf("recovery key name cannot start with 'enterprise', which is reserved for escrowed keys")
+	}
+
 	// Recovery key name cannot already be in use.
 	result, err := client.EnumerateKeySlots(ctx)
 	if err != nil {
@@ -178,3 +183,59 @@ func ValidateRecoveryKeyName(ctx context.Context, client authValidator, recovery
 
 	return nil
 }
+
+// ParseKeySlots parses keyslot selectors in the form name[:container-role].
+func ParseKeySlots(selectors []string) ([]snapd.KeySlot, error) {
+	var keySlots []snapd.KeySlot
+	for _, selector := range selectors {
+		name, role, _ := strings.Cut(selector, ":")
+		if name == "" {
+			return nil, fmt.Errorf("invalid keyslot %q: name cannot be empty", selector)
+		}
+		if strings.Contains(role, ":") {
+			return nil, fmt.Errorf("invalid keyslot %q: expected name[:container-role]", selector)
+		}
+
+		keySlots = append(keySlots, snapd.KeySlot{Name: name, ContainerRole: role})
+	}
+
+	return keySlots, nil
+}
+
+// ValidateKeySlots validates that the keyslots exist and are platform keyslots using the given auth mode.
+// A keyslot without container role must exist in both system-data and system-save, as snapd expands it to both.
+func ValidateKeySlots(ctx context.Context, client authValidator, keySlots []snapd.KeySlot, authMode snapd.AuthMode) error {
+	if len(keySlots) == 0 {
+		return nil
+	}
+
+	result, err := client.EnumerateKeySlots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enumerate key slots: %w", err)
+	}
+
+	for _, keySlot := range keySlots {
+		roles := []string{keySlot.ContainerRole}
+		if keySlot.ContainerRole == "" {
+			roles = []string{containerRoleSystemData, containerRoleSystemSave}
+		}
+
+		for _, role := range roles {
+			volume, ok := result.ByContainerRole[role]
+			if !ok {
+				return fmt.Errorf("container role %q not found", role)
+			}
+
+			slot, ok := volume.KeySlots[keySlot.Name]
+			if !ok {
+				return fmt.Errorf("keyslot %q not found in %s", keySlot.Name, role)
+			}
+
+			if slot.Type != keySlotTypePlatform || slot.AuthMode != string(authMode) {
+				return fmt.Errorf("keyslot %q in %s does not use %s authentication", keySlot.Name, role, authMode)
+			}
+		}
+	}
+
+	return nil
+}
@@ -161,6 +161,10 @@ func TestValidateRecoveryKeyName(t *testing.T) {
 			recoveryKeyName: "default-key",
 			wantErr:         true,
 		},
+		"Error when name starts with enterprise": {
+			recoveryKeyName: "enterprise-recovery",
+			wantErr:         true,
+		},
 		"Error when name matches existing recovery Key": {
 			recoveryKeyName: "additional-recovery",
 			wantErr:         true,
@@ -250,3 +254,79 @@ func TestValidateAuthMode(t *testing.T) {
 		})
 	}
 }
+
+func TestParseKeySlots(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		selectors []string
+
+		want    []snapd.KeySlot
+		wantErr bool
+	}{
+		"No keyslots":                {},
+		"Keyslot without role":       {selectors: []string{"default"}, want: []snapd.KeySlot{{Name: "default"}}},
+		"Keyslot with role":          {selectors: []string{"default:system-data"}, want: []snapd.KeySlot{{Name: "default", ContainerRole: "system-data"}}},
+		"Multiple keyslots":          {selectors: []string{"default", "extra:system-save"}, want: []snapd.KeySlot{{Name: "default"}, {Name: "extra", ContainerRole: "system-save"}}},
+		"Error when name empty":      {selectors: []string{":system-data"}, wantErr: true},
+		"Error when too many fields": {selectors: []string{"default:system-data:extra"}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tpm.ParseKeySlots(tc.selectors)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+			be.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestValidateKeySlots(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		keySlots       []snapd.KeySlot
+		authMode       snapd.AuthMode
+		enumerateFails bool
+
+		wantErr bool
+	}{
+		"No keyslots":                {authMode: snapd.AuthModePassphrase},
+		"Keyslot in both roles":      {keySlots: []snapd.KeySlot{{Name: "default-fallback"}}, authMode: snapd.AuthModePassphrase},
+		"Keyslot with one role":      {keySlots: []snapd.KeySlot{{Name: "default", ContainerRole: "system-data"}}, authMode: snapd.AuthModePassphrase},
+		"No enumeration on no slots": {authMode: snapd.AuthModePassphrase, enumerateFails: true},
+
+		"Error when keyslot not found":        {keySlots: []snapd.KeySlot{{Name: "unknown"}}, authMode: snapd.AuthModePassphrase, wantErr: true},
+		"Error when container role not found": {keySlots: []snapd.KeySlot{{Name: "default", ContainerRole: "unknown"}}, authMode: snapd.AuthModePassphrase, wantErr: true},
+		"Error when keyslot is not platform":  {keySlots: []snapd.KeySlot{{Name: "default-recovery"}}, authMode: snapd.AuthModePassphrase, wantErr: true},
+		"Error when auth mode differs":        {keySlots: []snapd.KeySlot{{Name: "default", ContainerRole: "system-data"}}, authMode: snapd.AuthModePin, wantErr: true},
+		"Error when unprotected in one role":  {keySlots: []snapd.KeySlot{{Name: "default"}}, authMode: snapd.AuthModePassphrase, wantErr: true},
+		"Error when enumerate fails":          {keySlots: []snapd.KeySlot{{Name: "default"}}, authMode: snapd.AuthModePassphrase, enumerateFails: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			mockClient := testutils.NewMockSnapdClient(testutils.MockConfig{
+				EnumerateError: tc.enumerateFails,
+			})
+
+			err := tpm.ValidateKeySlots(ctx, mockClient, tc.keySlots, tc.authMode)
+
+			if tc.wantErr {
+				be.Err(t, err)
+				return
+			}
+			be.Err(t, err, nil)
+		})
+	}
+}
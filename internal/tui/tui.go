@@ -4,6 +4,7 @@ package tui
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -22,13 +23,23 @@ func ReadUserInput() (string, error) {
 
 // ClearLine clears the current line in the terminal.
 func ClearLine() {
-	fmt.Print("\033[1A\033[2K")
+	clearLine(os.Stdout)
+}
+
+func clearLine(w io.Writer) {
+	fmt.Fprint(w, "\033[1A\033[2K")
 }
 
 // ReadUserSecret prompts the user for sensitive input and clears the line after reading.
 func ReadUserSecret(form string) (string, error) {
-	fmt.Print(form)
-	defer ClearLine()
+	return ReadUserSecretOn(os.Stdout, form)
+}
+
+// ReadUserSecretOn is like ReadUserSecret, but writes the prompt to w.
+// This keeps stdout clean when it is used for the command output.
+func ReadUserSecretOn(w io.Writer, form string) (string, error) {
+	fmt.Fprint(w, form)
+	defer clearLine(w)
 
 	input, err := ReadUserInput()
 	if err != nil {
@@ -37,3 +48,20 @@ func ReadUserSecret(form string) (string, error) {
 
 	return input, nil
 }
+
+// Confirm asks the user a yes/no question and returns true only on an explicit yes.
+func Confirm(question string) (bool, error) {
+	fmt.Printf("%s [y/N]: ", question)
+
+	answer, err := ReadUserInput()
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}